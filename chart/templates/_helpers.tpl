@@ -60,3 +60,26 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Metric and label names of the metric schema the PrometheusRules query, read with fromYaml.
+`both` keeps every family under its v1 name with its v1 labels, so it queries like `v1`.
+With `v2` and `both` the ServiceMonitor honors labels, which keeps `container` from being renamed to `exported_container`.
+*/}}
+{{- define "chart.metricSchema" -}}
+{{- if eq .Values.metrics.schema "v2" }}
+pod: pod
+namespace: namespace
+node: node
+{{- else }}
+pod: pod_name
+namespace: pod_namespace
+node: node_name
+{{- end }}
+container: {{ ternary "exported_container" "container" (eq .Values.metrics.schema "v1") }}
+honorLabels: {{ ne .Values.metrics.schema "v1" }}
+containerLimitPercentage: ephemeral_storage_container_limit_percentage
+containerVolumeLimitPercentage: ephemeral_storage_container_volume_limit_percentage
+podLimitPercentage: ephemeral_storage_pod_limit_percentage
+thresholdBreach: ephemeral_storage_threshold_breach
+{{- end }}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/node"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	clusterUpGaugeVec          *prometheus.GaugeVec
+	clusterNodesGaugeVec       *prometheus.GaugeVec
+	clusterScrapesCounterVec   *prometheus.CounterVec
+	clusterLastSuccessGaugeVec *prometheus.GaugeVec
+)
+
+// cluster is a cluster the exporter scrapes with its node and pod collectors.
+// The name is empty unless the exporter scrapes the clusters of CLUSTERS.
+type cluster struct {
+	name      string
+	clientset kubernetes.Interface
+	node      *node.Node
+	pod       *pod.Collector
+}
+
+// defaultCluster returns the cluster of the Node and Pod collectors, scraped
+// when CLUSTERS is unset.
+func defaultCluster() *cluster {
+	return &cluster{node: &Node, pod: &Pod}
+}
+
+// startClusters builds the collectors of every cluster in clients and
+// starts their node watches once the collectors of all clusters exist.
+// Clusters whose clients could not be built are reported down and skipped.
+func startClusters(sampleInterval int64, clients []dev.Cluster, startNodeWatch func(*node.Node)) []*cluster {
+	createClusterMetrics()
+	reachable := make([]dev.Cluster, 0, len(clients))
+	for _, client := range clients {
+		if client.Err != nil {
+			clusterUpGaugeVec.With(prometheus.Labels{"cluster": client.Name}).Set(0)
+			continue
+		}
+		reachable = append(reachable, client)
+	}
+	nodes := node.NewClusterCollectors(sampleInterval, reachable)
+	pods := pod.NewClusterCollectors(sampleInterval, reachable)
+	clusters := make([]*cluster, len(reachable))
+	for i, client := range reachable {
+		clusters[i] = &cluster{name: client.Name, node: &nodes[i], pod: &pods[i]}
+		if client.Clientset != nil {
+			clusters[i].clientset = client.Clientset
+		}
+	}
+	for _, c := range clusters {
+		startNodeWatch(c.node)
+	}
+	return clusters
+}
+
+func createClusterMetrics() {
+	clusterUpGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_cluster_up",
+		Help: "Whether the apiserver of a cluster answered its readiness check, 1 when ready and 0 otherwise",
+	},
+		[]string{"cluster"},
+	)
+	prometheus.MustRegister(clusterUpGaugeVec)
+
+	clusterNodesGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_cluster_nodes",
+		Help: "Number of nodes the exporter scrapes in a cluster",
+	},
+		[]string{"cluster"},
+	)
+	prometheus.MustRegister(clusterNodesGaugeVec)
+
+	clusterScrapesCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ephemeral_storage_cluster_scrapes_total",
+		Help: "Node scrapes of a cluster by result, success or failure",
+	},
+		[]string{"cluster", "result"},
+	)
+	prometheus.MustRegister(clusterScrapesCounterVec)
+
+	clusterLastSuccessGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_cluster_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful node scrape in a cluster",
+	},
+		[]string{"cluster"},
+	)
+	prometheus.MustRegister(clusterLastSuccessGaugeVec)
+}
+
+// scrape scrapes a node for the scheduler and, when the exporter scrapes
+// several clusters, counts the scrape in the health metrics of its cluster.
+func (c *cluster) scrape(nodeName string) (float64, error) {
+	usage, err := c.setMetrics(nodeName)
+	if c.name == "" {
+		return usage, err
+	}
+	if err != nil {
+		clusterScrapesCounterVec.With(prometheus.Labels{"cluster": c.name, "result": "failure"}).Inc()
+	} else {
+		clusterScrapesCounterVec.With(prometheus.Labels{"cluster": c.name, "result": "success"}).Inc()
+		clusterLastSuccessGaugeVec.With(prometheus.Labels{"cluster": c.name}).SetToCurrentTime()
+	}
+	return usage, err
+}
+
+// checkHealth probes the apiserver of the cluster and counts its nodes every
+// sample interval until stop is closed. The cluster is down while its
+// apiserver is not ready or once one of its watches stopped.
+func (c *cluster) checkHealth(stop <-chan struct{}) {
+	interval := time.Duration(sampleInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		_, err := c.clientset.Discovery().RESTClient().Get().AbsPath("/readyz").DoRaw(ctx)
+		cancel()
+		up := 1.0
+		if err != nil {
+			log.Warn().Err(err).Msgf("Cluster %s: apiserver is not ready", c.name)
+			up = 0
+		} else if dev.ClusterFailed(c.name) {
+			// A stopped watch leaves the nodes and pods of the cluster stale.
+			up = 0
+		}
+		clusterUpGaugeVec.With(prometheus.Labels{"cluster": c.name}).Set(up)
+		clusterNodesGaugeVec.With(prometheus.Labels{"cluster": c.name}).Set(float64(c.node.Set.Cardinality()))
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
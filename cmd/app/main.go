@@ -1,17 +1,26 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/host"
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/node"
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
-	"github.com/panjf2000/ants/v2"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/record"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/replay"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/sample"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/scheduler"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/schema"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
@@ -22,6 +31,10 @@ var (
 	sampleIntervalMill int64
 	Node               node.Node
 	Pod                pod.Collector
+	Recorder           *record.Recorder
+	EmptyDirScanner    *host.EmptyDirScanner
+	DeletedFileScanner *host.DeletedFileScanner
+	FsChecker          *host.FsChecker
 )
 
 // collectorDeps holds the constructor/wiring functions used to build the
@@ -40,6 +53,14 @@ var defaultCollectorDeps = collectorDeps{
 	startNodeWatch:   (*node.Node).StartWatch,
 }
 
+// replayCollectorDeps builds the collectors without watching nodes, since a
+// replay has no cluster to watch.
+var replayCollectorDeps = collectorDeps{
+	newNodeCollector: node.NewCollector,
+	newPodCollector:  pod.NewCollector,
+	startNodeWatch:   func(*node.Node) {},
+}
+
 // startCollectors wires the node and pod collectors, starting the node
 // watch only after both collectors have been constructed. The pod collector
 // must exist before the node watch begins, since a Deployment-mode watch
@@ -51,48 +72,39 @@ func startCollectors(sampleInterval int64, deps collectorDeps) (node.Node, pod.C
 	return n, p
 }
 
-type ephemeralStorageMetrics struct {
-	Node struct {
-		NodeName string `json:"nodeName"`
-	}
-	Pods []struct {
-		PodRef struct {
-			Name      string `json:"name"`
-			Namespace string `json:"namespace"`
-		}
-		EphemeralStorage struct {
-			AvailableBytes float64 `json:"availableBytes"`
-			CapacityBytes  float64 `json:"capacityBytes"`
-			UsedBytes      float64 `json:"usedBytes"`
-			Inodes         float64 `json:"inodes"`
-			InodesFree     float64 `json:"inodesFree"`
-			InodesUsed     float64 `json:"inodesUsed"`
-		} `json:"ephemeral-storage"`
-		Containers []pod.ContainerStats `json:"containers,omitempty"`
-
-		Volumes []pod.Volume `json:"volume,omitempty"`
-	}
-}
-
-func setMetricsFromSummary(nodeName string, content []byte) error {
-	var data ephemeralStorageMetrics
-	if err := json.Unmarshal(content, &data); err != nil {
-		return fmt.Errorf("decode stats summary: %w", err)
-	}
-
-	// Evict pods absent from the stats summary for scrapeMissTolerance consecutive scrapes
-	currentPods := make([]string, 0, len(data.Pods))
-	for _, p := range data.Pods {
-		currentPods = append(currentPods, p.PodRef.Name)
+// setMetricsFromSummary streams the stats summary of nodeName from r and sets
+// the metrics of its pods once the summary was read completely, so a
+// malformed or oversized summary exports nothing. It returns the node usage in
+// percent, or -1 when the summary has no filesystem capacity.
+func (c *cluster) setMetricsFromSummary(nodeName string, r io.Reader) (float64, error) {
+	var pods []node.PodStats
+	nodeStats, err := node.DecodeSummary(r, func(p *node.PodStats) error {
+		pods = append(pods, *p)
+		return nil
+	})
+	if err != nil {
+		return -1, fmt.Errorf("decode stats summary: %w", err)
 	}
-	pod.EvictStalePods(nodeName, currentPods)
 
-	for _, p := range data.Pods {
+	currentPods := make(map[string]string, len(pods))
+	usage := -1.0
+	for _, p := range pods {
 		podName := p.PodRef.Name
 		podNamespace := p.PodRef.Namespace
-		usedBytes := p.EphemeralStorage.UsedBytes
 		availableBytes := p.EphemeralStorage.AvailableBytes
 		capacityBytes := p.EphemeralStorage.CapacityBytes
+		// Every pod carries the node filesystem, filtered pods included.
+		// Sources without filesystem capacity (CRI) leave the node metrics unset.
+		if capacityBytes > 0 {
+			c.node.SetMetrics(nodeName, availableBytes, capacityBytes)
+			usage = math.Max(capacityBytes-availableBytes, 0) * 100 / capacityBytes
+		}
+		if !c.pod.Admit(podName, podNamespace) {
+			continue
+		}
+		currentPods[podName] = podNamespace
+		sample.Observe(c.name, nodeName, podNamespace, podName, p.EphemeralStorage.Time)
+		usedBytes := p.EphemeralStorage.UsedBytes
 		inodes := p.EphemeralStorage.Inodes
 		inodesFree := p.EphemeralStorage.InodesFree
 		inodesUsed := p.EphemeralStorage.InodesUsed
@@ -100,45 +112,112 @@ func setMetricsFromSummary(nodeName string, content []byte) error {
 			log.Warn().Msg(fmt.Sprintf("pod %s/%s on %s has no metrics on its ephemeral storage usage", podName, podNamespace, nodeName))
 			continue
 		}
-		Node.SetMetrics(nodeName, availableBytes, capacityBytes)
-		Pod.SetMetrics(podName, podNamespace, nodeName, usedBytes, availableBytes, capacityBytes, inodes, inodesFree, inodesUsed, p.Volumes, p.Containers)
+		c.pod.SetMetrics(podName, podNamespace, nodeName, usedBytes, availableBytes, capacityBytes, inodes, inodesFree, inodesUsed, p.Volumes, p.Containers)
 	}
 
-	return nil
+	if nodeStats.Fs != nil {
+		sample.ObserveNode(c.name, nodeName, nodeStats.Fs.Time)
+	}
+
+	// Evict pods absent from the stats summary for scrapeMissTolerance consecutive scrapes
+	pod.EvictStalePods(c.name, nodeName, currentPods)
+
+	if nodeStats.Runtime.ImageFs != nil {
+		c.node.SetImageFsMetrics(nodeName, nodeStats.Runtime.ImageFs.UsedBytes)
+	}
+	if FsChecker != nil {
+		if nodeStats.Fs != nil {
+			FsChecker.Observe(host.NodeFs, nodeStats.Fs.AvailableBytes, nodeStats.Fs.CapacityBytes)
+		}
+		if nodeStats.Runtime.ImageFs != nil {
+			FsChecker.Observe(host.ImageFs, nodeStats.Runtime.ImageFs.AvailableBytes, nodeStats.Runtime.ImageFs.CapacityBytes)
+		}
+	}
+
+	return usage, nil
 }
 
-func setMetrics(nodeName string) {
+// setMetrics scrapes a node and returns its usage in percent for the
+// scheduler.
+func (c *cluster) setMetrics(nodeName string) (float64, error) {
 	start := time.Now()
 
-	content, err := Node.Query(nodeName)
+	body, err := c.node.Query(nodeName)
 	// Skip node query if there is an error.
 	if err != nil {
-		return
+		return -1, err
 	}
+	defer body.Close()
 
 	log.Debug().Msg(fmt.Sprintf("Fetched proxy stats from node : %s", nodeName))
-	if err := setMetricsFromSummary(nodeName, content); err != nil {
+	var summary io.Reader = body
+	var raw *bytes.Buffer
+	if Recorder != nil {
+		// Keep a copy of the raw summary while it streams through the decoder.
+		raw = node.GetBuffer()
+		defer node.PutBuffer(raw)
+		summary = io.TeeReader(body, raw)
+	}
+	usage, err := c.setMetricsFromSummary(nodeName, summary)
+	c.node.Decoded(nodeName, err)
+	if raw != nil && !errors.Is(err, node.ErrSummaryTooLarge) {
+		// Record malformed summaries in full too, they are what replay is for.
+		if _, copyErr := io.Copy(io.Discard, summary); copyErr == nil {
+			if err := Recorder.Record(nodeName, raw.Bytes()); err != nil {
+				log.Warn().Err(err).Msgf("Failed to record proxy stats from node: %s", nodeName)
+			}
+		}
+	}
+	if err != nil {
 		log.Warn().Err(err).Msgf("Failed to decode proxy stats from node: %s", nodeName)
-		return
+		return usage, err
 	}
 
 	adjustTime := sampleIntervalMill - time.Since(start).Milliseconds()
 	if adjustTime <= 0.0 {
 		log.Error().Msgf("Node %s: Polling Rate could not keep up. Adjust your Interval to a higher number than %d seconds", nodeName, sampleInterval)
 	}
-	if Node.AdjustedPollingRate {
-		node.AdjustedPollingRateGaugeVec.With(prometheus.Labels{"node_name": nodeName}).Set(float64(adjustTime))
+	c.node.SetAdjustedPollingRate(nodeName, adjustTime)
+	return usage, nil
+}
+
+// replayRecording feeds a recording through the same path as live scrapes: pod
+// manifests fill the pod lookup, then every capture is applied in order.
+func replayRecording(path string) error {
+	rec, err := replay.Load(path)
+	if err != nil {
+		return err
+	}
+	Pod.LoadPods(rec.Pods)
+	cl := defaultCluster()
+	for _, c := range rec.Captures {
+		if _, err := cl.setMetricsFromSummary(c.Node, bytes.NewReader(c.Content)); err != nil {
+			log.Warn().Err(err).Msgf("Failed to replay %s", c.Path)
+		}
 	}
+	log.Info().Msgf("Replayed %d stats summaries and %d pod manifests from %s", len(rec.Captures), len(rec.Pods), path)
+	return nil
 }
 
-func getMetrics() {
+// newScheduler returns the scheduler scraping the nodes of the cluster and
+// exits when its SCRAPE_* settings are invalid.
+func (c *cluster) newScheduler() *scheduler.Scheduler {
+	s, err := scheduler.NewScheduler(sampleInterval, c.node.MaxNodeQueryConcurrency, c.node.Set, c.scrape)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up the scrape scheduler")
+		os.Exit(1)
+	}
+	return s
+}
+
+func (c *cluster) getMetrics(s *scheduler.Scheduler) {
 	// Wait for pod initialization with a timeout to prevent deadlock
 	// If initialization takes too long, log a warning and continue anyway
 	initTimeout := time.Duration(sampleInterval*2) * time.Second
 	initDone := make(chan struct{})
 
 	go func() {
-		Pod.WaitGroup.Wait()
+		c.pod.WaitGroup.Wait()
 		close(initDone)
 	}()
 
@@ -149,21 +228,7 @@ func getMetrics() {
 		log.Warn().Msgf("Pod initialization timed out after %v, continuing anyway. Metrics may be incomplete.", initTimeout)
 	}
 
-	p, _ := ants.NewPoolWithFunc(Node.MaxNodeQueryConcurrency, func(node interface{}) {
-		setMetrics(node.(string))
-	}, ants.WithExpiryDuration(time.Duration(sampleInterval)*time.Second))
-
-	defer p.Release()
-
-	for {
-		nodeSlice := Node.Set.ToSlice()
-
-		for _, node := range nodeSlice {
-			_ = p.Invoke(node)
-		}
-
-		time.Sleep(time.Duration(sampleInterval) * time.Second)
-	}
+	s.Run(make(chan struct{}))
 }
 
 func main() {
@@ -178,13 +243,71 @@ func main() {
 	readinessTimeout := time.Duration(readinessTimeoutSeconds) * time.Second
 
 	dev.SetLogger()
-	dev.SetK8sClient()
-	Node, Pod = startCollectors(sampleInterval, defaultCollectorDeps)
+	gatherer, err := schema.NewGatherer(prometheus.DefaultGatherer)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up the metrics schema")
+		os.Exit(1)
+	}
+	if dev.MultiCluster() && (dev.DeployAsDaemonSet() || dev.GetEnv("RECORD_PATH", "") != "" || dev.ReplayPath() != "") {
+		log.Error().Msg("CLUSTERS requires DEPLOY_TYPE=Deployment and supports neither RECORD_PATH nor REPLAY_PATH")
+		os.Exit(1)
+	}
+	if replayPath := dev.ReplayPath(); replayPath != "" {
+		Node, Pod = startCollectors(sampleInterval, replayCollectorDeps)
+		if err := replayRecording(replayPath); err != nil {
+			log.Error().Err(err).Msgf("Failed to load recording %s", replayPath)
+			os.Exit(1)
+		}
+	} else if sources := dev.Clusters(); len(sources) > 0 {
+		for _, c := range startClusters(sampleInterval, dev.SetK8sClient(sources...), (*node.Node).StartWatch) {
+			s := c.newScheduler()
+			go c.checkHealth(make(chan struct{}))
+			go c.getMetrics(s)
+		}
+	} else {
+		var err error
+		if Recorder, err = record.NewRecorder(); err != nil {
+			log.Error().Err(err).Msg("Failed to set up recording")
+			os.Exit(1)
+		}
+		dev.SetK8sClient()
+		Node, Pod = startCollectors(sampleInterval, defaultCollectorDeps)
+		if EmptyDirScanner, err = host.NewEmptyDirScanner(Pod.Pods); err != nil {
+			log.Error().Err(err).Msg("Failed to set up the emptyDir scanner")
+			os.Exit(1)
+		}
+		if EmptyDirScanner != nil {
+			go EmptyDirScanner.Run(make(chan struct{}))
+		}
+		if DeletedFileScanner, err = host.NewDeletedFileScanner(Pod.Pods); err != nil {
+			log.Error().Err(err).Msg("Failed to set up the deleted file scanner")
+			os.Exit(1)
+		}
+		if DeletedFileScanner != nil {
+			go DeletedFileScanner.Run(make(chan struct{}))
+		}
+		ioStat, err := host.NewIOStatCollector(Pod.Pods)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up the container write bytes collector")
+			os.Exit(1)
+		}
+		if ioStat != nil {
+			prometheus.MustRegister(ioStat)
+		}
+		if FsChecker, err = host.NewFsChecker(); err != nil {
+			log.Error().Err(err).Msg("Failed to set up the host filesystem check")
+			os.Exit(1)
+		}
+		if FsChecker != nil {
+			go FsChecker.Run(make(chan struct{}))
+		}
+		c := defaultCluster()
+		go c.getMetrics(c.newScheduler())
+	}
 
 	if pprofEnabled {
 		go dev.EnablePprof()
 	}
-	go getMetrics()
 
 	// Health check endpoint for readiness probe - responds immediately
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -195,9 +318,10 @@ func main() {
 	})
 
 	// Metrics endpoint with timing middleware to diagnose slow responses
+	promHandler := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
 	metricsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		promhttp.Handler().ServeHTTP(w, r)
+		promHandler.ServeHTTP(w, r)
 		duration := time.Since(start)
 
 		if duration > readinessTimeout {
@@ -213,13 +337,20 @@ func main() {
 		}
 	})
 	http.Handle("/metrics", metricsHandler)
+	if Recorder != nil && Recorder.Download {
+		// Download the last captures of a node: /captures?node=<node>&last=<n>
+		http.Handle("/captures", Recorder)
+	}
+	if EmptyDirScanner != nil && EmptyDirScanner.Debug {
+		// Largest directories inside the emptyDirs of the node: /debug/emptydir?namespace=<namespace>&pod=<pod>
+		http.Handle("/debug/emptydir", EmptyDirScanner)
+	}
 	log.Info().Msg(fmt.Sprintf("Starting server listening on :%s", port))
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%s", port),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
-	err := server.ListenAndServe()
-	if err != nil {
+	if err := server.ListenAndServe(); err != nil {
 		log.Error().Msg(fmt.Sprintf("Listener Failed : %s\n", err.Error()))
 		panic(err.Error())
 	}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/node"
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/record"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 // TestStartCollectorsOrdersPodBeforeNodeWatch locks in the guarantee that
@@ -74,7 +83,7 @@ const sampleStatsSummary = `{
 }`
 
 func TestEphemeralStorageMetricsUnmarshal(t *testing.T) {
-	var data ephemeralStorageMetrics
+	var data node.Summary
 	if err := json.Unmarshal([]byte(sampleStatsSummary), &data); err != nil {
 		t.Fatalf("unmarshal failed: %v", err)
 	}
@@ -126,7 +135,7 @@ func TestEphemeralStorageMetricsUnmarshal(t *testing.T) {
 
 func TestEphemeralStorageMetricsUnmarshalMissingFields(t *testing.T) {
 	const minimal = `{"node": {"nodeName": "n1"}, "pods": [{"podRef": {"name": "p", "namespace": "ns"}}]}`
-	var data ephemeralStorageMetrics
+	var data node.Summary
 	if err := json.Unmarshal([]byte(minimal), &data); err != nil {
 		t.Fatalf("unmarshal failed: %v", err)
 	}
@@ -153,7 +162,7 @@ func TestEphemeralStorageMetricsUnmarshalMissingFields(t *testing.T) {
 
 func TestEphemeralStorageMetricsUnmarshalMalformed(t *testing.T) {
 	const bad = `{"node": "not-an-object"}`
-	var data ephemeralStorageMetrics
+	var data node.Summary
 	err := json.Unmarshal([]byte(bad), &data)
 	if err == nil {
 		t.Fatal("expected unmarshal error for malformed input, got nil")
@@ -164,7 +173,7 @@ func TestEphemeralStorageMetricsUnmarshalMalformed(t *testing.T) {
 }
 
 func TestSetMetricsFromSummaryRejectsMalformedJSON(t *testing.T) {
-	err := setMetricsFromSummary("test-node", []byte(`{"pods": [`))
+	_, err := defaultCluster().setMetricsFromSummary("test-node", strings.NewReader(`{"pods": [`))
 	if err == nil {
 		t.Fatal("expected malformed stats summary to return an error")
 	}
@@ -173,8 +182,37 @@ func TestSetMetricsFromSummaryRejectsMalformedJSON(t *testing.T) {
 	}
 }
 
+// TestSetMetricsFromMalformedSummary checks that pods decoded before the
+// malformed part of a summary are not exported.
+func TestSetMetricsFromMalformedSummary(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	origRegisterer, origGatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	prometheus.DefaultRegisterer = registry
+	prometheus.DefaultGatherer = registry
+	origNode, origPod := Node, Pod
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer = origRegisterer
+		prometheus.DefaultGatherer = origGatherer
+		Node, Pod = origNode, origPod
+	})
+
+	t.Setenv("DEPLOY_TYPE", "Deployment")
+	t.Setenv("EPHEMERAL_STORAGE_POD_USAGE", "true")
+	t.Setenv("EPHEMERAL_STORAGE_NODE_AVAILABLE", "true")
+	Node = node.NewCollector(1)
+	Pod = pod.NewCollector(1)
+
+	truncated := sampleStatsSummary[:strings.LastIndex(sampleStatsSummary, "]")]
+	if _, err := defaultCluster().setMetricsFromSummary("test-node-01", strings.NewReader(truncated)); err == nil {
+		t.Fatal("expected a truncated stats summary to return an error")
+	}
+	if n, err := testutil.GatherAndCount(registry, "ephemeral_storage_node_available", "ephemeral_storage_pod_usage"); err != nil || n != 0 {
+		t.Errorf("exported %d series from a truncated summary (%v), want none", n, err)
+	}
+}
+
 func TestEphemeralStorageMetricsUnmarshalEmpty(t *testing.T) {
-	var data ephemeralStorageMetrics
+	var data node.Summary
 	if err := json.Unmarshal([]byte(`{}`), &data); err != nil {
 		t.Fatalf("unmarshal empty: %v", err)
 	}
@@ -201,7 +239,7 @@ func TestEphemeralStorageMetricsUnmarshalContainers(t *testing.T) {
 	    ]
 	  }]
 	}`
-	var data ephemeralStorageMetrics
+	var data node.Summary
 	if err := json.Unmarshal([]byte(withContainers), &data); err != nil {
 		t.Fatalf("unmarshal: %v", err)
 	}
@@ -229,3 +267,185 @@ func TestEphemeralStorageMetricsUnmarshalContainers(t *testing.T) {
 		t.Errorf("logs available = %v, want 50", c.Logs.AvailableBytes)
 	}
 }
+
+// fakeStatsSource serves a canned stats summary so setMetrics can be driven
+// without a Kubernetes API server.
+type fakeStatsSource struct {
+	content []byte
+}
+
+func (f fakeStatsSource) Summary(string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+func TestSetMetricsFromStatsSource(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	origRegisterer, origGatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	prometheus.DefaultRegisterer = registry
+	prometheus.DefaultGatherer = registry
+	origNode, origPod, origRecorder := Node, Pod, Recorder
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer = origRegisterer
+		prometheus.DefaultGatherer = origGatherer
+		Node, Pod, Recorder = origNode, origPod, origRecorder
+	})
+
+	recordDir := t.TempDir()
+	t.Setenv("DEPLOY_TYPE", "Deployment")
+	t.Setenv("EPHEMERAL_STORAGE_POD_USAGE", "true")
+	t.Setenv("EPHEMERAL_STORAGE_NODE_AVAILABLE", "true")
+	t.Setenv("RECORD_PATH", recordDir)
+	Node = node.NewCollector(1)
+	Pod = pod.NewCollector(1)
+	Node.Source = fakeStatsSource{content: []byte(sampleStatsSummary)}
+	var err error
+	if Recorder, err = record.NewRecorder(); err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	usage, err := defaultCluster().setMetrics("test-node-01")
+	if err != nil || usage != 20 {
+		t.Errorf("setMetrics() = %v, %v; want 20%% usage", usage, err)
+	}
+
+	if captures, _ := os.ReadDir(filepath.Join(recordDir, "test-node-01")); len(captures) != 1 {
+		t.Errorf("expected 1 recorded capture, got %d", len(captures))
+	}
+
+	expected := strings.NewReader(`
+		# HELP ephemeral_storage_node_available Available ephemeral storage for a node
+		# TYPE ephemeral_storage_node_available gauge
+		ephemeral_storage_node_available{node_name="test-node-01"} 8e+06
+		# HELP ephemeral_storage_pod_usage Current ephemeral byte usage of pod
+		# TYPE ephemeral_storage_pod_usage gauge
+		ephemeral_storage_pod_usage{node_name="test-node-01",pod_name="pod-a",pod_namespace="ns-a"} 2e+06
+	`)
+	if err := testutil.GatherAndCompare(registry, expected,
+		"ephemeral_storage_node_available",
+		"ephemeral_storage_pod_usage",
+	); err != nil {
+		t.Fatalf("metrics mismatch: %v", err)
+	}
+}
+
+func TestReplayRecording(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	origRegisterer, origGatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	prometheus.DefaultRegisterer = registry
+	prometheus.DefaultGatherer = registry
+	origNode, origPod := Node, Pod
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer = origRegisterer
+		prometheus.DefaultGatherer = origGatherer
+		Node, Pod = origNode, origPod
+	})
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test-node-01.json"), []byte(sampleStatsSummary), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := `apiVersion: v1
+kind: Pod
+metadata:
+  name: pod-a
+  namespace: ns-a
+spec:
+  containers:
+  - name: app
+status:
+  phase: Succeeded
+`
+	if err := os.WriteFile(filepath.Join(dir, "pods.yaml"), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DEPLOY_TYPE", "Deployment")
+	t.Setenv("REPLAY_PATH", dir)
+	t.Setenv("EPHEMERAL_STORAGE_POD_USAGE", "true")
+	t.Setenv("EPHEMERAL_STORAGE_POD_PHASE", "true")
+	Node, Pod = startCollectors(1, replayCollectorDeps)
+
+	if err := replayRecording(dir); err != nil {
+		t.Fatalf("replayRecording: %v", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP ephemeral_storage_pod_phase Phase of a pod reported in the stats summary, set to 1 for the current phase
+		# TYPE ephemeral_storage_pod_phase gauge
+		ephemeral_storage_pod_phase{node_name="test-node-01",phase="Succeeded",pod_name="pod-a",pod_namespace="ns-a"} 1
+		# HELP ephemeral_storage_pod_usage Current ephemeral byte usage of pod
+		# TYPE ephemeral_storage_pod_usage gauge
+		ephemeral_storage_pod_usage{node_name="test-node-01",pod_name="pod-a",pod_namespace="ns-a"} 2e+06
+	`)
+	if err := testutil.GatherAndCompare(registry, expected,
+		"ephemeral_storage_pod_phase",
+		"ephemeral_storage_pod_usage",
+	); err != nil {
+		t.Fatalf("metrics mismatch: %v", err)
+	}
+
+	if err := replayRecording(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing recording")
+	}
+}
+
+func TestMultiClusterMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	origRegisterer, origGatherer := prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	prometheus.DefaultRegisterer = registry
+	prometheus.DefaultGatherer = registry
+	t.Cleanup(func() {
+		prometheus.DefaultRegisterer = origRegisterer
+		prometheus.DefaultGatherer = origGatherer
+	})
+
+	t.Setenv("CLUSTERS", "a,b")
+	t.Setenv("DEPLOY_TYPE", "Deployment")
+	t.Setenv("EPHEMERAL_STORAGE_POD_USAGE", "true")
+	t.Setenv("EPHEMERAL_STORAGE_NODE_AVAILABLE", "true")
+	broken := dev.Cluster{Name: "c", Err: errors.New("unknown context")}
+	clusters := startClusters(1, []dev.Cluster{{Name: "a"}, {Name: "b"}, broken}, func(*node.Node) {})
+	if len(clusters) != 2 {
+		t.Fatalf("started %d clusters, want the broken one skipped", len(clusters))
+	}
+	for _, c := range clusters {
+		c.node.Source = fakeStatsSource{content: []byte(sampleStatsSummary)}
+		// Both clusters have a node and pod of the same name.
+		if _, err := c.scrape("test-node-01"); err != nil {
+			t.Fatalf("scrape cluster %s: %v", c.name, err)
+		}
+	}
+
+	// Pods missing from the summaries of one cluster leave the other alone.
+	const empty = `{"node": {"nodeName": "test-node-01"}, "pods": []}`
+	for range 2 {
+		if _, err := clusters[0].setMetricsFromSummary("test-node-01", strings.NewReader(empty)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := strings.NewReader(`
+		# HELP ephemeral_storage_cluster_up Whether the apiserver of a cluster answered its readiness check, 1 when ready and 0 otherwise
+		# TYPE ephemeral_storage_cluster_up gauge
+		ephemeral_storage_cluster_up{cluster="c"} 0
+		# HELP ephemeral_storage_cluster_scrapes_total Node scrapes of a cluster by result, success or failure
+		# TYPE ephemeral_storage_cluster_scrapes_total counter
+		ephemeral_storage_cluster_scrapes_total{cluster="a",result="success"} 1
+		ephemeral_storage_cluster_scrapes_total{cluster="b",result="success"} 1
+		# HELP ephemeral_storage_node_available Available ephemeral storage for a node
+		# TYPE ephemeral_storage_node_available gauge
+		ephemeral_storage_node_available{cluster="a",node_name="test-node-01"} 8e+06
+		ephemeral_storage_node_available{cluster="b",node_name="test-node-01"} 8e+06
+		# HELP ephemeral_storage_pod_usage Current ephemeral byte usage of pod
+		# TYPE ephemeral_storage_pod_usage gauge
+		ephemeral_storage_pod_usage{cluster="b",node_name="test-node-01",pod_name="pod-a",pod_namespace="ns-a"} 2e+06
+	`)
+	if err := testutil.GatherAndCompare(registry, expected,
+		"ephemeral_storage_cluster_up",
+		"ephemeral_storage_cluster_scrapes_total",
+		"ephemeral_storage_node_available",
+		"ephemeral_storage_pod_usage",
+	); err != nil {
+		t.Fatalf("metrics mismatch: %v", err)
+	}
+}
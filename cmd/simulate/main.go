@@ -0,0 +1,70 @@
+// Command simulate serves a synthetic cluster for scale testing the exporter
+// without a real cluster: node and pod lists for its informers and node proxy
+// stats summaries that follow a configurable usage pattern, with optional
+// failure injection.
+//
+//	go run ./cmd/simulate -nodes 2000 -pods-per-node 50 -kubeconfig /tmp/sim.kubeconfig
+//	KUBECONFIG=/tmp/sim.kubeconfig DEPLOY_TYPE=Deployment go run ./cmd/app
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/simulate"
+)
+
+func main() {
+	var cfg simulate.Config
+	listen := flag.String("listen", "127.0.0.1:8001", "address the fake apiserver listens on")
+	kubeconfig := flag.String("kubeconfig", "", "write a kubeconfig pointing at the simulator to this path")
+	flag.IntVar(&cfg.Nodes, "nodes", 100, "number of simulated nodes")
+	flag.IntVar(&cfg.PodsPerNode, "pods-per-node", 30, "number of pods on every node")
+	flag.IntVar(&cfg.ContainersPerPod, "containers", 1, "number of containers in every pod")
+	flag.Int64Var(&cfg.CapacityBytes, "capacity", 100<<30, "ephemeral storage capacity of every node in bytes")
+	flag.Int64Var(&cfg.BaseBytes, "base", 10<<20, "starting ephemeral storage usage of a container in bytes")
+	flag.Int64Var(&cfg.RateBytes, "rate", 1<<20, "bytes per second the pattern adds or removes")
+	flag.Int64Var(&cfg.LimitBytes, "limit", 0, "ephemeral-storage limit of every container in bytes, 0 for none")
+	flag.StringVar(&cfg.Pattern, "pattern", simulate.PatternGrow, "usage pattern: flat, grow, shrink or sawtooth")
+	flag.DurationVar(&cfg.Latency, "latency", 0, "latency added to every stats summary response")
+	flag.Float64Var(&cfg.ErrorRate, "error-rate", 0, "share of stats summary requests answered with 503")
+	flag.Float64Var(&cfg.MalformedRate, "malformed-rate", 0, "share of stats summary responses cut short")
+	flag.Float64Var(&cfg.DropPodRate, "drop-pod-rate", 0, "share of pods left out of each stats summary")
+	flag.IntVar(&cfg.DeadNodes, "dead-nodes", 0, "number of nodes whose stats summary always fails")
+	flag.DurationVar(&cfg.WatchTimeout, "watch-timeout", 5*time.Minute, "longest a watch request is held open")
+	flag.Parse()
+
+	dev.SetLogger()
+	cluster, err := simulate.NewCluster(cfg)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid simulation")
+		os.Exit(1)
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to listen on %s", *listen)
+		os.Exit(1)
+	}
+	server := fmt.Sprintf("http://%s", listener.Addr())
+	if *kubeconfig != "" {
+		if err := simulate.WriteKubeconfig(*kubeconfig, server); err != nil {
+			log.Error().Err(err).Msgf("Failed to write kubeconfig %s", *kubeconfig)
+			os.Exit(1)
+		}
+	}
+
+	log.Info().Msgf("Simulating %d nodes with %d pods each on %s", cfg.Nodes, cfg.PodsPerNode, server)
+	srv := &http.Server{Handler: cluster.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	if err := srv.Serve(listener); err != nil {
+		log.Error().Err(err).Msg("Simulator failed")
+		os.Exit(1)
+	}
+}
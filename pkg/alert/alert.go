@@ -0,0 +1,324 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+)
+
+// AlertName is the alertname label of the notifications.
+const AlertName = "EphemeralStorageThresholdBreached"
+
+// Key identifies the alert of a pod at a threshold level.
+type Key struct {
+	Cluster   string // empty unless several clusters are scraped
+	Pod       string
+	Namespace string
+	Node      string
+	Level     string
+}
+
+func (k Key) labels() model.LabelSet {
+	labels := model.LabelSet{
+		model.AlertNameLabel: AlertName,
+		"pod_name":           model.LabelValue(k.Pod),
+		"pod_namespace":      model.LabelValue(k.Namespace),
+		"node_name":          model.LabelValue(k.Node),
+		"level":              model.LabelValue(k.Level),
+		"severity":           model.LabelValue(k.Level),
+	}
+	if k.Cluster != "" {
+		labels["cluster"] = model.LabelValue(k.Cluster)
+	}
+	return labels
+}
+
+// state is the evaluation state of an alert that is pending or firing.
+type state struct {
+	since     time.Time // first evaluation above the threshold
+	firing    bool
+	lastSent  time.Time
+	usage     float64
+	threshold float64
+}
+
+// notification is an alert queued for the webhooks.
+type notification struct {
+	key      Key
+	state    state
+	resolved time.Time // zero while firing
+}
+
+// Evaluator checks pod usage against thresholds like an Alertmanager fed by
+// a single alert rule: an alert fires once usage stayed at or above its
+// threshold for the for duration, and resolves once usage drops below the
+// threshold minus the hysteresis, so usage hovering around a threshold does
+// not flap. Firing alerts are only sent again after the repeat interval.
+// Notifications are queued and posted to every webhook by Run, so
+// evaluating never blocks a scrape.
+type Evaluator struct {
+	webhooks       []string
+	forDuration    time.Duration
+	hysteresis     float64 // percentage points
+	repeatInterval time.Duration
+	retries        int
+	retryBackoff   time.Duration
+	client         *http.Client
+	now            func() time.Time
+
+	mu     sync.Mutex
+	alerts map[Key]*state
+
+	queue chan notification
+}
+
+// NewEvaluator returns the Evaluator configured through ALERT_WEBHOOK_URLS
+// (comma separated), ALERT_FOR, ALERT_HYSTERESIS_PERCENT,
+// ALERT_REPEAT_INTERVAL, ALERT_WEBHOOK_RETRIES and ALERT_WEBHOOK_TIMEOUT, or
+// nil when no webhook is configured.
+func NewEvaluator() (*Evaluator, error) {
+	var webhooks []string
+	for _, url := range strings.Split(dev.GetEnv("ALERT_WEBHOOK_URLS", ""), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			webhooks = append(webhooks, url)
+		}
+	}
+	if len(webhooks) == 0 {
+		return nil, nil
+	}
+	forDuration, err := time.ParseDuration(dev.GetEnv("ALERT_FOR", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("ALERT_FOR: %w", err)
+	}
+	hysteresis, err := strconv.ParseFloat(dev.GetEnv("ALERT_HYSTERESIS_PERCENT", "5"), 64)
+	if err != nil || hysteresis < 0 {
+		return nil, fmt.Errorf("ALERT_HYSTERESIS_PERCENT: want a non-negative percentage, got %q", dev.GetEnv("ALERT_HYSTERESIS_PERCENT", "5"))
+	}
+	repeatInterval, err := time.ParseDuration(dev.GetEnv("ALERT_REPEAT_INTERVAL", "4h"))
+	if err != nil {
+		return nil, fmt.Errorf("ALERT_REPEAT_INTERVAL: %w", err)
+	}
+	retries, err := strconv.Atoi(dev.GetEnv("ALERT_WEBHOOK_RETRIES", "3"))
+	if err != nil {
+		return nil, fmt.Errorf("ALERT_WEBHOOK_RETRIES: %w", err)
+	}
+	timeout, err := time.ParseDuration(dev.GetEnv("ALERT_WEBHOOK_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("ALERT_WEBHOOK_TIMEOUT: %w", err)
+	}
+	return newEvaluator(webhooks, forDuration, hysteresis, repeatInterval, retries, time.Second, timeout), nil
+}
+
+func newEvaluator(webhooks []string, forDuration time.Duration, hysteresis float64, repeatInterval time.Duration, retries int, retryBackoff time.Duration, timeout time.Duration) *Evaluator {
+	return &Evaluator{
+		webhooks:       webhooks,
+		forDuration:    forDuration,
+		hysteresis:     hysteresis,
+		repeatInterval: repeatInterval,
+		retries:        max(retries, 0),
+		retryBackoff:   retryBackoff,
+		client:         &http.Client{Timeout: timeout},
+		now:            time.Now,
+		alerts:         map[Key]*state{},
+		queue:          make(chan notification, 1024),
+	}
+}
+
+// Evaluate records the usage in percent of the pod and level in key against
+// threshold, and queues a notification when the alert starts firing, is
+// due to be repeated or resolves.
+func (e *Evaluator) Evaluate(key Key, usage float64, threshold float64) {
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.alerts[key]
+	if !ok {
+		if usage < threshold {
+			return
+		}
+		s = &state{since: now}
+		e.alerts[key] = s
+	}
+	s.usage, s.threshold = usage, threshold
+
+	if usage < threshold-e.hysteresis || (!s.firing && usage < threshold) {
+		e.resolve(key, s, now)
+		return
+	}
+	switch {
+	case !s.firing && now.Sub(s.since) >= e.forDuration:
+		s.firing = true
+	case s.firing && now.Sub(s.lastSent) >= e.repeatInterval:
+	default:
+		return
+	}
+	s.lastSent = now
+	e.enqueue(notification{key: key, state: *s})
+}
+
+// Clear resolves the alert of key, e.g. once its threshold was removed.
+func (e *Evaluator) Clear(key Key) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if s, ok := e.alerts[key]; ok {
+		e.resolve(key, s, e.now())
+	}
+}
+
+// ClearPod resolves every alert of the pod podName in cluster, e.g. once it
+// is deleted.
+func (e *Evaluator) ClearPod(cluster string, podName string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	for key, s := range e.alerts {
+		if key.Cluster == cluster && key.Pod == podName {
+			e.resolve(key, s, now)
+		}
+	}
+}
+
+// ClearNode resolves every alert of the pods on node in cluster, e.g. once
+// it is removed.
+func (e *Evaluator) ClearNode(cluster string, node string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	for key, s := range e.alerts {
+		if key.Cluster == cluster && key.Node == node {
+			e.resolve(key, s, now)
+		}
+	}
+}
+
+// resolve drops the alert of key and notifies the webhooks if it was firing.
+// The caller holds e.mu.
+func (e *Evaluator) resolve(key Key, s *state, now time.Time) {
+	delete(e.alerts, key)
+	if s.firing {
+		e.enqueue(notification{key: key, state: *s, resolved: now})
+	}
+}
+
+func (e *Evaluator) enqueue(n notification) {
+	select {
+	case e.queue <- n:
+	default:
+		log.Warn().Msgf("Alert queue full, dropping notification for pod %s/%s", n.key.Namespace, n.key.Pod)
+	}
+}
+
+// Run posts queued notifications to the webhooks until stop is closed.
+func (e *Evaluator) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case n := <-e.queue:
+			body, err := json.Marshal(e.payload(n))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to encode alert notification")
+				continue
+			}
+			for _, url := range e.webhooks {
+				if err := e.post(url, body, stop); err != nil {
+					log.Warn().Err(err).Msgf("Failed to notify %s about pod %s/%s", url, n.key.Namespace, n.key.Pod)
+				}
+			}
+		}
+	}
+}
+
+// post sends body to url, retrying failed attempts with exponential backoff.
+func (e *Evaluator) post(url string, body []byte, stop <-chan struct{}) error {
+	var err error
+	for attempt := 0; attempt <= e.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-stop:
+				return err
+			case <-time.After(e.retryBackoff << (attempt - 1)):
+			}
+		}
+		var resp *http.Response
+		resp, err = e.client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+		err = fmt.Errorf("webhook returned %s", resp.Status)
+		if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+			// The receiver rejects the payload, retrying will not help.
+			return err
+		}
+	}
+	return err
+}
+
+// message is the Alertmanager webhook payload, version 4.
+type message struct {
+	Version           string         `json:"version"`
+	GroupKey          string         `json:"groupKey"`
+	TruncatedAlerts   int            `json:"truncatedAlerts"`
+	Status            string         `json:"status"`
+	Receiver          string         `json:"receiver"`
+	GroupLabels       model.LabelSet `json:"groupLabels"`
+	CommonLabels      model.LabelSet `json:"commonLabels"`
+	CommonAnnotations model.LabelSet `json:"commonAnnotations"`
+	ExternalURL       string         `json:"externalURL"`
+	Alerts            []alertMessage `json:"alerts"`
+}
+
+type alertMessage struct {
+	Status       string         `json:"status"`
+	Labels       model.LabelSet `json:"labels"`
+	Annotations  model.LabelSet `json:"annotations"`
+	StartsAt     time.Time      `json:"startsAt"`
+	EndsAt       time.Time      `json:"endsAt"`
+	GeneratorURL string         `json:"generatorURL"`
+	Fingerprint  string         `json:"fingerprint"`
+}
+
+func (e *Evaluator) payload(n notification) message {
+	labels := n.key.labels()
+	status := string(model.AlertFiring)
+	if !n.resolved.IsZero() {
+		status = string(model.AlertResolved)
+	}
+	annotations := model.LabelSet{
+		"summary": "Pod ephemeral storage usage breaches its threshold.",
+		"description": model.LabelValue(fmt.Sprintf("Ephemeral storage usage of pod %s in Namespace %s on Node %s is at %.1f%%, its %s threshold is %.1f%%.",
+			n.key.Pod, n.key.Namespace, n.key.Node, n.state.usage, n.key.Level, n.state.threshold)),
+	}
+	return message{
+		Version:           "4",
+		GroupKey:          fmt.Sprintf("{}:%s", labels),
+		Status:            status,
+		Receiver:          "k8s-ephemeral-storage-metrics",
+		GroupLabels:       labels,
+		CommonLabels:      labels,
+		CommonAnnotations: annotations,
+		Alerts: []alertMessage{{
+			Status:      status,
+			Labels:      labels,
+			Annotations: annotations,
+			StartsAt:    n.state.since,
+			EndsAt:      n.resolved,
+			Fingerprint: labels.Fingerprint().String(),
+		}},
+	}
+}
@@ -0,0 +1,225 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a local webhook recording the payloads it accepted. The first
+// failures requests are answered with a 503.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+	messages []message
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var m message
+	if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.messages = append(r.messages, m)
+}
+
+func (r *receiver) received() []message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]message(nil), r.messages...)
+}
+
+// clock is a manually advanced time source.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func start(t *testing.T, e *Evaluator) {
+	t.Helper()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		e.Run(stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+}
+
+func waitFor(t *testing.T, r *receiver, n int) []message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if m := r.received(); len(m) >= n {
+			return m
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("received %d notifications, want %d", len(r.received()), n)
+	return nil
+}
+
+func TestEvaluator(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e := newEvaluator([]string{server.URL}, time.Minute, 5, time.Hour, 0, time.Millisecond, time.Second)
+	e.now = c.Now
+	start(t, e)
+	key := Key{Pod: "db", Namespace: "team-a", Node: "node-1", Level: "critical"}
+
+	// Pending until usage stayed above the threshold for a minute.
+	e.Evaluate(key, 91, 90)
+	c.Add(30 * time.Second)
+	e.Evaluate(key, 92, 90)
+	// Dropping below the threshold while pending starts over.
+	c.Add(20 * time.Second)
+	e.Evaluate(key, 89, 90)
+	c.Add(20 * time.Second)
+	e.Evaluate(key, 91, 90)
+	c.Add(59 * time.Second)
+	e.Evaluate(key, 91, 90)
+	if n := len(r.received()); n != 0 {
+		t.Fatalf("%d notifications before the for duration", n)
+	}
+	c.Add(time.Second)
+	e.Evaluate(key, 93, 90)
+	messages := waitFor(t, r, 1)
+
+	m := messages[0]
+	if m.Version != "4" || m.Status != "firing" || len(m.Alerts) != 1 {
+		t.Fatalf("unexpected payload %+v", m)
+	}
+	a := m.Alerts[0]
+	if a.Labels["alertname"] != AlertName || a.Labels["pod_name"] != "db" || a.Labels["severity"] != "critical" || a.Fingerprint == "" {
+		t.Errorf("unexpected labels %v, fingerprint %q", a.Labels, a.Fingerprint)
+	}
+	if !a.StartsAt.Equal(time.Date(2024, 1, 1, 0, 1, 10, 0, time.UTC)) || !a.EndsAt.IsZero() {
+		t.Errorf("startsAt %v endsAt %v", a.StartsAt, a.EndsAt)
+	}
+
+	// Hysteresis: usage between 85% and 90% keeps the alert firing, and
+	// firing alerts are deduplicated until the repeat interval.
+	for i := 0; i < 5; i++ {
+		c.Add(time.Minute)
+		e.Evaluate(key, 87, 90)
+	}
+	c.Add(time.Hour)
+	e.Evaluate(key, 88, 90)
+	messages = waitFor(t, r, 2)
+	if messages[1].Status != "firing" || messages[1].Alerts[0].Fingerprint != a.Fingerprint {
+		t.Errorf("repeat = %+v", messages[1])
+	}
+
+	c.Add(time.Minute)
+	e.Evaluate(key, 84, 90)
+	messages = waitFor(t, r, 3)
+	if messages[2].Status != "resolved" || messages[2].Alerts[0].EndsAt.IsZero() {
+		t.Errorf("resolution = %+v", messages[2])
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := len(r.received()); n != 3 {
+		t.Errorf("%d notifications, want 3", n)
+	}
+}
+
+func TestEvaluatorClear(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	e := newEvaluator([]string{server.URL}, 0, 5, time.Hour, 0, time.Millisecond, time.Second)
+	start(t, e)
+	pending := newEvaluator(nil, time.Hour, 5, time.Hour, 0, time.Millisecond, time.Second)
+
+	e.Evaluate(Key{Pod: "a", Node: "node-1", Level: "warn"}, 95, 80)
+	e.Evaluate(Key{Pod: "a", Node: "node-1", Level: "critical"}, 95, 90)
+	e.Evaluate(Key{Pod: "b", Node: "node-2", Level: "warn"}, 95, 80)
+	// A pod of the same name in another cluster.
+	other := Key{Cluster: "prod", Pod: "a", Node: "node-2", Level: "warn"}
+	e.Evaluate(other, 95, 80)
+	waitFor(t, r, 4)
+
+	e.ClearPod("", "a")
+	waitFor(t, r, 6)
+	e.ClearNode("", "node-2")
+	messages := waitFor(t, r, 7)
+	for _, m := range messages[4:] {
+		if m.Status != "resolved" {
+			t.Errorf("status = %s, want resolved", m.Status)
+		}
+	}
+	if _, ok := e.alerts[other]; !ok || len(e.alerts) != 1 {
+		t.Errorf("%d alerts left, want the one of the other cluster", len(e.alerts))
+	}
+
+	// Alerts that never fired resolve silently.
+	pending.Evaluate(Key{Pod: "c", Level: "warn"}, 95, 80)
+	pending.Clear(Key{Pod: "c", Level: "warn"})
+	if len(pending.queue) != 0 || len(pending.alerts) != 0 {
+		t.Error("pending alert notified or kept")
+	}
+}
+
+func TestEvaluatorRetries(t *testing.T) {
+	flaky := &receiver{failures: 2}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+	down := &receiver{failures: 100}
+	downServer := httptest.NewServer(down)
+	defer downServer.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	e := newEvaluator([]string{downServer.URL, rejecting.URL, flakyServer.URL}, 0, 5, time.Hour, 3, time.Millisecond, time.Second)
+	if err := e.post(rejecting.URL, []byte("{}"), nil); err == nil {
+		t.Error("rejected payload reported as sent")
+	}
+	start(t, e)
+	e.Evaluate(Key{Pod: "a", Level: "warn"}, 95, 80)
+
+	// The webhook that is down does not keep the others from being notified.
+	waitFor(t, flaky, 1)
+	flaky.mu.Lock()
+	defer flaky.mu.Unlock()
+	if flaky.requests != 3 {
+		t.Errorf("flaky webhook got %d requests, want 3", flaky.requests)
+	}
+	down.mu.Lock()
+	defer down.mu.Unlock()
+	if down.requests != 4 {
+		t.Errorf("webhook that is down got %d requests, want 1 + 3 retries", down.requests)
+	}
+}
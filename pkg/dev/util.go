@@ -5,8 +5,11 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -20,6 +23,9 @@ var (
 	Clientset *kubernetes.Clientset
 	ClientRaw *http.Client
 	ClientAno *http.Client
+
+	// failedClusters holds the clusters of CLUSTERS whose watches stopped.
+	failedClusters sync.Map
 )
 
 func GetEnv(key, fallback string) string {
@@ -37,10 +43,15 @@ func CurrentNodeName() string {
 	return GetEnv("CURRENT_NODE_NAME", "")
 }
 
-func setScrapeFromKubelet(config *rest.Config) {
-
-	var err error
+// ReplayPath is the recording the exporter replays instead of querying a
+// cluster, empty when running against a cluster.
+func ReplayPath() string {
+	return GetEnv("REPLAY_PATH", "")
+}
 
+// newKubeletClients returns the clients scraping kubelets directly, with the
+// credentials of config and anonymously.
+func newKubeletClients(config *rest.Config) (*http.Client, *http.Client, error) {
 	// creates the raw client with authentication
 	newConfig := *config
 	insecure, _ := strconv.ParseBool(GetEnv("SCRAPE_FROM_KUBELET_TLS_INSECURE_SKIP_VERIFY", "false"))
@@ -49,30 +60,165 @@ func setScrapeFromKubelet(config *rest.Config) {
 		newConfig.TLSClientConfig.CAFile = ""
 		newConfig.TLSClientConfig.CAData = nil
 	}
-	if ClientRaw, err = rest.HTTPClientFor(&newConfig); err != nil {
-		log.Error().Msg("Failed to get raw http client")
-		panic(err.Error())
+	raw, err := rest.HTTPClientFor(&newConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get raw http client: %w", err)
 	}
 
 	// creates the raw client without authentication
 	anoConfig := rest.AnonymousClientConfig(config)
-	if ClientAno, err = rest.HTTPClientFor(anoConfig); err != nil {
-		log.Error().Msg("Failed to get anonymous http client")
-		panic(err.Error())
+	ano, err := rest.HTTPClientFor(anoConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get anonymous http client: %w", err)
 	}
+	return raw, ano, nil
 }
 
-func SetK8sClient() {
-	config, err := getK8sConfig()
-	if err != nil {
-		panic(err)
+// Cluster is a cluster the exporter scrapes, with its clients.
+type Cluster struct {
+	// Name is the value of the cluster label, empty unless the exporter
+	// scrapes several clusters.
+	Name      string
+	Clientset *kubernetes.Clientset
+	ClientRaw *http.Client
+	ClientAno *http.Client
+	// StatsSource is the stats source set by the statsSource option of the
+	// CLUSTERS entry, empty to use STATS_SOURCE.
+	StatsSource string
+	// Err is why the clients of the cluster could not be built, in which
+	// case the cluster is reported down and not scraped.
+	Err error
+}
+
+// DefaultCluster returns the cluster of the clients SetK8sClient sets when
+// called without sources.
+func DefaultCluster() Cluster {
+	return Cluster{Clientset: Clientset, ClientRaw: ClientRaw, ClientAno: ClientAno}
+}
+
+// Clusters returns the kubeconfig sources listed in CLUSTERS, empty when the
+// exporter only scrapes the cluster of KUBECONFIG or the one it runs in.
+func Clusters() []string {
+	var sources []string
+	for source := range strings.SplitSeq(GetEnv("CLUSTERS", ""), ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// MultiCluster reports whether the exporter scrapes the clusters listed in
+// CLUSTERS, in which case every metric family carries a cluster label.
+func MultiCluster() bool {
+	return len(Clusters()) > 0
+}
+
+// WithClusterLabel appends the cluster label to the label names of a metric
+// family when the exporter scrapes several clusters.
+func WithClusterLabel(names []string) []string {
+	if MultiCluster() {
+		return append(names, "cluster")
+	}
+	return names
+}
+
+// ClusterKey qualifies name, e.g. of a node or pod, with its cluster in the
+// state shared by the collectors of several clusters.
+func ClusterKey(cluster string, name string) string {
+	if cluster == "" {
+		return name
+	}
+	return cluster + "/" + name
+}
+
+// WatchStopped reports that a watch of cluster stopped. The exporter exits
+// when it scrapes a single cluster, otherwise the cluster is reported down
+// and the other clusters keep being scraped.
+func WatchStopped(cluster string, watch string, err error) {
+	if cluster == "" {
+		log.Error().Err(err).Msgf("Watcher %s stopped.", watch)
+		os.Exit(1)
 	}
+	log.Error().Err(err).Msgf("Watcher %s of cluster %s stopped, reporting the cluster down", watch, cluster)
+	failedClusters.Store(cluster, true)
+}
 
+// ClusterFailed reports whether a watch of cluster stopped.
+func ClusterFailed(cluster string) bool {
+	_, failed := failedClusters.Load(cluster)
+	return failed
+}
+
+// SetK8sClient builds the clients of the clusters the exporter scrapes.
+// Without sources it connects to the cluster of KUBECONFIG or the one it
+// runs in and sets Clientset, ClientRaw and ClientAno. Otherwise each source
+// is a kubeconfig file or a context of KUBECONFIG, optionally prefixed with
+// the name the cluster label takes, e.g. prod=/etc/kubeconfigs/prod.yaml,
+// and optionally followed by the stats source of the cluster, e.g.
+// prod=/etc/kubeconfigs/prod.yaml;statsSource=metrics. A source whose clients
+// cannot be built is returned with Err set rather than stopping the exporter.
+func SetK8sClient(sources ...string) []Cluster {
+	if len(sources) == 0 {
+		config, err := getK8sConfig()
+		if err != nil {
+			panic(err)
+		}
+		cluster, err := newCluster("", config)
+		if err != nil {
+			log.Error().Msg("Failed to get client set for in cluster client")
+			panic(err.Error())
+		}
+		Clientset, ClientRaw, ClientAno = cluster.Clientset, cluster.ClientRaw, cluster.ClientAno
+		log.Debug().Msg("Successful got the in cluster client")
+		return []Cluster{cluster}
+	}
+
+	clusters := make([]Cluster, 0, len(sources))
+	names := map[string]bool{}
+	for _, source := range sources {
+		source, statsSource, optErr := getClusterOptions(source)
+		name, config, err := getClusterConfig(source)
+		if names[name] {
+			log.Error().Msgf("cluster %s: duplicate cluster name %q, skipping it", source, name)
+			continue
+		}
+		names[name] = true
+		if optErr != nil {
+			log.Error().Err(optErr).Msgf("Invalid options of cluster %s", name)
+			clusters = append(clusters, Cluster{Name: name, Err: optErr})
+			continue
+		}
+		if err != nil {
+			// A bad source only takes its own cluster down.
+			log.Error().Err(err).Msgf("Failed to load the kubeconfig of cluster %s", source)
+			clusters = append(clusters, Cluster{Name: name, Err: err})
+			continue
+		}
+		cluster, err := newCluster(name, config)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to get client set for cluster %s", name)
+			cluster = Cluster{Name: name, Err: err}
+		} else {
+			log.Debug().Msgf("Successful got the client of cluster %s", name)
+		}
+		cluster.StatsSource = statsSource
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// newCluster creates the clients of a cluster from its rest config.
+func newCluster(name string, config *rest.Config) (Cluster, error) {
+	cluster := Cluster{Name: name}
 	config.UserAgent = "k8s-ephemeral-storage-metrics"
 
 	scrapeFromKubelet, _ := strconv.ParseBool(GetEnv("SCRAPE_FROM_KUBELET", "false"))
 	if scrapeFromKubelet {
-		setScrapeFromKubelet(config)
+		var err error
+		if cluster.ClientRaw, cluster.ClientAno, err = newKubeletClients(config); err != nil {
+			return Cluster{}, err
+		}
 	}
 
 	// fix: reading ops and burst from os env.
@@ -84,13 +230,56 @@ func SetK8sClient() {
 	}
 
 	// creates the clientset
-	Clientset, err = kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		log.Error().Msg("Failed to get client set for in cluster client")
-		panic(err.Error())
+		return Cluster{}, err
+	}
+	cluster.Clientset = clientset
+	return cluster, nil
+}
+
+// getClusterOptions splits the options following the first semicolon of a
+// CLUSTERS source off it and returns the source and its statsSource option.
+func getClusterOptions(source string) (string, string, error) {
+	source, options, _ := strings.Cut(source, ";")
+	var statsSource string
+	for option := range strings.SplitSeq(options, ";") {
+		if option = strings.TrimSpace(option); option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		if key != "statsSource" {
+			return source, "", fmt.Errorf("unknown cluster option %q", option)
+		}
+		statsSource = value
 	}
-	log.Debug().Msg("Successful got the in cluster client")
+	return source, statsSource, nil
+}
 
+// getClusterConfig loads the rest config of a CLUSTERS source and returns it
+// with the cluster name. Sources naming an existing file are read as a
+// kubeconfig and named after the file, others select a context of
+// KUBECONFIG and are named after it.
+func getClusterConfig(source string) (string, *rest.Config, error) {
+	name, target, named := strings.Cut(source, "=")
+	if !named {
+		name, target = "", source
+	}
+	if _, err := os.Stat(target); err == nil {
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(target), filepath.Ext(target))
+		}
+		config, err := clientcmd.BuildConfigFromFlags("", target)
+		return name, config, err
+	}
+	if name == "" {
+		name = target
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: target},
+	).ClientConfig()
+	return name, config, err
 }
 
 func getK8sConfig() (*rest.Config, error) {
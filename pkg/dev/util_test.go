@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -154,6 +155,17 @@ func TestCurrentNodeName(t *testing.T) {
 	})
 }
 
+func TestReplayPath(t *testing.T) {
+	t.Setenv("REPLAY_PATH", "")
+	if ReplayPath() != "" {
+		t.Error("expected empty when unset")
+	}
+	t.Setenv("REPLAY_PATH", "/tmp/capture.tgz")
+	if ReplayPath() != "/tmp/capture.tgz" {
+		t.Errorf("expected '/tmp/capture.tgz', got %q", ReplayPath())
+	}
+}
+
 func TestLineInfoHookRun(t *testing.T) {
 	var buf bytes.Buffer
 	logger := zerolog.New(&buf).Hook(LineInfoHook{})
@@ -179,22 +191,18 @@ func TestSetLogger_InvalidLevelPanics(t *testing.T) {
 	SetLogger()
 }
 
-func TestSetScrapeFromKubelet(t *testing.T) {
-	origClientRaw := ClientRaw
-	origClientAno := ClientAno
-	t.Cleanup(func() {
-		ClientRaw = origClientRaw
-		ClientAno = origClientAno
-	})
-
+func TestNewKubeletClients(t *testing.T) {
 	t.Setenv("SCRAPE_FROM_KUBELET_TLS_INSECURE_SKIP_VERIFY", "true")
 	config := &rest.Config{Host: "https://localhost:6443"}
-	setScrapeFromKubelet(config)
-	if ClientRaw == nil {
-		t.Error("ClientRaw should not be nil")
+	raw, ano, err := newKubeletClients(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw == nil {
+		t.Error("raw client should not be nil")
 	}
-	if ClientAno == nil {
-		t.Error("ClientAno should not be nil")
+	if ano == nil {
+		t.Error("anonymous client should not be nil")
 	}
 }
 
@@ -248,4 +256,78 @@ users:
 			t.Error("Clientset should not be nil with SCRAPE_FROM_KUBELET")
 		}
 	})
+
+	t.Run("creates a client per cluster source", func(t *testing.T) {
+		t.Setenv("KUBECONFIG", kubeconfig)
+		Clientset = nil
+		clusters := SetK8sClient(kubeconfig, "staging="+kubeconfig, "test")
+		var names []string
+		for _, c := range clusters {
+			if c.Clientset == nil {
+				t.Errorf("cluster %s has no Clientset", c.Name)
+			}
+			names = append(names, c.Name)
+		}
+		if want := []string{"config", "staging", "test"}; !slices.Equal(names, want) {
+			t.Errorf("cluster names = %v, want %v", names, want)
+		}
+		if Clientset != nil {
+			t.Error("Clientset set while scraping several clusters")
+		}
+	})
+
+	t.Run("reports bad sources without stopping", func(t *testing.T) {
+		t.Setenv("KUBECONFIG", kubeconfig)
+		clusters := SetK8sClient("missing", "test", "test="+kubeconfig)
+		if len(clusters) != 2 {
+			t.Fatalf("got %d clusters, want the duplicate name skipped", len(clusters))
+		}
+		if clusters[0].Name != "missing" || clusters[0].Err == nil {
+			t.Errorf("cluster %s: Err = %v, want an unknown context error", clusters[0].Name, clusters[0].Err)
+		}
+		if clusters[1].Name != "test" || clusters[1].Err != nil || clusters[1].Clientset == nil {
+			t.Errorf("cluster %s: Err = %v, want a Clientset", clusters[1].Name, clusters[1].Err)
+		}
+	})
+
+	t.Run("reads the stats source of a cluster", func(t *testing.T) {
+		t.Setenv("KUBECONFIG", kubeconfig)
+		clusters := SetK8sClient("prod="+kubeconfig+";statsSource=metrics", "test", "bad="+kubeconfig+";source=metrics")
+		if clusters[0].Name != "prod" || clusters[0].Err != nil || clusters[0].StatsSource != "metrics" {
+			t.Errorf("cluster %s: StatsSource = %q, Err = %v, want metrics", clusters[0].Name, clusters[0].StatsSource, clusters[0].Err)
+		}
+		if clusters[1].Name != "test" || clusters[1].StatsSource != "" {
+			t.Errorf("cluster %s: StatsSource = %q, want STATS_SOURCE", clusters[1].Name, clusters[1].StatsSource)
+		}
+		if clusters[2].Name != "bad" || clusters[2].Err == nil {
+			t.Errorf("cluster %s: Err = %v, want an unknown option error", clusters[2].Name, clusters[2].Err)
+		}
+	})
+}
+
+func TestWatchStopped(t *testing.T) {
+	if ClusterFailed("prod") {
+		t.Fatal("cluster prod failed before its watch stopped")
+	}
+	WatchStopped("prod", "podWatch", nil)
+	if !ClusterFailed("prod") {
+		t.Error("cluster prod not failed after its watch stopped")
+	}
+	if ClusterFailed("staging") {
+		t.Error("a stopped watch of prod failed staging")
+	}
+}
+
+func TestClusters(t *testing.T) {
+	t.Setenv("CLUSTERS", " prod=/etc/kubeconfigs/prod, ,staging ")
+	if got, want := Clusters(), []string{"prod=/etc/kubeconfigs/prod", "staging"}; !slices.Equal(got, want) {
+		t.Errorf("Clusters() = %v, want %v", got, want)
+	}
+	if got := WithClusterLabel([]string{"node_name"}); !slices.Equal(got, []string{"node_name", "cluster"}) {
+		t.Errorf("WithClusterLabel() = %v with CLUSTERS set", got)
+	}
+	t.Setenv("CLUSTERS", "")
+	if got := WithClusterLabel([]string{"node_name"}); !slices.Equal(got, []string{"node_name"}) {
+		t.Errorf("WithClusterLabel() = %v without CLUSTERS", got)
+	}
 }
@@ -0,0 +1,244 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+var (
+	// cgroupPodUID matches the pod UID in the cgroup path of a container,
+	// written with dashes by the cgroupfs driver and underscores by systemd.
+	cgroupPodUID = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	containerID  = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// heldFile is a file a process holds open, as seen through its /proc fd link.
+type heldFile struct {
+	dev     uint64
+	ino     uint64
+	deleted bool  // no links left
+	bytes   int64 // allocated, not apparent, size
+	overlay bool  // on an overlay filesystem, i.e. a container rootfs
+}
+
+// DeletedFileScanner finds files that were deleted while processes of the
+// pods on its node still hold them open. Their space stays in use until the
+// last descriptor is closed, but no longer shows up when walking the
+// filesystem. Only files on container overlays or the kubelet filesystem
+// are counted, memory backed files are not ephemeral storage.
+type DeletedFileScanner struct {
+	procDir    string
+	node       string
+	interval   time.Duration
+	kubeletDev uint64
+	pods       func() []pod.PodRef
+
+	exported map[string]prometheus.Labels // keyed by pod uid/container
+}
+
+var (
+	deletedOpenBytesVec    *prometheus.GaugeVec
+	deletedOpenMetricsOnce sync.Once
+)
+
+func createDeletedOpenMetrics() {
+	deletedOpenMetricsOnce.Do(func() {
+		deletedOpenBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ephemeral_storage_deleted_open_bytes",
+			Help: "Bytes held by deleted files that processes of a container still keep open",
+		},
+			[]string{
+				// name of pod for Ephemeral Storage
+				"pod_name",
+				// namespace of pod for Ephemeral Storage
+				"pod_namespace",
+				// Name of Node where pod is placed.
+				"node_name",
+				// Name of container
+				"container",
+			},
+		)
+		prometheus.MustRegister(deletedOpenBytesVec)
+	})
+}
+
+// NewDeletedFileScanner returns the scanner configured through
+// DELETED_FILES_SCAN, DELETED_FILES_SCAN_INTERVAL, PROC_DIR and
+// KUBELET_PODS_DIR, or nil when scanning is disabled. pods lists the pods
+// processes are mapped to. Scanning reads the node's processes, so it needs
+// DaemonSet mode on Linux with the host PID namespace.
+func NewDeletedFileScanner(pods func() []pod.PodRef) (*DeletedFileScanner, error) {
+	enabled, _ := strconv.ParseBool(dev.GetEnv("DELETED_FILES_SCAN", "false"))
+	if !enabled {
+		return nil, nil
+	}
+	if !dev.DeployAsDaemonSet() {
+		return nil, fmt.Errorf("DELETED_FILES_SCAN reads the node's processes and requires DEPLOY_TYPE DaemonSet")
+	}
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("DELETED_FILES_SCAN reads /proc and is only supported on Linux")
+	}
+	interval, err := time.ParseDuration(dev.GetEnv("DELETED_FILES_SCAN_INTERVAL", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("DELETED_FILES_SCAN_INTERVAL: %w", err)
+	}
+	podsDir := dev.GetEnv("KUBELET_PODS_DIR", "/var/lib/kubelet/pods")
+	kubeletDev, err := deviceOf(podsDir)
+	if err != nil {
+		return nil, fmt.Errorf("KUBELET_PODS_DIR: %w", err)
+	}
+	return newDeletedFileScanner(dev.GetEnv("PROC_DIR", "/proc"), dev.CurrentNodeName(), interval, kubeletDev, pods), nil
+}
+
+func newDeletedFileScanner(procDir string, node string, interval time.Duration, kubeletDev uint64, pods func() []pod.PodRef) *DeletedFileScanner {
+	createDeletedOpenMetrics()
+	return &DeletedFileScanner{
+		procDir:    procDir,
+		node:       node,
+		interval:   interval,
+		kubeletDev: kubeletDev,
+		pods:       pods,
+		exported:   map[string]prometheus.Labels{},
+	}
+}
+
+// Run scans every interval until stop is closed.
+func (s *DeletedFileScanner) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.Scan()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan walks the open descriptors of every process, sums the deleted files
+// per container and replaces the exported series. A file open in several
+// descriptors or processes is counted once, for the first container seen.
+func (s *DeletedFileScanner) Scan() {
+	pods := map[string]pod.PodRef{}
+	for _, p := range s.pods() {
+		pods[p.UID] = p
+	}
+
+	procs, err := os.ReadDir(s.procDir)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to list processes in %s", s.procDir)
+		return
+	}
+	type fileID struct{ dev, ino uint64 }
+	seen := map[fileID]bool{}
+	held := map[string]int64{}
+	current := map[string]prometheus.Labels{}
+
+	for _, proc := range procs {
+		if _, err := strconv.Atoi(proc.Name()); err != nil {
+			continue
+		}
+		procPath := filepath.Join(s.procDir, proc.Name())
+		content, err := os.ReadFile(filepath.Join(procPath, "cgroup"))
+		if err != nil {
+			// The process exited since it was listed.
+			continue
+		}
+		uid, id, ok := parseCgroup(string(content))
+		if !ok {
+			continue
+		}
+		p, ok := pods[uid]
+		if !ok {
+			continue
+		}
+		name, ok := p.Containers[id]
+		if !ok {
+			// Sandbox (pause) containers and containers missing from the pod status.
+			continue
+		}
+
+		fds, err := os.ReadDir(filepath.Join(procPath, "fd"))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Debug().Err(err).Msgf("Failed to list open files of process %s", proc.Name())
+			}
+			continue
+		}
+		key := p.UID + "/" + name
+		for _, fd := range fds {
+			f, err := statHeld(filepath.Join(procPath, "fd", fd.Name()))
+			if err != nil || !f.deleted || (!f.overlay && f.dev != s.kubeletDev) {
+				continue
+			}
+			if seen[fileID{f.dev, f.ino}] {
+				continue
+			}
+			seen[fileID{f.dev, f.ino}] = true
+			held[key] += f.bytes
+			current[key] = prometheus.Labels{"pod_name": p.Name, "pod_namespace": p.Namespace,
+				"node_name": s.node, "container": name}
+		}
+	}
+
+	for key, labels := range current {
+		deletedOpenBytesVec.With(labels).Set(float64(held[key]))
+	}
+	for key, labels := range s.exported {
+		if _, ok := current[key]; !ok {
+			deletedOpenBytesVec.Delete(labels)
+		}
+	}
+	s.exported = current
+}
+
+// parseCgroup returns the pod UID and container ID of a process from the
+// content of its /proc/<pid>/cgroup, for v1 and v2 hierarchies.
+func parseCgroup(content string) (uid string, id string, ok bool) {
+	for line := range strings.Lines(content) {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if uid, id, ok := parseCgroupPath(parts[2]); ok {
+			return uid, id, true
+		}
+	}
+	return "", "", false
+}
+
+// parseCgroupPath returns the pod UID and container ID of a container
+// cgroup for both cgroup drivers, e.g.
+// /kubepods/burstable/pod<uid>/<id> or
+// /kubepods.slice/kubepods-pod<uid>.slice/cri-containerd-<id>.scope.
+func parseCgroupPath(cgroup string) (uid string, id string, ok bool) {
+	match := cgroupPodUID.FindStringSubmatch(cgroup)
+	if match == nil {
+		return "", "", false
+	}
+	base := strings.TrimSuffix(path.Base(cgroup), ".scope")
+	if i := strings.LastIndexByte(base, '-'); i >= 0 {
+		base = base[i+1:]
+	}
+	if !containerID.MatchString(base) {
+		return "", "", false
+	}
+	return strings.ReplaceAll(match[1], "_", "-"), base, true
+}
@@ -0,0 +1,37 @@
+package host
+
+import (
+	"syscall"
+)
+
+// overlayfsMagic is the statfs type of overlay filesystems.
+const overlayfsMagic = 0x794c7630
+
+// statHeld stats the file behind an open descriptor link, which resolves to
+// the file even after it was deleted.
+func statHeld(fdPath string) (heldFile, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(fdPath, &st); err != nil {
+		return heldFile{}, err
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(fdPath, &fs); err != nil {
+		return heldFile{}, err
+	}
+	return heldFile{
+		dev:     uint64(st.Dev),
+		ino:     uint64(st.Ino),
+		deleted: st.Nlink == 0,
+		bytes:   int64(st.Blocks) * 512,
+		overlay: int64(fs.Type) == overlayfsMagic,
+	}, nil
+}
+
+// deviceOf returns the device of the filesystem holding path.
+func deviceOf(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Dev), nil
+}
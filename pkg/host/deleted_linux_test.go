@@ -0,0 +1,111 @@
+package host
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// fakeProcess lays out /proc/<pid> below procDir with a cgroup file and fd
+// links to the descriptors this test process holds.
+func fakeProcess(t *testing.T, procDir string, pid int, cgroup string, files ...*os.File) {
+	t.Helper()
+	fdDir := filepath.Join(procDir, fmt.Sprint(pid), "fd")
+	if err := os.MkdirAll(fdDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(procDir, fmt.Sprint(pid), "cgroup"), []byte(cgroup), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		if err := os.Symlink(fmt.Sprintf("/proc/self/fd/%d", f.Fd()), filepath.Join(fdDir, fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// openFile creates a file of size bytes below dir and keeps it open, removed
+// from the directory when deleted is set.
+func openFile(t *testing.T, dir string, size int, deleted bool) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp(dir, "held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	if _, err := f.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if deleted {
+		if err := os.Remove(f.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func allocated(t *testing.T, f *os.File) int64 {
+	t.Helper()
+	held, err := statHeld(fmt.Sprintf("/proc/self/fd/%d", f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return held.bytes
+}
+
+func TestDeletedFileScanner(t *testing.T) {
+	kubeletDir := t.TempDir()
+	kubeletDev, err := deviceOf(kubeletDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appID, sidecarID := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	cgroup := func(id string) string {
+		return "0::/kubepods.slice/kubepods-pod0b1c2d3e_4f50_6172_8394_a5b6c7d8e9f0.slice/cri-containerd-" + id + ".scope\n"
+	}
+
+	deletedLog := openFile(t, kubeletDir, 1<<20, true)
+	deletedTmp := openFile(t, kubeletDir, 64<<10, true)
+	kept := openFile(t, kubeletDir, 1<<20, false)
+
+	procDir := t.TempDir()
+	// The same deleted file open twice and in a second process is counted once.
+	fakeProcess(t, procDir, 100, cgroup(appID), deletedLog, deletedLog, kept)
+	fakeProcess(t, procDir, 101, cgroup(appID), deletedLog, deletedTmp)
+	// Files of the sandbox, of unknown pods and of host processes are not.
+	fakeProcess(t, procDir, 102, cgroup(strings.Repeat("c", 64)), deletedLog)
+	fakeProcess(t, procDir, 103, strings.ReplaceAll(cgroup(sidecarID), "0b1c2d3e", "ffffffff"), deletedTmp)
+	fakeProcess(t, procDir, 104, "0::/system.slice/containerd.service\n", deletedTmp)
+	fakeProcess(t, procDir, 105, cgroup(sidecarID), kept)
+
+	pods := []pod.PodRef{{Name: "web", Namespace: "team-a", UID: "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0",
+		Containers: map[string]string{appID: "app", sidecarID: "sidecar"}}}
+	s := newDeletedFileScanner(procDir, "node-1", 0, kubeletDev, func() []pod.PodRef { return pods })
+	s.Scan()
+
+	labels := prometheus.Labels{"pod_name": "web", "pod_namespace": "team-a", "node_name": "node-1", "container": "app"}
+	want := allocated(t, deletedLog) + allocated(t, deletedTmp)
+	if v := testutil.ToFloat64(deletedOpenBytesVec.With(labels)); v != float64(want) || want == 0 {
+		t.Errorf("deleted open bytes = %v, want %v", v, want)
+	}
+	if n := testutil.CollectAndCount(deletedOpenBytesVec); n != 1 {
+		t.Errorf("%d series, want 1", n)
+	}
+
+	// Files on other filesystems are not ephemeral storage.
+	s.kubeletDev++
+	s.Scan()
+	if n := testutil.CollectAndCount(deletedOpenBytesVec); n != 0 {
+		t.Errorf("%d series left for files on another filesystem", n)
+	}
+}
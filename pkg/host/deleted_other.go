@@ -0,0 +1,17 @@
+//go:build !linux
+
+package host
+
+import (
+	"errors"
+)
+
+var errNotLinux = errors.New("deleted file detection is only supported on Linux")
+
+func statHeld(string) (heldFile, error) {
+	return heldFile{}, errNotLinux
+}
+
+func deviceOf(string) (uint64, error) {
+	return 0, errNotLinux
+}
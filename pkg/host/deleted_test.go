@@ -0,0 +1,45 @@
+package host
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCgroup(t *testing.T) {
+	id := strings.Repeat("ab12", 16)
+	tests := []struct {
+		name    string
+		content string
+		wantUID string
+		wantOK  bool
+	}{
+		{
+			name:    "cgroup v2 systemd",
+			content: "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0b1c2d3e_4f50_6172_8394_a5b6c7d8e9f0.slice/cri-containerd-" + id + ".scope\n",
+			wantUID: "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0",
+			wantOK:  true,
+		},
+		{
+			name:    "cgroup v1 cgroupfs",
+			content: "12:pids:/kubepods/besteffort/pod0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0/" + id + "\n11:memory:/kubepods/besteffort/pod0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0/" + id + "\n",
+			wantUID: "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0",
+			wantOK:  true,
+		},
+		{
+			name:    "cri-o",
+			content: "0::/kubepods.slice/kubepods-pod0b1c2d3e_4f50_6172_8394_a5b6c7d8e9f0.slice/crio-" + id + ".scope\n",
+			wantUID: "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0",
+			wantOK:  true,
+		},
+		{name: "pod slice", content: "0::/kubepods.slice/kubepods-pod0b1c2d3e_4f50_6172_8394_a5b6c7d8e9f0.slice\n"},
+		{name: "host process", content: "0::/system.slice/containerd.service\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, gotID, ok := parseCgroup(tt.content)
+			if ok != tt.wantOK || uid != tt.wantUID || (ok && gotID != id) {
+				t.Errorf("parseCgroup = %q, %q, %v, want %q, %q, %v", uid, gotID, ok, tt.wantUID, id, tt.wantOK)
+			}
+		})
+	}
+}
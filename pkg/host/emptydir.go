@@ -0,0 +1,361 @@
+package host
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// emptyDirPlugin is the directory kubelet keeps a pod's emptyDirs in, below
+// <pods dir>/<pod uid>/volumes.
+const emptyDirPlugin = "kubernetes.io~empty-dir"
+
+// errBudget stops a walk once the scan ran out of time.
+var errBudget = errors.New("scan time budget exceeded")
+
+// DirUsage is the size of a directory inside an emptyDir, relative to the
+// volume root.
+type DirUsage struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// VolumeUsage is the result of scanning one emptyDir. Truncated volumes ran
+// out of time budget, their sizes only cover the files walked so far.
+type VolumeUsage struct {
+	Pod         string     `json:"pod"`
+	Namespace   string     `json:"namespace"`
+	Volume      string     `json:"volume"`
+	Bytes       int64      `json:"bytes"`
+	Directories []DirUsage `json:"directories"`
+	Truncated   bool       `json:"truncated,omitempty"`
+	ScannedAt   time.Time  `json:"scannedAt"`
+}
+
+// EmptyDirScanner walks the emptyDirs of the pods on its node below the
+// kubelet pods directory and reports the largest subdirectories of each, up
+// to depth levels deep. A pass stops once it used its time budget; volumes
+// it did not reach keep their previous results until they are scanned again.
+type EmptyDirScanner struct {
+	podsDir  string
+	node     string
+	depth    int
+	top      int
+	interval time.Duration
+	budget   time.Duration
+	pods     func() []pod.PodRef
+	now      func() time.Time
+
+	// Debug serves the last scan on /debug/emptydir when set through
+	// EMPTYDIR_SCAN_DEBUG. It lists pod names, namespaces and directories.
+	Debug bool
+
+	resume string // key of the volume the next pass starts at
+
+	mu       sync.RWMutex
+	results  map[string]VolumeUsage // keyed by pod uid/volume
+	exported map[string][]prometheus.Labels
+}
+
+var (
+	emptyDirDirectoryBytesVec *prometheus.GaugeVec
+	emptyDirScanDurationVec   *prometheus.GaugeVec
+	emptyDirMetricsOnce       sync.Once
+)
+
+func createEmptyDirMetrics() {
+	emptyDirMetricsOnce.Do(func() {
+		emptyDirDirectoryBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ephemeral_storage_emptydir_directory_bytes",
+			Help: "Bytes used by one of the largest directories inside an emptyDir volume",
+		},
+			[]string{
+				// name of pod for Ephemeral Storage
+				"pod_name",
+				// namespace of pod for Ephemeral Storage
+				"pod_namespace",
+				// Name of Node where pod is placed.
+				"node_name",
+				// Name of the emptyDir volume
+				"volume",
+				// Path of the directory relative to the volume root
+				"directory",
+			},
+		)
+		prometheus.MustRegister(emptyDirDirectoryBytesVec)
+
+		emptyDirScanDurationVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ephemeral_storage_emptydir_scan_duration_seconds",
+			Help: "Duration of the last pass over the emptyDir volumes of a node",
+		},
+			[]string{
+				// Name of the scanned Node.
+				"node_name",
+			},
+		)
+		prometheus.MustRegister(emptyDirScanDurationVec)
+	})
+}
+
+// NewEmptyDirScanner returns the scanner configured through EMPTYDIR_SCAN,
+// KUBELET_PODS_DIR, EMPTYDIR_SCAN_DEPTH, EMPTYDIR_SCAN_TOP,
+// EMPTYDIR_SCAN_INTERVAL, EMPTYDIR_SCAN_BUDGET and EMPTYDIR_SCAN_DEBUG, or nil when scanning is
+// disabled. pods lists the pods whose volumes are scanned. Scanning reads
+// the node's filesystem, so it needs DaemonSet mode.
+func NewEmptyDirScanner(pods func() []pod.PodRef) (*EmptyDirScanner, error) {
+	enabled, _ := strconv.ParseBool(dev.GetEnv("EMPTYDIR_SCAN", "false"))
+	if !enabled {
+		return nil, nil
+	}
+	if !dev.DeployAsDaemonSet() {
+		return nil, fmt.Errorf("EMPTYDIR_SCAN reads the node's kubelet directory and requires DEPLOY_TYPE DaemonSet")
+	}
+	depth, err := strconv.Atoi(dev.GetEnv("EMPTYDIR_SCAN_DEPTH", "2"))
+	if err != nil || depth < 1 {
+		return nil, fmt.Errorf("EMPTYDIR_SCAN_DEPTH: want a positive number, got %q", dev.GetEnv("EMPTYDIR_SCAN_DEPTH", "2"))
+	}
+	top, err := strconv.Atoi(dev.GetEnv("EMPTYDIR_SCAN_TOP", "5"))
+	if err != nil || top < 1 {
+		return nil, fmt.Errorf("EMPTYDIR_SCAN_TOP: want a positive number, got %q", dev.GetEnv("EMPTYDIR_SCAN_TOP", "5"))
+	}
+	interval, err := time.ParseDuration(dev.GetEnv("EMPTYDIR_SCAN_INTERVAL", "5m"))
+	if err != nil {
+		return nil, fmt.Errorf("EMPTYDIR_SCAN_INTERVAL: %w", err)
+	}
+	budget, err := time.ParseDuration(dev.GetEnv("EMPTYDIR_SCAN_BUDGET", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("EMPTYDIR_SCAN_BUDGET: %w", err)
+	}
+	debug, _ := strconv.ParseBool(dev.GetEnv("EMPTYDIR_SCAN_DEBUG", "false"))
+	s := newEmptyDirScanner(dev.GetEnv("KUBELET_PODS_DIR", "/var/lib/kubelet/pods"), dev.CurrentNodeName(), depth, top, interval, budget, pods)
+	s.Debug = debug
+	return s, nil
+}
+
+func newEmptyDirScanner(podsDir string, node string, depth int, top int, interval time.Duration, budget time.Duration, pods func() []pod.PodRef) *EmptyDirScanner {
+	createEmptyDirMetrics()
+	return &EmptyDirScanner{
+		podsDir:  podsDir,
+		node:     node,
+		depth:    depth,
+		top:      top,
+		interval: interval,
+		budget:   budget,
+		pods:     pods,
+		now:      time.Now,
+		results:  map[string]VolumeUsage{},
+		exported: map[string][]prometheus.Labels{},
+	}
+}
+
+// Run scans every interval until stop is closed.
+func (s *EmptyDirScanner) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.Scan()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan makes one pass over the emptyDirs of the tracked pods and updates the
+// metrics and the results served over HTTP. A pass that runs out of budget
+// is resumed by the next one after the last volume it reached, so every
+// volume gets scanned even when a pass cannot cover the node.
+func (s *EmptyDirScanner) Scan() {
+	start := s.now()
+	deadline := start.Add(s.budget)
+
+	type volume struct {
+		key  string // pod uid/volume
+		path string
+		pod  pod.PodRef
+		name string
+	}
+	var volumes []volume
+	for _, p := range s.pods() {
+		volumesDir := filepath.Join(s.podsDir, p.UID, "volumes", emptyDirPlugin)
+		entries, err := os.ReadDir(volumesDir)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Warn().Err(err).Msgf("Failed to list emptyDirs of pod %s/%s", p.Namespace, p.Name)
+			}
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				volumes = append(volumes, volume{key: p.UID + "/" + e.Name(), path: filepath.Join(volumesDir, e.Name()), pod: p, name: e.Name()})
+			}
+		}
+	}
+	slices.SortFunc(volumes, func(a, b volume) int { return strings.Compare(a.key, b.key) })
+	first, _ := slices.BinarySearchFunc(volumes, s.resume, func(v volume, key string) int { return strings.Compare(v.key, key) })
+	volumes = append(volumes[first:], volumes[:first]...)
+
+	s.resume = ""
+	for i, v := range volumes {
+		usage := scanVolume(v.path, s.depth, s.top, deadline, s.now)
+		usage.Pod, usage.Namespace, usage.Volume = v.pod.Name, v.pod.Namespace, v.name
+		usage.ScannedAt = s.now()
+		s.setResult(v.key, usage)
+		if usage.Truncated {
+			if i+1 < len(volumes) {
+				s.resume = volumes[i+1].key
+			}
+			log.Warn().Msgf("emptyDir scan of node %s ran out of its %v budget in %s/%s volume %s, the next pass resumes after it",
+				s.node, s.budget, v.pod.Namespace, v.pod.Name, v.name)
+			break
+		}
+	}
+
+	current := make(map[string]bool, len(volumes))
+	for _, v := range volumes {
+		current[v.key] = true
+	}
+	s.mu.Lock()
+	for key := range s.results {
+		if !current[key] {
+			s.deleteResult(key)
+		}
+	}
+	s.mu.Unlock()
+
+	emptyDirScanDurationVec.With(prometheus.Labels{"node_name": s.node}).Set(s.now().Sub(start).Seconds())
+}
+
+// setResult stores the usage of a volume and replaces its series, keeping
+// those of directories still in its top list.
+func (s *EmptyDirScanner) setResult(key string, usage VolumeUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exported := make([]prometheus.Labels, 0, len(usage.Directories))
+	keep := make(map[string]bool, len(usage.Directories))
+	for _, d := range usage.Directories {
+		labels := prometheus.Labels{"pod_name": usage.Pod, "pod_namespace": usage.Namespace,
+			"node_name": s.node, "volume": usage.Volume, "directory": d.Path}
+		emptyDirDirectoryBytesVec.With(labels).Set(float64(d.Bytes))
+		exported = append(exported, labels)
+		keep[d.Path] = true
+	}
+	for _, labels := range s.exported[key] {
+		if !keep[labels["directory"]] {
+			emptyDirDirectoryBytesVec.Delete(labels)
+		}
+	}
+	s.exported[key] = exported
+	s.results[key] = usage
+}
+
+// deleteResult drops a volume and its series. The caller holds s.mu.
+func (s *EmptyDirScanner) deleteResult(key string) {
+	for _, labels := range s.exported[key] {
+		emptyDirDirectoryBytesVec.Delete(labels)
+	}
+	delete(s.exported, key)
+	delete(s.results, key)
+}
+
+// scanVolume sums the bytes below root per directory up to depth levels and
+// returns the top largest ones. Directories deeper than depth are not
+// walked, so only files up to depth levels below root are counted. Files are
+// counted by their apparent size and symlinks are not followed. The walk
+// stops at deadline.
+func scanVolume(root string, depth int, top int, deadline time.Time, now func() time.Time) VolumeUsage {
+	var usage VolumeUsage
+	sizes := map[string]int64{}
+	walked := 0
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files removed while walking or unreadable directories are skipped.
+			if d != nil && d.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		walked++
+		if walked%256 == 0 && now().After(deadline) {
+			return errBudget
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if d.IsDir() {
+			if path != root && len(parts) > depth {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		usage.Bytes += info.Size()
+
+		// The last part is the file itself, every part before it a directory.
+		for level := 1; level < len(parts) && level <= depth; level++ {
+			sizes[strings.Join(parts[:level], "/")] += info.Size()
+		}
+		return nil
+	})
+	usage.Truncated = errors.Is(err, errBudget)
+
+	for path, bytes := range sizes {
+		usage.Directories = append(usage.Directories, DirUsage{Path: path, Bytes: bytes})
+	}
+	slices.SortFunc(usage.Directories, func(a, b DirUsage) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Path, b.Path))
+	})
+	if len(usage.Directories) > top {
+		usage.Directories = usage.Directories[:top]
+	}
+	return usage
+}
+
+// ServeHTTP writes the results of the last scan as JSON, largest volumes
+// first, optionally narrowed with ?namespace=<namespace>&pod=<pod>.
+func (s *EmptyDirScanner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	namespace, podName := r.URL.Query().Get("namespace"), r.URL.Query().Get("pod")
+	s.mu.RLock()
+	results := make([]VolumeUsage, 0, len(s.results))
+	for _, usage := range s.results {
+		if (namespace == "" || usage.Namespace == namespace) && (podName == "" || usage.Pod == podName) {
+			results = append(results, usage)
+		}
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(results, func(a, b VolumeUsage) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes),
+			strings.Compare(a.Namespace, b.Namespace), strings.Compare(a.Pod, b.Pod), strings.Compare(a.Volume, b.Volume))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Warn().Err(err).Msg("Failed to write emptyDir scan results")
+	}
+}
@@ -0,0 +1,145 @@
+package host
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// writeFile creates path below root with size bytes.
+func writeFile(t *testing.T, root string, path string, size int) {
+	t.Helper()
+	full := filepath.Join(root, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func emptyDir(podsDir string, uid string, volume string) string {
+	return filepath.Join(podsDir, uid, "volumes", emptyDirPlugin, volume)
+}
+
+func TestEmptyDirScanner(t *testing.T) {
+	podsDir := t.TempDir()
+	cache := emptyDir(podsDir, "uid-1", "cache")
+	writeFile(t, cache, "top.bin", 1)
+	writeFile(t, cache, "npm/_cacache/index", 100)
+	writeFile(t, cache, "npm/_cacache/content", 400)
+	writeFile(t, cache, "npm/logs/debug.log", 50)
+	writeFile(t, cache, "pip/wheels/a.whl", 300)
+	writeFile(t, cache, "tmp/x", 10)
+	// Directories below the depth limit are not walked.
+	writeFile(t, cache, "npm/_cacache/tmp/partial", 5000)
+	writeFile(t, emptyDir(podsDir, "uid-1", "scratch"), "data/blob", 20)
+	// Pods that are not tracked and other volume types are not scanned.
+	writeFile(t, emptyDir(podsDir, "uid-other", "cache"), "big", 1000)
+	writeFile(t, filepath.Join(podsDir, "uid-1", "volumes", "kubernetes.io~configmap", "cfg"), "key", 1000)
+
+	pods := []pod.PodRef{{Name: "build", Namespace: "ci", UID: "uid-1"}, {Name: "gone", Namespace: "ci", UID: "uid-missing"}}
+	s := newEmptyDirScanner(podsDir, "node-1", 2, 3, time.Minute, time.Minute, func() []pod.PodRef { return pods })
+	s.Scan()
+
+	got := s.results["uid-1/cache"]
+	want := []DirUsage{{"npm", 550}, {"npm/_cacache", 500}, {"pip", 300}}
+	if got.Bytes != 861 || fmt.Sprint(got.Directories) != fmt.Sprint(want) || got.Truncated {
+		t.Fatalf("cache = %+v, want 861 bytes in %v", got, want)
+	}
+	if len(s.results) != 2 {
+		t.Errorf("%d volumes scanned, want 2", len(s.results))
+	}
+	labels := prometheus.Labels{"pod_name": "build", "pod_namespace": "ci", "node_name": "node-1", "volume": "cache", "directory": "npm/_cacache"}
+	if v := testutil.ToFloat64(emptyDirDirectoryBytesVec.With(labels)); v != 500 {
+		t.Errorf("directory bytes = %v, want 500", v)
+	}
+
+	// Directories leaving the top list and pods leaving the node lose their series.
+	writeFile(t, cache, "tmp/x", 1000)
+	s.Scan()
+	pipLabels := prometheus.Labels{"pod_name": "build", "pod_namespace": "ci", "node_name": "node-1", "volume": "cache", "directory": "pip"}
+	if emptyDirDirectoryBytesVec.Delete(pipLabels) {
+		t.Error("series of a directory out of the top list kept")
+	}
+	pods = nil
+	s.Scan()
+	if n := testutil.CollectAndCount(emptyDirDirectoryBytesVec); n != 0 || len(s.results) != 0 {
+		t.Errorf("%d series and %d results left after the pod left", n, len(s.results))
+	}
+}
+
+func TestEmptyDirScannerBudget(t *testing.T) {
+	podsDir := t.TempDir()
+	for _, volume := range []string{"a", "b"} {
+		for i := range 300 {
+			writeFile(t, emptyDir(podsDir, "uid-1", volume), fmt.Sprintf("d/%03d", i), 1)
+		}
+	}
+	pods := []pod.PodRef{{Name: "p", Namespace: "ns", UID: "uid-1"}}
+	s := newEmptyDirScanner(podsDir, "node-1", 1, 5, time.Minute, time.Minute, func() []pod.PodRef { return pods })
+	// Every pass runs out of budget during its first volume.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	s.now = func() time.Time {
+		calls++
+		if calls == 1 {
+			return start
+		}
+		return start.Add(time.Hour)
+	}
+
+	s.Scan()
+	if a, ok := s.results["uid-1/a"]; !ok || !a.Truncated || a.Bytes >= 300 {
+		t.Fatalf("a = %+v, want a truncated scan", a)
+	}
+	if _, ok := s.results["uid-1/b"]; ok {
+		t.Fatal("b scanned after the budget ran out")
+	}
+
+	calls = 0
+	s.Scan()
+	if b, ok := s.results["uid-1/b"]; !ok || !b.Truncated {
+		t.Fatalf("b = %+v, want the second pass to resume at b", b)
+	}
+	if _, ok := s.results["uid-1/a"]; !ok {
+		t.Error("a lost its previous result")
+	}
+}
+
+func TestEmptyDirScannerServeHTTP(t *testing.T) {
+	s := newEmptyDirScanner(t.TempDir(), "node-1", 2, 5, time.Minute, time.Minute, nil)
+	s.results["uid-1/small"] = VolumeUsage{Pod: "web", Namespace: "team-a", Volume: "small", Bytes: 1}
+	s.results["uid-1/large"] = VolumeUsage{Pod: "web", Namespace: "team-a", Volume: "large", Bytes: 100}
+	s.results["uid-2/cache"] = VolumeUsage{Pod: "db", Namespace: "team-b", Volume: "cache", Bytes: 50}
+
+	for query, want := range map[string][]string{
+		"":                  {"large", "cache", "small"},
+		"?namespace=team-a": {"large", "small"},
+		"?pod=db":           {"cache"},
+	} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/emptydir"+query, nil))
+		var results []VolumeUsage
+		if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+		var volumes []string
+		for _, r := range results {
+			volumes = append(volumes, r.Volume)
+		}
+		if fmt.Sprint(volumes) != fmt.Sprint(want) {
+			t.Errorf("%q: volumes = %v, want %v", query, volumes, want)
+		}
+	}
+}
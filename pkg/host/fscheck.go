@@ -0,0 +1,185 @@
+package host
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+)
+
+// Filesystems compared by the FsChecker, named like the kubelet eviction
+// signals.
+const (
+	NodeFs  = "nodefs"
+	ImageFs = "imagefs"
+)
+
+// fsSample is the available and total bytes of a filesystem.
+type fsSample struct {
+	available float64
+	capacity  float64
+}
+
+// FsChecker statfs's the kubelet root dir and the image filesystem of its
+// node through hostPath mounts and compares them with the filesystem stats
+// of the kubelet summary. Kubelet computes those from the same statfs, so a
+// lasting drift shows broken or lagging stats collection.
+type FsChecker struct {
+	node     string
+	paths    map[string]string // keyed by filesystem
+	interval time.Duration
+	statFs   func(path string) (float64, float64, error)
+
+	mu      sync.Mutex
+	host    map[string]fsSample
+	kubelet map[string]fsSample
+}
+
+var (
+	nodeFsAvailableBytesVec *prometheus.GaugeVec
+	nodeFsCapacityBytesVec  *prometheus.GaugeVec
+	nodeFsDriftBytesVec     *prometheus.GaugeVec
+	fsCheckMetricsOnce      sync.Once
+)
+
+func createFsCheckMetrics() {
+	fsCheckMetricsOnce.Do(func() {
+		labels := []string{
+			// Name of the checked Node.
+			"node_name",
+			// nodefs or imagefs
+			"filesystem",
+			// host for statfs from the exporter, kubelet for the stats summary
+			"source",
+		}
+		nodeFsAvailableBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ephemeral_storage_node_fs_available_bytes",
+			Help: "Available bytes of a node filesystem as seen by statfs on the host and by the kubelet summary",
+		}, labels)
+		prometheus.MustRegister(nodeFsAvailableBytesVec)
+
+		nodeFsCapacityBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ephemeral_storage_node_fs_capacity_bytes",
+			Help: "Capacity bytes of a node filesystem as seen by statfs on the host and by the kubelet summary",
+		}, labels)
+		prometheus.MustRegister(nodeFsCapacityBytesVec)
+
+		nodeFsDriftBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ephemeral_storage_node_fs_drift_bytes",
+			Help: "Available bytes of a node filesystem reported by the kubelet summary minus those statfs reports on the host",
+		},
+			[]string{
+				// Name of the checked Node.
+				"node_name",
+				// nodefs or imagefs
+				"filesystem",
+			},
+		)
+		prometheus.MustRegister(nodeFsDriftBytesVec)
+	})
+}
+
+// NewFsChecker returns the checker configured through FS_CHECK,
+// KUBELET_ROOT_DIR, IMAGE_FS_DIR and FS_CHECK_INTERVAL, or nil when
+// disabled. An empty IMAGE_FS_DIR only checks the kubelet root dir. It
+// statfs's the node's filesystems, so it needs DaemonSet mode on Linux.
+func NewFsChecker() (*FsChecker, error) {
+	enabled, _ := strconv.ParseBool(dev.GetEnv("FS_CHECK", "false"))
+	if !enabled {
+		return nil, nil
+	}
+	if !dev.DeployAsDaemonSet() {
+		return nil, fmt.Errorf("FS_CHECK reads the node's filesystems and requires DEPLOY_TYPE DaemonSet")
+	}
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("FS_CHECK calls statfs and is only supported on Linux")
+	}
+	interval, err := time.ParseDuration(dev.GetEnv("FS_CHECK_INTERVAL", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("FS_CHECK_INTERVAL: %w", err)
+	}
+	paths := map[string]string{NodeFs: dev.GetEnv("KUBELET_ROOT_DIR", "/var/lib/kubelet")}
+	if imageFsDir := dev.GetEnv("IMAGE_FS_DIR", "/var/lib/containerd"); imageFsDir != "" {
+		paths[ImageFs] = imageFsDir
+	}
+	for filesystem, path := range paths {
+		if _, _, err := statFs(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", filesystem, err)
+		}
+	}
+	return newFsChecker(dev.CurrentNodeName(), paths, interval, statFs), nil
+}
+
+func newFsChecker(node string, paths map[string]string, interval time.Duration, statFs func(string) (float64, float64, error)) *FsChecker {
+	createFsCheckMetrics()
+	return &FsChecker{
+		node:     node,
+		paths:    paths,
+		interval: interval,
+		statFs:   statFs,
+		host:     map[string]fsSample{},
+		kubelet:  map[string]fsSample{},
+	}
+}
+
+// Run checks every interval until stop is closed.
+func (c *FsChecker) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.Check()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check statfs's every filesystem and updates the host values and drifts.
+func (c *FsChecker) Check() {
+	for filesystem, path := range c.paths {
+		available, capacity, err := c.statFs(path)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to statfs %s at %s", filesystem, path)
+			continue
+		}
+		c.set(filesystem, "host", fsSample{available: available, capacity: capacity})
+	}
+}
+
+// Observe records the filesystem stats of the kubelet summary of the
+// checker's node. Filesystems without capacity, e.g. from the CRI stats
+// source, or without a host path are ignored.
+func (c *FsChecker) Observe(filesystem string, availableBytes float64, capacityBytes float64) {
+	if _, ok := c.paths[filesystem]; !ok || capacityBytes <= 0 {
+		return
+	}
+	c.set(filesystem, "kubelet", fsSample{available: availableBytes, capacity: capacityBytes})
+}
+
+// set stores a sample and recomputes the drift once both sources are known.
+func (c *FsChecker) set(filesystem string, source string, s fsSample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if source == "host" {
+		c.host[filesystem] = s
+	} else {
+		c.kubelet[filesystem] = s
+	}
+	labels := prometheus.Labels{"node_name": c.node, "filesystem": filesystem, "source": source}
+	nodeFsAvailableBytesVec.With(labels).Set(s.available)
+	nodeFsCapacityBytesVec.With(labels).Set(s.capacity)
+
+	host, hostOK := c.host[filesystem]
+	kubelet, kubeletOK := c.kubelet[filesystem]
+	if hostOK && kubeletOK {
+		nodeFsDriftBytesVec.With(prometheus.Labels{"node_name": c.node, "filesystem": filesystem}).Set(kubelet.available - host.available)
+	}
+}
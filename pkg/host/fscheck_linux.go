@@ -0,0 +1,15 @@
+package host
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// statFs returns the bytes available to unprivileged users and the size of
+// the filesystem holding path, computed like cAdvisor does for kubelet.
+func statFs(path string) (availableBytes float64, capacityBytes float64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return float64(st.Bavail) * float64(st.Frsize), float64(st.Blocks) * float64(st.Frsize), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package host
+
+import (
+	"errors"
+)
+
+func statFs(string) (float64, float64, error) {
+	return 0, 0, errors.New("statfs is only supported on Linux")
+}
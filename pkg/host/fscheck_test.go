@@ -0,0 +1,61 @@
+package host
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFsChecker(t *testing.T) {
+	available := map[string]float64{"/host/kubelet": 40 << 30, "/host/imagefs": 10 << 30}
+	statFs := func(path string) (float64, float64, error) {
+		if path == "/host/broken" {
+			return 0, 0, errors.New("stale mount")
+		}
+		return available[path], 100 << 30, nil
+	}
+	c := newFsChecker("node-1", map[string]string{NodeFs: "/host/kubelet", ImageFs: "/host/imagefs"}, time.Minute, statFs)
+
+	c.Check()
+	// No drift before the kubelet summary was seen.
+	if n := testutil.CollectAndCount(nodeFsDriftBytesVec); n != 0 {
+		t.Errorf("%d drift series before the first summary", n)
+	}
+
+	// The kubelet summary lags 2GiB behind on nodefs and agrees on imagefs.
+	c.Observe(NodeFs, 42<<30, 100<<30)
+	c.Observe(ImageFs, 10<<30, 100<<30)
+	// Sources without filesystem capacity are ignored.
+	c.Observe(ImageFs, 0, 0)
+	c.Observe("containerfs", 1, 1)
+
+	want := `
+# HELP ephemeral_storage_node_fs_drift_bytes Available bytes of a node filesystem reported by the kubelet summary minus those statfs reports on the host
+# TYPE ephemeral_storage_node_fs_drift_bytes gauge
+ephemeral_storage_node_fs_drift_bytes{filesystem="imagefs",node_name="node-1"} 0
+ephemeral_storage_node_fs_drift_bytes{filesystem="nodefs",node_name="node-1"} 2.147483648e+09
+`
+	if err := testutil.CollectAndCompare(nodeFsDriftBytesVec, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(nodeFsAvailableBytesVec); n != 4 {
+		t.Errorf("%d available series, want host and kubelet for both filesystems", n)
+	}
+
+	// The host catches up with the kubelet on the next check.
+	available["/host/kubelet"] = 42 << 30
+	c.Check()
+	if v := testutil.ToFloat64(nodeFsDriftBytesVec.WithLabelValues("node-1", NodeFs)); v != 0 {
+		t.Errorf("nodefs drift = %v after the host caught up, want 0", v)
+	}
+
+	// A failing statfs keeps the last host values.
+	c.paths[NodeFs] = "/host/broken"
+	c.Check()
+	if v := testutil.ToFloat64(nodeFsAvailableBytesVec.WithLabelValues("node-1", NodeFs, "host")); v != 42<<30 {
+		t.Errorf("host available = %v after a failed statfs, want the last value", v)
+	}
+}
@@ -0,0 +1,186 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// kubepodsCgroups are the parents of the pod cgroups with the systemd and
+// the cgroupfs driver.
+var kubepodsCgroups = []string{"kubepods.slice", "kubepods"}
+
+var containerWriteBytesDesc = prometheus.NewDesc(
+	"ephemeral_storage_container_write_bytes_total",
+	"Bytes a container wrote to the devices backing the kubelet root dir, from its cgroup io.stat",
+	[]string{"pod_name", "pod_namespace", "node_name", "container"}, nil,
+)
+
+// IOStatCollector reports the bytes written by each container of the node,
+// read from the io.stat of the container cgroups on every scrape. Unlike
+// usage deltas it shows which container writes heavily while files are also
+// deleted. Writes are counted on the device of the kubelet root dir, or the
+// disk holding it when the filesystem lives on a partition.
+type IOStatCollector struct {
+	cgroupDir string
+	node      string
+	devices   []string // major:minor, in order of preference
+	pods      func() []pod.PodRef
+}
+
+// NewIOStatCollector returns the collector configured through
+// EPHEMERAL_STORAGE_CONTAINER_WRITE_BYTES, CGROUP_DIR and KUBELET_PODS_DIR,
+// or nil when disabled. pods lists the pods cgroups are mapped to. It reads
+// the node's cgroup v2 hierarchy, so it needs DaemonSet mode on Linux.
+func NewIOStatCollector(pods func() []pod.PodRef) (*IOStatCollector, error) {
+	enabled, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_CONTAINER_WRITE_BYTES", "false"))
+	if !enabled {
+		return nil, nil
+	}
+	if !dev.DeployAsDaemonSet() {
+		return nil, fmt.Errorf("EPHEMERAL_STORAGE_CONTAINER_WRITE_BYTES reads the node's cgroups and requires DEPLOY_TYPE DaemonSet")
+	}
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("EPHEMERAL_STORAGE_CONTAINER_WRITE_BYTES reads cgroups and is only supported on Linux")
+	}
+	cgroupDir := dev.GetEnv("CGROUP_DIR", "/sys/fs/cgroup")
+	if _, err := os.Stat(filepath.Join(cgroupDir, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("CGROUP_DIR %s is not a cgroup v2 hierarchy: %w", cgroupDir, err)
+	}
+	device, err := blockDevice(dev.GetEnv("KUBELET_PODS_DIR", "/var/lib/kubelet/pods"))
+	if err != nil {
+		return nil, fmt.Errorf("KUBELET_PODS_DIR: %w", err)
+	}
+	return newIOStatCollector(cgroupDir, dev.CurrentNodeName(), backingDevices("/sys", device), pods), nil
+}
+
+// blockDevice returns the major:minor number of the device holding path,
+// as used by cgroup io.stat and /sys/dev/block, decoded like glibc's
+// major(3) and minor(3).
+func blockDevice(path string) (string, error) {
+	dev, err := deviceOf(path)
+	if err != nil {
+		return "", err
+	}
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
+
+func newIOStatCollector(cgroupDir string, node string, devices []string, pods func() []pod.PodRef) *IOStatCollector {
+	return &IOStatCollector{cgroupDir: cgroupDir, node: node, devices: devices, pods: pods}
+}
+
+// backingDevices returns device followed by its disk when device is a
+// partition: io.stat accounts partition I/O to the whole disk.
+func backingDevices(sysDir string, device string) []string {
+	devices := []string{device}
+	devDir, err := filepath.EvalSymlinks(filepath.Join(sysDir, "dev", "block", device))
+	if err != nil {
+		return devices
+	}
+	if _, err := os.Stat(filepath.Join(devDir, "partition")); err != nil {
+		return devices
+	}
+	disk, err := os.ReadFile(filepath.Join(filepath.Dir(devDir), "dev"))
+	if err != nil {
+		return devices
+	}
+	return append(devices, strings.TrimSpace(string(disk)))
+}
+
+func (c *IOStatCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- containerWriteBytesDesc
+}
+
+func (c *IOStatCollector) Collect(ch chan<- prometheus.Metric) {
+	for labels, written := range c.read() {
+		ch <- prometheus.MustNewConstMetric(containerWriteBytesDesc, prometheus.CounterValue, float64(written),
+			labels.pod, labels.namespace, c.node, labels.container)
+	}
+}
+
+type containerRef struct {
+	pod       string
+	namespace string
+	container string
+}
+
+// read walks the container cgroups below the kubepods cgroup and sums the
+// bytes written per container. A restarted container gets a new cgroup, so
+// its counter starts over like that of a restarted process.
+func (c *IOStatCollector) read() map[containerRef]uint64 {
+	pods := map[string]pod.PodRef{}
+	for _, p := range c.pods() {
+		pods[p.UID] = p
+	}
+	written := map[containerRef]uint64{}
+
+	for _, parent := range kubepodsCgroups {
+		root := filepath.Join(c.cgroupDir, parent)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// Cgroups removed while walking are skipped.
+				if d != nil && d.IsDir() && path != root {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			uid, id, ok := parseCgroupPath(path)
+			if !ok {
+				return nil
+			}
+			if p, ok := pods[uid]; ok {
+				if name, ok := p.Containers[id]; ok {
+					content, err := os.ReadFile(filepath.Join(path, "io.stat"))
+					if err == nil {
+						written[containerRef{p.Name, p.Namespace, name}] += parseIOStat(string(content), c.devices)
+					}
+				}
+			}
+			return fs.SkipDir
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Err(err).Msgf("Failed to walk cgroups below %s", root)
+		}
+	}
+	return written
+}
+
+// parseIOStat returns the bytes written to the first of devices listed in
+// an io.stat, whose lines read e.g.
+// 8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func parseIOStat(content string, devices []string) uint64 {
+	written := map[string]uint64{}
+	for line := range strings.Lines(content) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "wbytes="); ok {
+				written[fields[0]], _ = strconv.ParseUint(value, 10, 64)
+			}
+		}
+	}
+	for _, device := range devices {
+		if value, ok := written[device]; ok {
+			return value
+		}
+	}
+	return 0
+}
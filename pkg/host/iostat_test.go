@@ -0,0 +1,89 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+func TestIOStatCollector(t *testing.T) {
+	cgroupDir := t.TempDir()
+	appID, sidecarID, pauseID := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	podSlice := "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0b1c2d3e_4f50_6172_8394_a5b6c7d8e9f0.slice"
+	for cgroup, ioStat := range map[string]string{
+		podSlice + "/cri-containerd-" + appID + ".scope":     "8:0 rbytes=10 wbytes=1000 rios=1 wios=2 dbytes=0 dios=0\n259:0 rbytes=0 wbytes=7 rios=0 wios=1 dbytes=0 dios=0\n",
+		podSlice + "/cri-containerd-" + sidecarID + ".scope": "253:0 rbytes=0 wbytes=300 rios=0 wios=3 dbytes=0 dios=0\n8:0 rbytes=0 wbytes=400 rios=0 wios=4 dbytes=0 dios=0\n",
+		podSlice + "/cri-containerd-" + pauseID + ".scope":   "8:0 rbytes=0 wbytes=5 rios=0 wios=1 dbytes=0 dios=0\n",
+		podSlice:                          "8:0 rbytes=0 wbytes=1405 rios=0 wios=7 dbytes=0 dios=0\n",
+		"system.slice/containerd.service": "8:0 rbytes=0 wbytes=99 rios=0 wios=1 dbytes=0 dios=0\n",
+		// A pod of another node, its cgroup is not mapped to a known pod.
+		"kubepods/besteffort/podffffffff-4f50-6172-8394-a5b6c7d8e9f0/" + appID: "8:0 rbytes=0 wbytes=99 rios=0 wios=1 dbytes=0 dios=0\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(cgroupDir, cgroup), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(cgroupDir, cgroup, "io.stat"), []byte(ioStat), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pods := []pod.PodRef{{Name: "web", Namespace: "team-a", UID: "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0",
+		Containers: map[string]string{appID: "app", sidecarID: "sidecar"}}}
+	c := newIOStatCollector(cgroupDir, "node-1", []string{"253:0", "8:0"}, func() []pod.PodRef { return pods })
+
+	want := `
+# HELP ephemeral_storage_container_write_bytes_total Bytes a container wrote to the devices backing the kubelet root dir, from its cgroup io.stat
+# TYPE ephemeral_storage_container_write_bytes_total counter
+ephemeral_storage_container_write_bytes_total{container="app",node_name="node-1",pod_name="web",pod_namespace="team-a"} 1000
+ephemeral_storage_container_write_bytes_total{container="sidecar",node_name="node-1",pod_name="web",pod_namespace="team-a"} 300
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackingDevices(t *testing.T) {
+	sysDir := t.TempDir()
+	disk := filepath.Join(sysDir, "devices", "pci0000:00", "block", "sda")
+	for path, content := range map[string]string{
+		filepath.Join(disk, "dev"):                                          "8:0\n",
+		filepath.Join(disk, "sda1", "dev"):                                  "8:1\n",
+		filepath.Join(disk, "sda1", "partition"):                            "1\n",
+		filepath.Join(sysDir, "devices", "virtual", "block", "dm-0", "dev"): "253:0\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := filepath.Join(sysDir, "dev", "block")
+	if err := os.MkdirAll(links, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for device, target := range map[string]string{
+		"8:1":   "../../devices/pci0000:00/block/sda/sda1",
+		"253:0": "../../devices/virtual/block/dm-0",
+	} {
+		if err := os.Symlink(target, filepath.Join(links, device)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for device, want := range map[string][]string{
+		"8:1":   {"8:1", "8:0"},
+		"253:0": {"253:0"},
+		"0:52":  {"0:52"},
+	} {
+		if got := backingDevices(sysDir, device); !slices.Equal(got, want) {
+			t.Errorf("backingDevices(%s) = %v, want %v", device, got, want)
+		}
+	}
+}
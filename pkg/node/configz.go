@@ -0,0 +1,109 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// Kubelet defaults of the container log rotation settings.
+const (
+	defaultContainerLogMaxSize         = "10Mi"
+	defaultContainerLogMaxFiles        = 5
+	defaultContainerLogMonitorInterval = 10 * time.Second
+)
+
+// configz is the part of the kubelet /configz response holding the container
+// log rotation settings.
+type configz struct {
+	KubeletConfig struct {
+		ContainerLogMaxSize         string `json:"containerLogMaxSize"`
+		ContainerLogMaxFiles        *int   `json:"containerLogMaxFiles"`
+		ContainerLogMonitorInterval string `json:"containerLogMonitorInterval"`
+	} `json:"kubeletconfig"`
+}
+
+// parseConfigz reads the log rotation settings from a kubelet /configz
+// response, falling back to the kubelet defaults for unset fields.
+func parseConfigz(content []byte) (pod.LogRotation, error) {
+	var c configz
+	if err := json.Unmarshal(content, &c); err != nil {
+		return pod.LogRotation{}, err
+	}
+	cfg := c.KubeletConfig
+
+	maxSize := cfg.ContainerLogMaxSize
+	if maxSize == "" {
+		maxSize = defaultContainerLogMaxSize
+	}
+	quantity, err := resource.ParseQuantity(maxSize)
+	if err != nil {
+		return pod.LogRotation{}, fmt.Errorf("containerLogMaxSize: %w", err)
+	}
+
+	maxFiles := defaultContainerLogMaxFiles
+	if cfg.ContainerLogMaxFiles != nil {
+		maxFiles = *cfg.ContainerLogMaxFiles
+	}
+
+	monitorInterval := defaultContainerLogMonitorInterval
+	if cfg.ContainerLogMonitorInterval != "" {
+		monitorInterval, err = time.ParseDuration(cfg.ContainerLogMonitorInterval)
+		if err != nil {
+			return pod.LogRotation{}, fmt.Errorf("containerLogMonitorInterval: %w", err)
+		}
+	}
+
+	return pod.LogRotation{MaxBytes: quantity.AsApproximateFloat64(), MaxFiles: maxFiles, MonitorInterval: monitorInterval}, nil
+}
+
+// refreshLogRotation reads the log rotation settings of node from kubelet
+// /configz once per configzRefresh. A failed read keeps the last settings
+// and is retried after the next refresh interval.
+func (n *Node) refreshLogRotation(node string) {
+	if n.configz == nil {
+		return
+	}
+	if fetched, ok := n.configzFetched.Load(node); ok && time.Since(fetched.(time.Time)) < n.configzRefresh {
+		return
+	}
+	n.configzFetched.Store(node, time.Now())
+
+	content, err := n.configz.get(node, "/configz")
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to read kubelet configz of node %s", node)
+		return
+	}
+	rotation, err := parseConfigz(content)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to parse kubelet configz of node %s", node)
+		return
+	}
+	log.Debug().Msgf("Node %s rotates container logs at %v bytes x %d files", node, rotation.MaxBytes, rotation.MaxFiles)
+	pod.SetLogRotation(dev.ClusterKey(n.cluster.Name, node), rotation)
+}
+
+// refreshConfigz keeps the log rotation settings of every scraped node fresh
+// in the background until stop is closed, so scrapes only read the settings
+// cached by pod.SetLogRotation. Nodes are checked every sample interval and
+// read again once their settings are older than configzRefresh.
+func (n *Node) refreshConfigz(stop <-chan struct{}) {
+	ticker := time.NewTicker(max(time.Duration(n.sampleInterval)*time.Second, time.Second))
+	defer ticker.Stop()
+	for {
+		for _, node := range n.Set.ToSlice() {
+			n.refreshLogRotation(node)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+func TestParseConfigz(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    pod.LogRotation
+		wantErr bool
+	}{
+		{
+			name:    "configured",
+			content: `{"kubeletconfig":{"containerLogMaxSize":"50Mi","containerLogMaxFiles":3,"containerLogMonitorInterval":"5s","maxPods":110}}`,
+			want:    pod.LogRotation{MaxBytes: 50 << 20, MaxFiles: 3, MonitorInterval: 5 * time.Second},
+		},
+		{
+			name:    "kubelet defaults",
+			content: `{"kubeletconfig":{}}`,
+			want:    pod.LogRotation{MaxBytes: 10 << 20, MaxFiles: 5, MonitorInterval: 10 * time.Second},
+		},
+		{
+			name:    "decimal size",
+			content: `{"kubeletconfig":{"containerLogMaxSize":"100M","containerLogMaxFiles":2}}`,
+			want:    pod.LogRotation{MaxBytes: 100e6, MaxFiles: 2, MonitorInterval: 10 * time.Second},
+		},
+		{name: "invalid size", content: `{"kubeletconfig":{"containerLogMaxSize":"ten"}}`, wantErr: true},
+		{name: "invalid interval", content: `{"kubeletconfig":{"containerLogMonitorInterval":"soon"}}`, wantErr: true},
+		{name: "not json", content: `<html>`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConfigz([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigz error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseConfigz = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshLogRotation(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/configz" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{"kubeletconfig":{"containerLogMaxSize":"20Mi","containerLogMaxFiles":4}}`))
+	}))
+	defer srv.Close()
+
+	endpoints := &sync.Map{}
+	endpoints.Store("node-1", srv.URL)
+	n := &Node{
+		configz:        &kubeletSource{endpoints: endpoints, client: srv.Client()},
+		configzRefresh: time.Hour,
+		configzFetched: &sync.Map{},
+	}
+
+	n.refreshLogRotation("node-1")
+	n.refreshLogRotation("node-1")
+	if got := requests.Load(); got != 1 {
+		t.Errorf("configz read %d times within the refresh interval, want 1", got)
+	}
+
+	// Failed reads are not retried before the next refresh either.
+	n.refreshLogRotation("unknown")
+	n.refreshLogRotation("unknown")
+	if _, ok := n.configzFetched.Load("unknown"); !ok {
+		t.Error("failed read not recorded")
+	}
+
+	n.configzRefresh = 0
+	n.refreshLogRotation("node-1")
+	if got := requests.Load(); got != 2 {
+		t.Errorf("configz read %d times after the refresh interval, want 2", got)
+	}
+
+	// Without log rotation metrics configz is never read.
+	(&Node{}).refreshLogRotation("node-1")
+	if got := requests.Load(); got != 2 {
+		t.Errorf("configz read %d times while disabled, want 2", got)
+	}
+}
+
+func TestRefreshConfigz(t *testing.T) {
+	read := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read <- r.Host
+		_, _ = w.Write([]byte(`{"kubeletconfig":{}}`))
+	}))
+	defer srv.Close()
+
+	endpoints := &sync.Map{}
+	endpoints.Store("node-1", srv.URL)
+	n := &Node{
+		configz:        &kubeletSource{endpoints: endpoints, client: srv.Client()},
+		configzRefresh: time.Hour,
+		configzFetched: &sync.Map{},
+		Set:            mapset.NewSet("node-1"),
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		n.refreshConfigz(stop)
+		close(done)
+	}()
+
+	// Nodes are read without being scraped.
+	select {
+	case <-read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("configz of node-1 was not read in the background")
+	}
+	close(stop)
+	<-done
+	if len(read) != 0 {
+		t.Errorf("configz read %d more times within the refresh interval, want none", len(read))
+	}
+}
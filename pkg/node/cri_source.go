@@ -0,0 +1,132 @@
+package node
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// Labels the kubelet puts on every container it creates through CRI.
+const (
+	criPodUIDLabel        = "io.kubernetes.pod.uid"
+	criContainerNameLabel = "io.kubernetes.container.name"
+)
+
+// criSource builds a stats summary from the container runtime of the local
+// node over CRI gRPC, so a DaemonSet does not go through the apiserver
+// proxy. CRI reports usage only: filesystem capacity, container logs and
+// emptyDir volumes are not available and stay empty in the returned summary.
+type criSource struct {
+	runtime runtimeapi.RuntimeServiceClient
+	image   runtimeapi.ImageServiceClient
+	timeout time.Duration
+}
+
+// NewCRISource returns a StatsSource reading the runtime listening on
+// endpoint, e.g. unix:///run/containerd/containerd.sock. The connection is
+// established lazily on the first call.
+func NewCRISource(endpoint string, timeout time.Duration) (StatsSource, error) {
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &criSource{
+		runtime: runtimeapi.NewRuntimeServiceClient(conn),
+		image:   runtimeapi.NewImageServiceClient(conn),
+		timeout: timeout,
+	}, nil
+}
+
+func (s *criSource) Summary(node string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	sandboxes, err := s.runtime.ListPodSandboxStats(ctx, &runtimeapi.ListPodSandboxStatsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	containers, err := s.runtime.ListContainerStats(ctx, &runtimeapi.ListContainerStatsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	imageFs, err := s.image.ImageFsInfo(ctx, &runtimeapi.ImageFsInfoRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeSummary(summaryFromCRI(node, sandboxes.GetStats(), containers.GetStats(), imageFs.GetImageFilesystems()))
+}
+
+// summaryFromCRI joins container stats to their pod sandbox through the pod
+// UID label and maps writable layer usage to the container rootfs. Stats of
+// several attempts of the same container are summed, as all their writable
+// layers occupy the node filesystem until the runtime removes them.
+func summaryFromCRI(node string, sandboxes []*runtimeapi.PodSandboxStats, containers []*runtimeapi.ContainerStats, imageFilesystems []*runtimeapi.FilesystemUsage) Summary {
+	var summary Summary
+	summary.Node.NodeName = node
+
+	if len(imageFilesystems) > 0 {
+		imageFs := &FsStats{}
+		for _, fs := range imageFilesystems {
+			imageFs.UsedBytes += float64(fs.GetUsedBytes().GetValue())
+			imageFs.InodesUsed += float64(fs.GetInodesUsed().GetValue())
+		}
+		summary.Node.Runtime.ImageFs = imageFs
+	}
+
+	usage := make(map[string]map[string]*pod.FsStats) // key=podUID then containerName
+	sampled := make(map[string]int64)                 // key=podUID val=newest writable layer timestamp in ns
+	for _, c := range containers {
+		labels := c.GetAttributes().GetLabels()
+		uid := labels[criPodUIDLabel]
+		name := labels[criContainerNameLabel]
+		if name == "" {
+			name = c.GetAttributes().GetMetadata().GetName()
+		}
+		if uid == "" || name == "" {
+			continue
+		}
+		if _, ok := usage[uid]; !ok {
+			usage[uid] = make(map[string]*pod.FsStats)
+		}
+		if _, ok := usage[uid][name]; !ok {
+			usage[uid][name] = &pod.FsStats{}
+		}
+		usage[uid][name].UsedBytes += int(c.GetWritableLayer().GetUsedBytes().GetValue())
+		usage[uid][name].InodesUsed += int64(c.GetWritableLayer().GetInodesUsed().GetValue())
+		sampled[uid] = max(sampled[uid], c.GetWritableLayer().GetTimestamp())
+	}
+
+	seen := make(map[string]struct{}) // key=podUID
+	for _, sandbox := range sandboxes {
+		metadata := sandbox.GetAttributes().GetMetadata()
+		if metadata.GetName() == "" {
+			continue
+		}
+		if _, ok := seen[metadata.GetUid()]; ok {
+			continue
+		}
+		p := PodStats{PodRef: PodReference{Name: metadata.GetName(), Namespace: metadata.GetNamespace()}}
+		if ts := sampled[metadata.GetUid()]; ts > 0 {
+			p.EphemeralStorage.Time = time.Unix(0, ts).UTC()
+		}
+		for name, fs := range usage[metadata.GetUid()] {
+			p.Containers = append(p.Containers, pod.ContainerStats{Name: name, Rootfs: *fs})
+			p.EphemeralStorage.UsedBytes += float64(fs.UsedBytes)
+			p.EphemeralStorage.InodesUsed += float64(fs.InodesUsed)
+		}
+		sort.Slice(p.Containers, func(i, j int) bool { return p.Containers[i].Name < p.Containers[j].Name })
+		seen[metadata.GetUid()] = struct{}{}
+		summary.Pods = append(summary.Pods, p)
+	}
+	sortPods(summary.Pods)
+
+	return summary
+}
@@ -0,0 +1,143 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// fakeCRI serves canned stats for the CRI calls the source makes.
+type fakeCRI struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+	runtimeapi.UnimplementedImageServiceServer
+	sandboxes  []*runtimeapi.PodSandboxStats
+	containers []*runtimeapi.ContainerStats
+	imageFs    []*runtimeapi.FilesystemUsage
+	err        error
+}
+
+func (f *fakeCRI) ListPodSandboxStats(context.Context, *runtimeapi.ListPodSandboxStatsRequest) (*runtimeapi.ListPodSandboxStatsResponse, error) {
+	return &runtimeapi.ListPodSandboxStatsResponse{Stats: f.sandboxes}, f.err
+}
+
+func (f *fakeCRI) ListContainerStats(context.Context, *runtimeapi.ListContainerStatsRequest) (*runtimeapi.ListContainerStatsResponse, error) {
+	return &runtimeapi.ListContainerStatsResponse{Stats: f.containers}, nil
+}
+
+func (f *fakeCRI) ImageFsInfo(context.Context, *runtimeapi.ImageFsInfoRequest) (*runtimeapi.ImageFsInfoResponse, error) {
+	return &runtimeapi.ImageFsInfoResponse{ImageFilesystems: f.imageFs}, nil
+}
+
+// startFakeCRI serves f on a unix socket in a temp dir and returns its endpoint.
+func startFakeCRI(t *testing.T, f *fakeCRI) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "cri.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(srv, f)
+	runtimeapi.RegisterImageServiceServer(srv, f)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return "unix://" + socket
+}
+
+func sandboxStats(uid, name, namespace string) *runtimeapi.PodSandboxStats {
+	return &runtimeapi.PodSandboxStats{Attributes: &runtimeapi.PodSandboxAttributes{
+		Id:       "sandbox-" + uid,
+		Metadata: &runtimeapi.PodSandboxMetadata{Name: name, Namespace: namespace, Uid: uid},
+	}}
+}
+
+func containerStats(uid, name string, usedBytes, inodesUsed uint64) *runtimeapi.ContainerStats {
+	return &runtimeapi.ContainerStats{
+		Attributes: &runtimeapi.ContainerAttributes{
+			Metadata: &runtimeapi.ContainerMetadata{Name: name},
+			Labels:   map[string]string{criPodUIDLabel: uid, criContainerNameLabel: name},
+		},
+		WritableLayer: &runtimeapi.FilesystemUsage{
+			UsedBytes:  &runtimeapi.UInt64Value{Value: usedBytes},
+			InodesUsed: &runtimeapi.UInt64Value{Value: inodesUsed},
+		},
+	}
+}
+
+func TestCRISource(t *testing.T) {
+	f := &fakeCRI{
+		sandboxes: []*runtimeapi.PodSandboxStats{
+			sandboxStats("uid-web", "web-0", "default"),
+			sandboxStats("uid-db", "db-0", "data"),
+		},
+		containers: []*runtimeapi.ContainerStats{
+			containerStats("uid-web", "app", 1000, 10),
+			containerStats("uid-web", "sidecar", 500, 5),
+			// A restarted attempt of app still holds its writable layer.
+			containerStats("uid-web", "app", 24, 1),
+			// Containers without kubelet labels are not attributable to a pod.
+			{Attributes: &runtimeapi.ContainerAttributes{Metadata: &runtimeapi.ContainerMetadata{Name: "unmanaged"}}},
+		},
+		imageFs: []*runtimeapi.FilesystemUsage{{
+			FsId:       &runtimeapi.FilesystemIdentifier{Mountpoint: "/var/lib/containerd"},
+			UsedBytes:  &runtimeapi.UInt64Value{Value: 1 << 30},
+			InodesUsed: &runtimeapi.UInt64Value{Value: 2000},
+		}},
+	}
+	source, err := NewCRISource(startFakeCRI(t, f), 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewCRISource: %v", err)
+	}
+
+	content, err := readAll(source.Summary("node-1"))
+	if err != nil {
+		t.Fatalf("Summary: %v", err)
+	}
+	var summary Summary
+	if err := json.Unmarshal(content, &summary); err != nil {
+		t.Fatalf("decode summary: %v", err)
+	}
+
+	if summary.Node.NodeName != "node-1" {
+		t.Errorf("node name = %q, want node-1", summary.Node.NodeName)
+	}
+	if want := (&FsStats{UsedBytes: 1 << 30, InodesUsed: 2000}); !reflect.DeepEqual(summary.Node.Runtime.ImageFs, want) {
+		t.Errorf("imageFs = %+v, want %+v", summary.Node.Runtime.ImageFs, want)
+	}
+	want := []PodStats{
+		{PodRef: PodReference{Name: "db-0", Namespace: "data"}},
+		{
+			PodRef:           PodReference{Name: "web-0", Namespace: "default"},
+			EphemeralStorage: FsStats{UsedBytes: 1524, InodesUsed: 16},
+			Containers: []pod.ContainerStats{
+				{Name: "app", Rootfs: pod.FsStats{UsedBytes: 1024, InodesUsed: 11}},
+				{Name: "sidecar", Rootfs: pod.FsStats{UsedBytes: 500, InodesUsed: 5}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(summary.Pods, want) {
+		t.Errorf("pods = %+v\nwant %+v", summary.Pods, want)
+	}
+}
+
+func TestCRISourceError(t *testing.T) {
+	f := &fakeCRI{err: status.Error(codes.Unavailable, "runtime not ready")}
+	source, err := NewCRISource(startFakeCRI(t, f), 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewCRISource: %v", err)
+	}
+	if _, err := source.Summary("node-1"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
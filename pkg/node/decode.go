@@ -0,0 +1,161 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrSummaryTooLarge is returned while reading a stats summary larger than
+// the configured MAX_SUMMARY_BYTES.
+var ErrSummaryTooLarge = errors.New("stats summary exceeds the maximum size")
+
+// bufferPool holds the buffers summaries are encoded into by sources that
+// build them in memory, so every scrape does not allocate a new one.
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// GetBuffer returns an empty buffer from the pool shared with the sources.
+func GetBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// PutBuffer hands buf back to the pool; the caller must not use it anymore.
+func PutBuffer(buf *bytes.Buffer) {
+	bufferPool.Put(buf)
+}
+
+// pooledBody serves a pooled buffer and returns it to the pool on Close.
+type pooledBody struct {
+	*bytes.Buffer
+}
+
+func (b pooledBody) Close() error {
+	PutBuffer(b.Buffer)
+	return nil
+}
+
+// encodeSummary encodes summary into a pooled buffer for sources that build
+// it from other APIs.
+func encodeSummary(summary Summary) (io.ReadCloser, error) {
+	buf := GetBuffer()
+	if err := json.NewEncoder(buf).Encode(summary); err != nil {
+		PutBuffer(buf)
+		return nil, err
+	}
+	return pooledBody{buf}, nil
+}
+
+// limitedBody fails reads with ErrSummaryTooLarge once more than remaining
+// bytes were read, instead of silently truncating like io.LimitReader.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrSummaryTooLarge
+	}
+	// Read one byte past the limit to tell a body of exactly the maximum size
+	// from a larger one.
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if int64(n) > l.remaining {
+		// Never hand out bytes past the limit, a truncated summary must not
+		// decode.
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, ErrSummaryTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// DecodeSummary streams a stats summary from r. Pods are decoded one at a
+// time and handed to visit, so the raw summary is never held in memory as a
+// whole; the CPU, memory, network and other sections the exporter does not
+// read are skipped without being decoded. The node section is returned once
+// the summary is read. Every call allocates its own decoder, only the buffers
+// of in-memory sources are pooled.
+func DecodeSummary(r io.Reader, visit func(*PodStats) error) (NodeStats, error) {
+	var nodeStats NodeStats
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nodeStats, err
+	}
+	if tok == nil {
+		return nodeStats, nil
+	}
+	if tok != json.Delim('{') {
+		return nodeStats, fmt.Errorf("stats summary: expected an object, got %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nodeStats, err
+		}
+		switch tok {
+		case "node":
+			err = dec.Decode(&nodeStats)
+		case "pods":
+			err = decodePods(dec, visit)
+		default:
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return nodeStats, err
+		}
+	}
+	_, err = dec.Token()
+	return nodeStats, err
+}
+
+func decodePods(dec *json.Decoder, visit func(*PodStats) error) error {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("stats summary: expected a pods array, got %v", tok)
+	}
+	for dec.More() {
+		var p PodStats
+		if err := dec.Decode(&p); err != nil {
+			return err
+		}
+		if err := visit(&p); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// skipValue reads past the next value without decoding it.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
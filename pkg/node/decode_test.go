@@ -0,0 +1,222 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// kubeletSummary builds a stats summary shaped like the kubelet's, including
+// the CPU, memory, network and swap sections the exporter skips.
+func kubeletSummary(pods int) []byte {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	cpu := map[string]any{"time": now, "usageNanoCores": 123456, "usageCoreNanoSeconds": 987654321}
+	memory := map[string]any{"time": now, "availableBytes": 1 << 30, "usageBytes": 1 << 28, "workingSetBytes": 1 << 27, "rssBytes": 1 << 26, "pageFaults": 1000, "majorPageFaults": 3}
+	fs := map[string]any{"time": now, "availableBytes": 50 << 30, "capacityBytes": 100 << 30, "usedBytes": 1 << 20, "inodesFree": 1000000, "inodes": 2000000, "inodesUsed": 42}
+	network := map[string]any{"time": now, "name": "eth0", "rxBytes": 1 << 30, "rxErrors": 0, "txBytes": 1 << 29, "txErrors": 0,
+		"interfaces": []any{map[string]any{"name": "eth0", "rxBytes": 1 << 30, "rxErrors": 0, "txBytes": 1 << 29, "txErrors": 0}}}
+
+	var podList []any
+	for p := 0; p < pods; p++ {
+		var containers []any
+		for c := 0; c < 3; c++ {
+			containers = append(containers, map[string]any{
+				"name": fmt.Sprintf("container-%d", c), "startTime": now,
+				"cpu": cpu, "memory": memory, "rootfs": fs, "logs": fs, "swap": map[string]any{"time": now, "swapUsageBytes": 0},
+			})
+		}
+		podList = append(podList, map[string]any{
+			"podRef":     map[string]any{"name": fmt.Sprintf("pod-%d", p), "namespace": "default", "uid": fmt.Sprintf("uid-%d", p)},
+			"startTime":  now,
+			"containers": containers,
+			"cpu":        cpu, "memory": memory, "network": network,
+			"volume": []any{
+				map[string]any{"name": "kube-api-access", "time": now, "availableBytes": 1 << 20, "capacityBytes": 1 << 20, "usedBytes": 12288},
+				map[string]any{"name": "scratch", "time": now, "availableBytes": 50 << 30, "capacityBytes": 100 << 30, "usedBytes": 4096},
+			},
+			"ephemeral-storage": fs,
+			"process_stats":     map[string]any{"process_count": 4},
+		})
+	}
+	content, err := json.Marshal(map[string]any{
+		"node": map[string]any{
+			"nodeName": "node-1",
+			"systemContainers": []any{
+				map[string]any{"name": "kubelet", "startTime": now, "cpu": cpu, "memory": memory},
+				map[string]any{"name": "runtime", "startTime": now, "cpu": cpu, "memory": memory},
+			},
+			"startTime": now, "cpu": cpu, "memory": memory, "network": network, "fs": fs,
+			"runtime": map[string]any{"imageFs": fs, "containerFs": fs},
+			"rlimit":  map[string]any{"time": now, "maxpid": 4194304, "curproc": 1500},
+		},
+		"pods": podList,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return content
+}
+
+func TestDecodeSummary(t *testing.T) {
+	content := kubeletSummary(3)
+
+	var want Summary
+	if err := json.Unmarshal(content, &want); err != nil {
+		t.Fatal(err)
+	}
+	var got Summary
+	nodeStats, err := DecodeSummary(bytes.NewReader(content), func(p *PodStats) error {
+		got.Pods = append(got.Pods, *p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DecodeSummary: %v", err)
+	}
+	got.Node = nodeStats
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("streamed summary differs from unmarshalled one:\n got %+v\nwant %+v", got, want)
+	}
+	if len(got.Pods) != 3 || got.Pods[2].PodRef.Name != "pod-2" || got.Node.Fs == nil || got.Node.Runtime.ImageFs == nil {
+		t.Errorf("unexpected summary %+v", got)
+	}
+	if len(got.Pods[0].Containers) != 3 || got.Pods[0].Containers[0].Rootfs.UsedBytes != 1<<20 || len(got.Pods[0].Volumes) != 2 {
+		t.Errorf("unexpected pod %+v", got.Pods[0])
+	}
+}
+
+func TestDecodeSummaryEdgeCases(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		pods    int
+		wantErr bool
+	}{
+		{"empty object", `{}`, 0, false},
+		{"null", `null`, 0, false},
+		{"null pods", `{"node":{"nodeName":"n"},"pods":null}`, 0, false},
+		{"pods before node", `{"pods":[{"podRef":{"name":"a"}}],"node":{"nodeName":"n"}}`, 1, false},
+		{"unknown scalar sections", `{"version":1,"pods":[],"ok":true}`, 0, false},
+		{"truncated", `{"pods": [`, 0, true},
+		{"not an object", `[]`, 0, true},
+		{"pods not an array", `{"pods":{}}`, 0, true},
+		{"empty", ``, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods := 0
+			_, err := DecodeSummary(strings.NewReader(tt.content), func(*PodStats) error {
+				pods++
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if pods != tt.pods {
+				t.Errorf("visited %d pods, want %d", pods, tt.pods)
+			}
+		})
+	}
+
+	boom := errors.New("boom")
+	if _, err := DecodeSummary(strings.NewReader(`{"pods":[{},{}]}`), func(*PodStats) error { return boom }); !errors.Is(err, boom) {
+		t.Errorf("visit error not returned: %v", err)
+	}
+}
+
+func TestQueryLimitsSummarySize(t *testing.T) {
+	content := kubeletSummary(2)
+	for _, tt := range []struct {
+		name     string
+		maxBytes int64
+		wantErr  error
+	}{
+		{"unlimited", 0, nil},
+		{"exactly the limit", int64(len(content)), nil},
+		{"over the limit", int64(len(content)) - 1, ErrSummaryTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Node{sampleInterval: 1, maxSummaryBytes: tt.maxBytes, Source: &fakeSource{content: content}, Set: mapset.NewSet[string]()}
+			body, err := n.Query("node-1")
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			defer body.Close()
+			if _, err := DecodeSummary(body, func(*PodStats) error { return nil }); !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecodeSummary err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeSummaryReusesBuffer(t *testing.T) {
+	summary := Summary{Pods: []PodStats{{PodRef: PodReference{Name: "a", Namespace: "b"}, Containers: []pod.ContainerStats{{Name: "c"}}}}}
+	body, err := encodeSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(body)
+	var got Summary
+	if err := json.Unmarshal(content, &got); err != nil || !reflect.DeepEqual(got.Pods, summary.Pods) {
+		t.Errorf("round trip = %+v, %v", got, err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf := GetBuffer(); buf.Len() != 0 {
+		t.Errorf("pooled buffer not reset: %d bytes", buf.Len())
+	}
+}
+
+func benchmarkSummary(b *testing.B, pods int, decode func([]byte) error) {
+	content := kubeletSummary(pods)
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := decode(content); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// decodeStream mirrors a scrape: the body is read through a reader rather
+// than being available as a whole.
+func decodeStream(content []byte) error {
+	_, err := DecodeSummary(io.NopCloser(bytes.NewReader(content)), func(*PodStats) error { return nil })
+	return err
+}
+
+// decodeReadAll is how summaries were decoded before streaming.
+func decodeReadAll(content []byte) error {
+	raw, err := io.ReadAll(io.NopCloser(bytes.NewReader(content)))
+	if err != nil {
+		return err
+	}
+	var summary Summary
+	return json.Unmarshal(raw, &summary)
+}
+
+func BenchmarkDecodeSummary110Pods(b *testing.B) {
+	benchmarkSummary(b, 110, decodeStream)
+}
+
+func BenchmarkDecodeSummary500Pods(b *testing.B) {
+	benchmarkSummary(b, 500, decodeStream)
+}
+
+func BenchmarkReadAllUnmarshalSummary110Pods(b *testing.B) {
+	benchmarkSummary(b, 110, decodeReadAll)
+}
+
+func BenchmarkReadAllUnmarshalSummary500Pods(b *testing.B) {
+	benchmarkSummary(b, 500, decodeReadAll)
+}
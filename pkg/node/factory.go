@@ -5,11 +5,14 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/sample"
 )
 
 var (
@@ -23,34 +26,81 @@ type Node struct {
 	nodeAvailable           bool
 	nodeCapacity            bool
 	nodePercentage          bool
+	nodeImageFs             bool
 	sampleInterval          int64
 	scrapeFromKubelet       bool
 	kubeletReadOnlyPort     int
 	nodeLabelSelector       string
+	statsSource             string
+	criEndpoint             string
+	maxSummaryBytes         int64
+	scrapeFailureTolerance  int
+	configz                 kubeletTransport // nil unless log rotation metrics are enabled
+	configzRefresh          time.Duration
+	configzFetched          *sync.Map // key=nodeName val=time.Time of the last configz read
 	Set                     mapset.Set[string]
 	KubeletEndpoint         *sync.Map // key=nodeName val=kubeletEndpoint
+	Source                  StatsSource
 	WaitGroup               *sync.WaitGroup
+	cluster                 dev.Cluster
 }
 
+// NewCollector returns the collector of the cluster of KUBECONFIG or the one
+// the exporter runs in.
 func NewCollector(sampleInterval int64) Node {
+	return NewClusterCollectors(sampleInterval, []dev.Cluster{dev.DefaultCluster()})[0]
+}
+
+// NewClusterCollectors returns a collector per cluster. They share the
+// configuration and the metric families, but each one tracks and scrapes
+// the nodes of its cluster with its own clients.
+func NewClusterCollectors(sampleInterval int64, clusters []dev.Cluster) []Node {
 
 	adjustedPollingRate, _ := strconv.ParseBool(dev.GetEnv("ADJUSTED_POLLING_RATE", "false"))
 	deployType := dev.GetEnv("DEPLOY_TYPE", "DaemonSet")
 	nodeAvailable, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_NODE_AVAILABLE", "false"))
 	nodeCapacity, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_NODE_CAPACITY", "false"))
 	nodePercentage, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_NODE_PERCENTAGE", "false"))
-	maxNodeQueryConcurrency, _ := strconv.Atoi(dev.GetEnv("MAX_NODE_CONCURRENCY", "10"))
+	nodeImageFs, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_NODE_IMAGE_FS", "false"))
+	maxNodeQueryConcurrencyEnv := dev.GetEnv("MAX_NODE_CONCURRENCY", "10")
+	maxNodeQueryConcurrency, err := strconv.Atoi(maxNodeQueryConcurrencyEnv)
+	if err != nil || maxNodeQueryConcurrency < 1 {
+		log.Error().Msg(fmt.Sprintf("MAX_NODE_CONCURRENCY must be a positive integer, got %s", maxNodeQueryConcurrencyEnv))
+		os.Exit(1)
+	}
 	scrapeFromKubelet, _ := strconv.ParseBool(dev.GetEnv("SCRAPE_FROM_KUBELET", "false"))
 	kubeletReadOnlyPort, _ := strconv.Atoi(dev.GetEnv("KUBELET_READONLY_PORT", "0"))
 	nodeLabelSelector := dev.GetEnv("NODE_LABEL_SELECTOR", "")
-	set := mapset.NewSet[string]()
-	mp := &sync.Map{}
-
+	statsSource := dev.GetEnv("STATS_SOURCE", "summary")
+	criEndpoint := dev.GetEnv("CRI_RUNTIME_ENDPOINT", "unix:///run/containerd/containerd.sock")
+	maxSummaryBytesEnv := dev.GetEnv("MAX_SUMMARY_BYTES", "33554432")
+	maxSummaryBytes, err := strconv.ParseInt(maxSummaryBytesEnv, 10, 64)
+	if err != nil || maxSummaryBytes < 1 {
+		log.Error().Msg(fmt.Sprintf("MAX_SUMMARY_BYTES must be a positive integer, got %s", maxSummaryBytesEnv))
+		os.Exit(1)
+	}
+	sampleTimestamps, _ := strconv.ParseBool(dev.GetEnv("SAMPLE_TIMESTAMPS", "false"))
+	scrapeFailureToleranceEnv := dev.GetEnv("SCRAPE_FAILURE_TOLERANCE", "3")
+	scrapeFailureTolerance, err := strconv.Atoi(scrapeFailureToleranceEnv)
+	if err != nil || scrapeFailureTolerance < 1 {
+		log.Error().Msg(fmt.Sprintf("SCRAPE_FAILURE_TOLERANCE must be a positive integer, got %s", scrapeFailureToleranceEnv))
+		os.Exit(1)
+	}
+	logsRotation, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_CONTAINER_LOGS_ROTATION", "false"))
+	configzRefresh, err := time.ParseDuration(dev.GetEnv("CONFIGZ_REFRESH_INTERVAL", "10m"))
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid CONFIGZ_REFRESH_INTERVAL")
+		os.Exit(1)
+	}
 	if deployType != "Deployment" && deployType != "DaemonSet" {
 		log.Error().Msg(fmt.Sprintf("deployType must be 'Deployment' or 'DaemonSet', got %s", deployType))
 		os.Exit(1)
 	}
 
+	checkStatsSource(statsSource, deployType)
+
+	sample.SetTimestamps(sampleTimestamps)
+
 	node := Node{
 		AdjustedPollingRate:     adjustedPollingRate,
 		deployType:              deployType,
@@ -58,21 +108,70 @@ func NewCollector(sampleInterval int64) Node {
 		nodeAvailable:           nodeAvailable,
 		nodeCapacity:            nodeCapacity,
 		nodePercentage:          nodePercentage,
+		nodeImageFs:             nodeImageFs,
 		sampleInterval:          sampleInterval,
 		scrapeFromKubelet:       scrapeFromKubelet,
 		kubeletReadOnlyPort:     kubeletReadOnlyPort,
 		nodeLabelSelector:       nodeLabelSelector,
-		Set:                     set,
-		KubeletEndpoint:         mp,
+		statsSource:             statsSource,
+		criEndpoint:             criEndpoint,
+		maxSummaryBytes:         maxSummaryBytes,
+		scrapeFailureTolerance:  scrapeFailureTolerance,
+		configzRefresh:          configzRefresh,
 		WaitGroup:               &waitGroup,
 	}
 	node.createMetrics()
 
-	if node.deployType != "Deployment" {
-		node.Set.Add(dev.GetEnv("CURRENT_NODE_NAME", ""))
+	nodes := make([]Node, 0, len(clusters))
+	for _, cluster := range clusters {
+		n := node
+		n.cluster = cluster
+		if cluster.StatsSource != "" {
+			checkStatsSource(cluster.StatsSource, deployType)
+			n.statsSource = cluster.StatsSource
+		}
+		n.configzFetched = &sync.Map{}
+		n.Set = mapset.NewSet[string]()
+		n.KubeletEndpoint = &sync.Map{}
+		n.Source = n.newStatsSource()
+		if logsRotation && dev.ReplayPath() == "" {
+			n.configz = n.newKubeletTransport()
+		}
+
+		if n.deployType != "Deployment" {
+			n.Set.Add(dev.GetEnv("CURRENT_NODE_NAME", ""))
+		}
+		if n.configz != nil {
+			go n.refreshConfigz(make(chan struct{}))
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+// checkStatsSource exits unless statsSource is a stats source the
+// exporter can read in deployType.
+func checkStatsSource(statsSource string, deployType string) {
+	if statsSource != "summary" && statsSource != "metrics" && statsSource != "cri" {
+		log.Error().Msg(fmt.Sprintf("statsSource must be 'summary', 'metrics' or 'cri', got %s", statsSource))
+		os.Exit(1)
+	}
+
+	if statsSource == "cri" && deployType != "DaemonSet" {
+		log.Error().Msg("statsSource 'cri' reads the local container runtime and requires deployType 'DaemonSet'")
+		os.Exit(1)
 	}
+}
 
-	return node
+// labels returns the labels of the series of nodeName, with the cluster
+// label while several clusters are scraped.
+func (n *Node) labels(nodeName string) prometheus.Labels {
+	labels := prometheus.Labels{"node_name": nodeName}
+	if n.cluster.Name != "" {
+		labels["cluster"] = n.cluster.Name
+	}
+	return labels
 }
 
 // watchStarter is the function StartWatch uses to begin watching. It is a
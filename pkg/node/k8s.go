@@ -1,12 +1,9 @@
 package node
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
-	"os"
 	"strconv"
 	"time"
 
@@ -41,58 +38,34 @@ func checkKubeletStatus(nodeStatusConditions *[]v1.NodeCondition) bool {
 	return false
 }
 
-func (n *Node) Query(node string) ([]byte, error) {
-	var content []byte
+// Query opens the stats summary of node, bounded by MAX_SUMMARY_BYTES. The
+// caller streams it through DecodeSummary, reports the outcome through
+// Decoded and closes it.
+func (n *Node) Query(node string) (io.ReadCloser, error) {
+	var body io.ReadCloser
 
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxInterval = 1 * time.Second
 	bo.MaxElapsedTime = time.Duration(n.sampleInterval) * time.Second
 
 	operation := func() error {
-		var resp *http.Response
 		var err error
-		if !n.scrapeFromKubelet || n.deployType != "Deployment" {
-			content, err = dev.Clientset.RESTClient().Get().AbsPath(fmt.Sprintf("/api/v1/nodes/%s/proxy/stats/summary", node)).DoRaw(context.Background())
-			if err != nil {
-				return err
-			}
-		} else {
-			kubeletep, ok := n.KubeletEndpoint.Load(node)
-			if !ok || kubeletep == "" {
-				return fmt.Errorf("kubelet endpoint not found for node: %s", node)
-			}
-			if n.kubeletReadOnlyPort > 0 {
-				if resp, err = dev.ClientAno.Get(fmt.Sprintf("%s/stats/summary", kubeletep.(string))); err != nil {
-					return err
-				}
-			} else {
-				if resp, err = dev.ClientRaw.Get(fmt.Sprintf("%s/stats/summary", kubeletep.(string))); err != nil {
-					return err
-				}
-			}
-			defer resp.Body.Close()
-			content, err = io.ReadAll(resp.Body)
-			if err != nil {
-				return err
-			}
-			if resp.StatusCode != http.StatusOK {
-				return fmt.Errorf("failed to scrape from kubelet endpoint: unexpected status code %d: %s", resp.StatusCode, string(content))
-			}
-		}
-		return nil
+		body, err = n.Source.Summary(node)
+		return err
 	}
 
 	err := backoff.Retry(operation, bo)
 
 	if err != nil {
 		log.Warn().Msg(fmt.Sprintf("Failed to fetched proxy stats from node: %s Error: %v", node, err))
-		// Assume the node status is not ready so evict all pods tracked by that node. The Update func in the Node Watcher
-		// will pick the node back up for monitoring again, once the kubelet status reports back ready.
-		n.evict(node)
+		n.scrapeFailed(node)
 		return nil, err
 	}
 
-	return content, nil
+	if n.maxSummaryBytes > 0 {
+		body = &limitedBody{ReadCloser: body, remaining: n.maxSummaryBytes}
+	}
+	return body, nil
 
 }
 
@@ -102,11 +75,11 @@ func (n *Node) Watch() {
 	// TODO: break out the sampleInterval into Groups. E.g. nodeSampleInterval, podSampleInterval, metricsSampleInterval
 	var sharedInformerFactory informers.SharedInformerFactory
 	if n.nodeLabelSelector != "" {
-		sharedInformerFactory = informers.NewSharedInformerFactoryWithOptions(dev.Clientset, time.Duration(n.sampleInterval)*time.Second, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		sharedInformerFactory = informers.NewSharedInformerFactoryWithOptions(n.cluster.Clientset, time.Duration(n.sampleInterval)*time.Second, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
 			options.LabelSelector = n.nodeLabelSelector
 		}))
 	} else {
-		sharedInformerFactory = informers.NewSharedInformerFactory(dev.Clientset, time.Duration(n.sampleInterval)*time.Second)
+		sharedInformerFactory = informers.NewSharedInformerFactory(n.cluster.Clientset, time.Duration(n.sampleInterval)*time.Second)
 	}
 	nodeInformer := sharedInformerFactory.Core().V1().Nodes().Informer()
 
@@ -165,8 +138,8 @@ func (n *Node) Watch() {
 	// Register the event handlers with the informer
 	_, err := nodeInformer.AddEventHandler(eventHandler)
 	if err != nil {
-		log.Err(err)
-		os.Exit(1)
+		dev.WatchStopped(n.cluster.Name, "NodeWatch", err)
+		return
 	}
 
 	// Start the informer to begin watching for Node events
@@ -176,8 +149,8 @@ func (n *Node) Watch() {
 		time.Sleep(time.Duration(n.sampleInterval) * time.Second)
 		select {
 		case <-stopCh:
-			log.Error().Msg("Watcher NodeWatch stopped.")
-			os.Exit(1)
+			dev.WatchStopped(n.cluster.Name, "NodeWatch", nil)
+			return
 		}
 	}
 }
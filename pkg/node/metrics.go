@@ -3,8 +3,11 @@ package node
 import (
 	"fmt"
 	"math"
+	"sync"
 
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/sample"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
@@ -14,6 +17,15 @@ var (
 	nodeAvailableGaugeVec       *prometheus.GaugeVec
 	nodeCapacityGaugeVec        *prometheus.GaugeVec
 	nodePercentageGaugeVec      *prometheus.GaugeVec
+	nodeImageFsGaugeVec         *prometheus.GaugeVec
+	nodeScrapeStaleGaugeVec     *prometheus.GaugeVec
+
+	// scrapeFailures counts the consecutive failed scrapes of each node,
+	// keyed by cluster and node name.
+	scrapeFailures = struct {
+		sync.Mutex
+		count map[string]int
+	}{count: map[string]int{}}
 )
 
 func (n *Node) createMetrics() {
@@ -22,47 +34,73 @@ func (n *Node) createMetrics() {
 		Name: "ephemeral_storage_node_available",
 		Help: "Available ephemeral storage for a node",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// Name of Node where pod is placed.
 			"node_name",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(nodeAvailableGaugeVec)
+	sample.MustRegister(nodeAvailableGaugeVec)
 
 	nodeCapacityGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_node_capacity",
 		Help: "Capacity of ephemeral storage for a node",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// Name of Node where pod is placed.
 			"node_name",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(nodeCapacityGaugeVec)
+	sample.MustRegister(nodeCapacityGaugeVec)
 
 	nodePercentageGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_node_percentage",
 		Help: "Percentage of ephemeral storage used on a node",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// Name of Node where pod is placed.
 			"node_name",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(nodePercentageGaugeVec)
+	sample.MustRegister(nodePercentageGaugeVec)
+
+	nodeScrapeStaleGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_node_scrape_stale",
+		Help: "1 while the last scrapes of a node failed and its metrics hold the last scraped values, 0 otherwise",
+	},
+		dev.WithClusterLabel([]string{
+			// Name of Node where pod is placed.
+			"node_name",
+		}),
+	)
+
+	prometheus.MustRegister(nodeScrapeStaleGaugeVec)
+	prometheus.MustRegister(sample.NewAgeCollector())
+
+	if n.nodeImageFs {
+		nodeImageFsGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ephemeral_storage_node_image_fs_used_bytes",
+			Help: "Bytes used by container images in the runtime image filesystem of a node",
+		},
+			dev.WithClusterLabel([]string{
+				// Name of Node where pod is placed.
+				"node_name",
+			}))
+
+		sample.MustRegister(nodeImageFsGaugeVec)
+	}
 
 	if n.AdjustedPollingRate {
 		AdjustedPollingRateGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "ephemeral_storage_adjusted_polling_rate",
 			Help: "AdjustTime polling rate time after a Node API queries in Milliseconds",
 		},
-			[]string{
+			dev.WithClusterLabel([]string{
 				// Name of Node where pod is placed.
 				"node_name",
-			})
+			}))
 
 		prometheus.MustRegister(AdjustedPollingRateGaugeVec)
 	}
@@ -72,12 +110,12 @@ func (n *Node) createMetrics() {
 func (n *Node) SetMetrics(nodeName string, availableBytes float64, capacityBytes float64) {
 
 	if n.nodeAvailable {
-		nodeAvailableGaugeVec.With(prometheus.Labels{"node_name": nodeName}).Set(availableBytes)
+		nodeAvailableGaugeVec.With(n.labels(nodeName)).Set(availableBytes)
 		log.Debug().Msg(fmt.Sprintf("Node: %s available bytes: %f", nodeName, availableBytes))
 	}
 
 	if n.nodeCapacity {
-		nodeCapacityGaugeVec.With(prometheus.Labels{"node_name": nodeName}).Set(capacityBytes)
+		nodeCapacityGaugeVec.With(n.labels(nodeName)).Set(capacityBytes)
 		log.Debug().Msg(fmt.Sprintf("Node: %s capacity bytes: %f", nodeName, capacityBytes))
 	}
 
@@ -86,22 +124,114 @@ func (n *Node) SetMetrics(nodeName string, availableBytes float64, capacityBytes
 		if capacityBytes > 0. {
 			setValue = math.Max(capacityBytes-availableBytes, 0.) * 100.0 / capacityBytes
 		}
-		nodePercentageGaugeVec.With(prometheus.Labels{"node_name": nodeName}).Set(setValue)
+		nodePercentageGaugeVec.With(n.labels(nodeName)).Set(setValue)
 		log.Debug().Msg(fmt.Sprintf("Node: %s percentage used: %f", nodeName, setValue))
 	}
 
 }
 
+// SetImageFsMetrics records the image filesystem usage the runtime reports
+// for a node.
+func (n *Node) SetImageFsMetrics(nodeName string, usedBytes float64) {
+	if n.nodeImageFs {
+		nodeImageFsGaugeVec.With(n.labels(nodeName)).Set(usedBytes)
+		log.Debug().Msg(fmt.Sprintf("Node: %s image filesystem used bytes: %f", nodeName, usedBytes))
+	}
+}
+
+// SetAdjustedPollingRate records the milliseconds left of the scrape
+// interval after scraping a node.
+func (n *Node) SetAdjustedPollingRate(nodeName string, adjustTime int64) {
+	if n.AdjustedPollingRate {
+		AdjustedPollingRateGaugeVec.With(n.labels(nodeName)).Set(float64(adjustTime))
+	}
+}
+
+// Decoded records whether the summary Query opened for node decoded. A
+// malformed or oversized summary counts as a failed scrape like an
+// unreachable node, except that the node stays in the scrape set.
+func (n *Node) Decoded(node string, err error) {
+	if err != nil {
+		n.decodeFailed(node)
+		return
+	}
+	n.scrapeSucceeded(node)
+}
+
+// scrapeSucceeded clears the failure count and stale flag of a node.
+func (n *Node) scrapeSucceeded(node string) {
+	scrapeFailures.Lock()
+	delete(scrapeFailures.count, dev.ClusterKey(n.cluster.Name, node))
+	scrapeFailures.Unlock()
+	nodeScrapeStaleGaugeVec.With(n.labels(node)).Set(0)
+}
+
+// countFailure counts a failed scrape of node and returns its consecutive
+// failures.
+func (n *Node) countFailure(node string) int {
+	key := dev.ClusterKey(n.cluster.Name, node)
+	scrapeFailures.Lock()
+	defer scrapeFailures.Unlock()
+	scrapeFailures.count[key]++
+	return scrapeFailures.count[key]
+}
+
+// scrapeFailed keeps the last metrics of a node and flags them stale until
+// scrapeFailureTolerance consecutive scrapes failed, then evicts the node.
+func (n *Node) scrapeFailed(node string) {
+	failures := n.countFailure(node)
+	if failures >= max(n.scrapeFailureTolerance, 1) {
+		// Assume the node status is not ready so evict all pods tracked by that node. The Update func in the Node Watcher
+		// will pick the node back up for monitoring again, once the kubelet status reports back ready.
+		n.evict(node)
+		return
+	}
+	log.Warn().Msgf("Node %s: %d of %d tolerated scrape failures, keeping its last metrics", node, failures, n.scrapeFailureTolerance)
+	nodeScrapeStaleGaugeVec.With(n.labels(node)).Set(1)
+}
+
+// decodeFailed keeps the last metrics of a node whose summary did not decode
+// and flags them stale until scrapeFailureTolerance consecutive summaries
+// failed, then drops them. The kubelet answered, so the node stays in the
+// scrape set: in DaemonSet mode nothing would add it back.
+func (n *Node) decodeFailed(node string) {
+	failures := n.countFailure(node)
+	if failures >= max(n.scrapeFailureTolerance, 1) {
+		log.Warn().Msgf("Node %s: %d consecutive summaries failed to decode, dropping its metrics until one decodes", node, failures)
+		n.deleteSeries(node)
+	} else {
+		log.Warn().Msgf("Node %s: %d of %d tolerated scrape failures, keeping its last metrics", node, failures, n.scrapeFailureTolerance)
+	}
+	nodeScrapeStaleGaugeVec.With(n.labels(node)).Set(1)
+}
+
 func (n *Node) evict(node string) {
 	n.Set.Remove(node)
-	deleteLabel := prometheus.Labels{"node_name": node}
 
+	scrapeFailures.Lock()
+	delete(scrapeFailures.count, dev.ClusterKey(n.cluster.Name, node))
+	scrapeFailures.Unlock()
+
+	n.deleteSeries(node)
+	log.Info().Msgf("Node %s does not exist or is unresponsive. Removed from monitoring", node)
+}
+
+// deleteSeries drops the series of node and of the pods tracked on it.
+func (n *Node) deleteSeries(node string) {
+	deleteLabel := n.labels(node)
+	sample.ForgetNode(n.cluster.Name, node)
+	if n.configzFetched != nil {
+		n.configzFetched.Delete(node)
+	}
+	nodeScrapeStaleGaugeVec.DeletePartialMatch(deleteLabel)
 	nodeAvailableGaugeVec.DeletePartialMatch(deleteLabel)
 	nodeCapacityGaugeVec.DeletePartialMatch(deleteLabel)
 	nodePercentageGaugeVec.DeletePartialMatch(deleteLabel)
+	if n.nodeImageFs {
+		nodeImageFsGaugeVec.DeletePartialMatch(deleteLabel)
+	}
 	if n.AdjustedPollingRate {
 		AdjustedPollingRateGaugeVec.DeletePartialMatch(deleteLabel)
 	}
 	pod.EvictPodByNode(&deleteLabel)
-	log.Info().Msgf("Node %s does not exist or is unresponsive. Removed from monitoring", node)
 }
@@ -0,0 +1,223 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// metricsSource builds a stats summary from the kubelet Prometheus endpoints
+// for clusters that restrict /stats/summary. /metrics/resource lists the pods
+// and containers currently running on the node, /metrics/cadvisor provides
+// their filesystem usage. Neither endpoint exposes emptyDir volumes or
+// container logs, so those stay empty in the returned summary.
+type metricsSource struct {
+	transport kubeletTransport
+}
+
+type fsSample struct {
+	usage      float64
+	limit      float64
+	inodes     float64
+	inodesFree float64
+}
+
+type podKey struct {
+	namespace string
+	name      string
+}
+
+func (s *metricsSource) Summary(node string) (io.ReadCloser, error) {
+	resource, err := s.transport.get(node, "/metrics/resource")
+	if err != nil {
+		return nil, err
+	}
+	cadvisor, err := s.transport.get(node, "/metrics/cadvisor")
+	if err != nil {
+		return nil, err
+	}
+	summary, err := summaryFromMetrics(node, resource, cadvisor)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSummary(summary)
+}
+
+func parseMetrics(content []byte) (map[string]*dto.MetricFamily, error) {
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	return parser.TextToMetricFamilies(bytes.NewReader(content))
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func sampleValue(m *dto.Metric) float64 {
+	if m.GetGauge() != nil {
+		return m.GetGauge().GetValue()
+	}
+	if m.GetCounter() != nil {
+		return m.GetCounter().GetValue()
+	}
+	return m.GetUntyped().GetValue()
+}
+
+// summaryFromMetrics maps the /metrics/resource and /metrics/cadvisor
+// expositions of a node onto the stats summary model. Pod ephemeral storage
+// follows the kubelet summary: used bytes and inodes are summed over the
+// containers, capacity and free space come from the node filesystem.
+func summaryFromMetrics(node string, resource []byte, cadvisor []byte) (Summary, error) {
+	var summary Summary
+	summary.Node.NodeName = node
+
+	resourceFamilies, err := parseMetrics(resource)
+	if err != nil {
+		return summary, fmt.Errorf("parse /metrics/resource: %w", err)
+	}
+	cadvisorFamilies, err := parseMetrics(cadvisor)
+	if err != nil {
+		return summary, fmt.Errorf("parse /metrics/cadvisor: %w", err)
+	}
+
+	// Pods and containers currently running on the node.
+	pods := make(map[podKey]map[string]struct{})
+	for _, name := range []string{"pod_memory_working_set_bytes", "container_start_time_seconds"} {
+		family, ok := resourceFamilies[name]
+		if !ok {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			key := podKey{namespace: labelValue(m, "namespace"), name: labelValue(m, "pod")}
+			if key.name == "" {
+				continue
+			}
+			if _, ok := pods[key]; !ok {
+				pods[key] = make(map[string]struct{})
+			}
+			if c := labelValue(m, "container"); c != "" {
+				pods[key][c] = struct{}{}
+			}
+		}
+	}
+
+	// Filesystem samples per container and per device of the root cgroup.
+	containers := make(map[podKey]map[string]*fsSample)
+	devices := make(map[string]*fsSample)
+	deviceUse := make(map[string]int)
+	for name, set := range map[string]func(*fsSample, float64){
+		"container_fs_usage_bytes":  func(f *fsSample, v float64) { f.usage += v },
+		"container_fs_limit_bytes":  func(f *fsSample, v float64) { f.limit = max(f.limit, v) },
+		"container_fs_inodes_total": func(f *fsSample, v float64) { f.inodes += v },
+		"container_fs_inodes_free":  func(f *fsSample, v float64) { f.inodesFree += v },
+	} {
+		family, ok := cadvisorFamilies[name]
+		if !ok {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			device := labelValue(m, "device")
+			if labelValue(m, "id") == "/" {
+				if _, ok := devices[device]; !ok {
+					devices[device] = &fsSample{}
+				}
+				set(devices[device], sampleValue(m))
+				continue
+			}
+			key := podKey{namespace: labelValue(m, "namespace"), name: labelValue(m, "pod")}
+			c := labelValue(m, "container")
+			if key.name == "" || c == "" || c == "POD" {
+				continue
+			}
+			if _, ok := containers[key]; !ok {
+				containers[key] = make(map[string]*fsSample)
+			}
+			if _, ok := containers[key][c]; !ok {
+				containers[key][c] = &fsSample{}
+			}
+			set(containers[key][c], sampleValue(m))
+			if name == "container_fs_usage_bytes" {
+				deviceUse[device]++
+			}
+		}
+	}
+	nodeFs := nodeFilesystem(devices, deviceUse)
+
+	// Older kubelets without /metrics/resource pod series: fall back to the
+	// pods cAdvisor reports.
+	if len(pods) == 0 {
+		for key, cs := range containers {
+			pods[key] = make(map[string]struct{})
+			for c := range cs {
+				pods[key][c] = struct{}{}
+			}
+		}
+	}
+
+	for key, names := range pods {
+		p := PodStats{PodRef: PodReference{Name: key.name, Namespace: key.namespace}}
+		p.EphemeralStorage.CapacityBytes = nodeFs.limit
+		p.EphemeralStorage.AvailableBytes = max(nodeFs.limit-nodeFs.usage, 0)
+		p.EphemeralStorage.Inodes = nodeFs.inodes
+		p.EphemeralStorage.InodesFree = nodeFs.inodesFree
+		for c := range names {
+			fs, ok := containers[key][c]
+			if !ok {
+				fs = &fsSample{}
+			}
+			inodesUsed := max(fs.inodes-fs.inodesFree, 0)
+			p.Containers = append(p.Containers, pod.ContainerStats{
+				Name: c,
+				Rootfs: pod.FsStats{
+					AvailableBytes: int64(max(fs.limit-fs.usage, 0)),
+					CapacityBytes:  int64(fs.limit),
+					UsedBytes:      int(fs.usage),
+					Inodes:         int64(fs.inodes),
+					InodesFree:     int64(fs.inodesFree),
+					InodesUsed:     int64(inodesUsed),
+				},
+			})
+			p.EphemeralStorage.UsedBytes += fs.usage
+			p.EphemeralStorage.InodesUsed += inodesUsed
+		}
+		sort.Slice(p.Containers, func(i, j int) bool { return p.Containers[i].Name < p.Containers[j].Name })
+		summary.Pods = append(summary.Pods, p)
+	}
+	sortPods(summary.Pods)
+
+	return summary, nil
+}
+
+// nodeFilesystem picks the root cgroup device backing the container root
+// filesystems, falling back to the largest device when cAdvisor reports no
+// container filesystem usage.
+func nodeFilesystem(devices map[string]*fsSample, deviceUse map[string]int) fsSample {
+	var best string
+	for device, fs := range devices {
+		switch {
+		case best == "":
+			best = device
+		case deviceUse[device] != deviceUse[best]:
+			if deviceUse[device] > deviceUse[best] {
+				best = device
+			}
+		case fs.limit > devices[best].limit || (fs.limit == devices[best].limit && device < best):
+			best = device
+		}
+	}
+	if best == "" {
+		return fsSample{}
+	}
+	return *devices[best]
+}
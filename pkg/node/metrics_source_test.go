@@ -0,0 +1,132 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+const resourceMetrics = `# HELP container_start_time_seconds [STABLE] Start time of the container since unix epoch in seconds
+# TYPE container_start_time_seconds gauge
+container_start_time_seconds{container="app",namespace="default",pod="web-0"} 1.7e+09 1700000000000
+container_start_time_seconds{container="sidecar",namespace="default",pod="web-0"} 1.7e+09 1700000000000
+container_start_time_seconds{container="db",namespace="data",pod="db-0"} 1.7e+09 1700000000000
+# HELP pod_memory_working_set_bytes [STABLE] Current working set of the pod in bytes
+# TYPE pod_memory_working_set_bytes gauge
+pod_memory_working_set_bytes{namespace="default",pod="web-0"} 1.2e+07 1700000000000
+pod_memory_working_set_bytes{namespace="data",pod="db-0"} 3.4e+07 1700000000000
+`
+
+const cadvisorMetrics = `# HELP container_fs_usage_bytes Number of bytes that are consumed by the container on this filesystem.
+# TYPE container_fs_usage_bytes gauge
+container_fs_usage_bytes{container="",device="/dev/sda1",id="/",image="",name="",namespace="",pod=""} 4e+09 1700000000000
+container_fs_usage_bytes{container="",device="/dev/sdb1",id="/",image="",name="",namespace="",pod=""} 1e+09 1700000000000
+container_fs_usage_bytes{container="app",device="/dev/sda1",id="/kubepods/pod1/c1",image="app",name="c1",namespace="default",pod="web-0"} 1000 1700000000000
+container_fs_usage_bytes{container="sidecar",device="/dev/sda1",id="/kubepods/pod1/c2",image="sidecar",name="c2",namespace="default",pod="web-0"} 500 1700000000000
+container_fs_usage_bytes{container="POD",device="/dev/sda1",id="/kubepods/pod1/pause",image="pause",name="p",namespace="default",pod="web-0"} 10 1700000000000
+container_fs_usage_bytes{container="old",device="/dev/sda1",id="/kubepods/pod9/c9",image="old",name="c9",namespace="default",pod="gone-0"} 99 1700000000000
+# HELP container_fs_limit_bytes Number of bytes that can be consumed by the container on this filesystem.
+# TYPE container_fs_limit_bytes gauge
+container_fs_limit_bytes{container="",device="/dev/sda1",id="/",image="",name="",namespace="",pod=""} 1e+10 1700000000000
+container_fs_limit_bytes{container="",device="/dev/sdb1",id="/",image="",name="",namespace="",pod=""} 2e+10 1700000000000
+container_fs_limit_bytes{container="app",device="/dev/sda1",id="/kubepods/pod1/c1",image="app",name="c1",namespace="default",pod="web-0"} 1e+10 1700000000000
+container_fs_limit_bytes{container="sidecar",device="/dev/sda1",id="/kubepods/pod1/c2",image="sidecar",name="c2",namespace="default",pod="web-0"} 1e+10 1700000000000
+# HELP container_fs_inodes_total Number of available Inodes
+# TYPE container_fs_inodes_total gauge
+container_fs_inodes_total{container="",device="/dev/sda1",id="/",image="",name="",namespace="",pod=""} 1000 1700000000000
+container_fs_inodes_total{container="app",device="/dev/sda1",id="/kubepods/pod1/c1",image="app",name="c1",namespace="default",pod="web-0"} 1000 1700000000000
+# HELP container_fs_inodes_free Number of available Inodes
+# TYPE container_fs_inodes_free gauge
+container_fs_inodes_free{container="",device="/dev/sda1",id="/",image="",name="",namespace="",pod=""} 600 1700000000000
+container_fs_inodes_free{container="app",device="/dev/sda1",id="/kubepods/pod1/c1",image="app",name="c1",namespace="default",pod="web-0"} 990 1700000000000
+`
+
+func TestSummaryFromMetrics(t *testing.T) {
+	summary, err := summaryFromMetrics("node-1", []byte(resourceMetrics), []byte(cadvisorMetrics))
+	if err != nil {
+		t.Fatalf("summaryFromMetrics: %v", err)
+	}
+	if summary.Node.NodeName != "node-1" {
+		t.Errorf("node name = %q, want node-1", summary.Node.NodeName)
+	}
+
+	// /dev/sda1 backs the containers and wins over the larger /dev/sdb1; the
+	// pause container and gone-0 (absent from /metrics/resource) are dropped.
+	want := []PodStats{
+		{
+			PodRef:           PodReference{Name: "db-0", Namespace: "data"},
+			EphemeralStorage: FsStats{AvailableBytes: 6e9, CapacityBytes: 1e10, Inodes: 1000, InodesFree: 600},
+			Containers:       []pod.ContainerStats{{Name: "db"}},
+		},
+		{
+			PodRef:           PodReference{Name: "web-0", Namespace: "default"},
+			EphemeralStorage: FsStats{AvailableBytes: 6e9, CapacityBytes: 1e10, UsedBytes: 1500, Inodes: 1000, InodesFree: 600, InodesUsed: 10},
+			Containers: []pod.ContainerStats{
+				{Name: "app", Rootfs: pod.FsStats{AvailableBytes: 1e10 - 1000, CapacityBytes: 1e10, UsedBytes: 1000, Inodes: 1000, InodesFree: 990, InodesUsed: 10}},
+				{Name: "sidecar", Rootfs: pod.FsStats{AvailableBytes: 1e10 - 500, CapacityBytes: 1e10, UsedBytes: 500}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(summary.Pods, want) {
+		t.Errorf("pods = %+v\nwant %+v", summary.Pods, want)
+	}
+}
+
+func TestSummaryFromMetricsWithoutResourcePods(t *testing.T) {
+	summary, err := summaryFromMetrics("node-1", []byte(""), []byte(cadvisorMetrics))
+	if err != nil {
+		t.Fatalf("summaryFromMetrics: %v", err)
+	}
+	var names []string
+	for _, p := range summary.Pods {
+		names = append(names, p.PodRef.Namespace+"/"+p.PodRef.Name)
+	}
+	if want := []string{"default/gone-0", "default/web-0"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("pods = %v, want %v", names, want)
+	}
+}
+
+func TestSummaryFromMetricsParseError(t *testing.T) {
+	if _, err := summaryFromMetrics("node-1", []byte(resourceMetrics), []byte("not a metric{")); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestMetricsSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics/resource":
+			_, _ = w.Write([]byte(resourceMetrics))
+		case "/metrics/cadvisor":
+			_, _ = w.Write([]byte(cadvisorMetrics))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	endpoints := &sync.Map{}
+	endpoints.Store("node-1", srv.URL)
+	source := &metricsSource{transport: &kubeletSource{endpoints: endpoints, client: srv.Client()}}
+
+	content, err := readAll(source.Summary("node-1"))
+	if err != nil {
+		t.Fatalf("Summary: %v", err)
+	}
+	var summary Summary
+	if err := json.Unmarshal(content, &summary); err != nil {
+		t.Fatalf("decode summary: %v", err)
+	}
+	if len(summary.Pods) != 2 || summary.Pods[1].EphemeralStorage.UsedBytes != 1500 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	if _, err := source.Summary("unknown-node"); err == nil {
+		t.Error("expected error for unknown node")
+	}
+}
@@ -54,6 +54,7 @@ func TestNode(t *testing.T) {
 		nodeAvailable:           true,
 		nodeCapacity:            true,
 		nodePercentage:          true,
+		nodeImageFs:             true,
 		MaxNodeQueryConcurrency: 10,
 		Set:                     mapset.NewSet[string](),
 		KubeletEndpoint:         &sync.Map{},
@@ -173,6 +174,23 @@ func TestNode(t *testing.T) {
 	t.Run("SetMetrics_disabled_no_panic", func(t *testing.T) {
 		nDisabled := &Node{}
 		nDisabled.SetMetrics("disabled-node", 1000, 5000)
+		nDisabled.SetImageFsMetrics("disabled-node", 1000)
+	})
+
+	t.Run("SetImageFsMetrics", func(t *testing.T) {
+		initPodGauges()
+		n.Set.Add("image-node")
+		n.SetImageFsMetrics("image-node", 4096)
+
+		v := getGaugeValue(t, nodeImageFsGaugeVec, prometheus.Labels{"node_name": "image-node"})
+		if v != 4096 {
+			t.Errorf("image fs: got %f, want 4096", v)
+		}
+
+		n.evict("image-node")
+		if count := testutil.CollectAndCount(nodeImageFsGaugeVec); count != 0 {
+			t.Errorf("expected image fs series to be evicted, got %d", count)
+		}
 	})
 
 	t.Run("createMetrics_AdjustedPollingRate_registered", func(t *testing.T) {
@@ -0,0 +1,134 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// StatsSource opens the raw kubelet stats summary of a node for streaming.
+// Node.Query retries a source with backoff, so implementations make a single
+// attempt and return an error when the summary cannot be served; errors while
+// reading the body surface from DecodeSummary. The caller closes the body.
+type StatsSource interface {
+	Summary(node string) (io.ReadCloser, error)
+}
+
+// kubeletTransport is a StatsSource that can also fetch other kubelet paths
+// of a node, e.g. /metrics/cadvisor.
+type kubeletTransport interface {
+	StatsSource
+	get(node string, path string) ([]byte, error)
+}
+
+// proxySource reads the stats summary through the apiserver node proxy.
+type proxySource struct {
+	clientset kubernetes.Interface
+}
+
+// NewProxySource returns a StatsSource that queries
+// /api/v1/nodes/<node>/proxy/stats/summary through the apiserver.
+func NewProxySource(clientset kubernetes.Interface) StatsSource {
+	return &proxySource{clientset: clientset}
+}
+
+func (s *proxySource) Summary(node string) (io.ReadCloser, error) {
+	return s.request(node, "/stats/summary").Stream(context.Background())
+}
+
+func (s *proxySource) get(node string, path string) ([]byte, error) {
+	return s.request(node, path).DoRaw(context.Background())
+}
+
+func (s *proxySource) request(node string, path string) *rest.Request {
+	return s.clientset.CoreV1().RESTClient().Get().AbsPath(fmt.Sprintf("/api/v1/nodes/%s/proxy%s", node, path))
+}
+
+// kubeletSource reads the stats summary straight from the kubelet endpoint
+// the node watcher recorded for each node.
+type kubeletSource struct {
+	endpoints *sync.Map // key=nodeName val=kubeletEndpoint
+	client    *http.Client
+}
+
+// NewKubeletSource returns a StatsSource that queries <endpoint>/stats/summary
+// on the kubelet with the given client.
+func NewKubeletSource(endpoints *sync.Map, client *http.Client) StatsSource {
+	return &kubeletSource{endpoints: endpoints, client: client}
+}
+
+func (s *kubeletSource) Summary(node string) (io.ReadCloser, error) {
+	return s.open(node, "/stats/summary")
+}
+
+func (s *kubeletSource) get(node string, path string) ([]byte, error) {
+	body, err := s.open(node, path)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (s *kubeletSource) open(node string, path string) (io.ReadCloser, error) {
+	kubeletep, ok := s.endpoints.Load(node)
+	if !ok || kubeletep == "" {
+		return nil, fmt.Errorf("kubelet endpoint not found for node: %s", node)
+	}
+	resp, err := s.client.Get(kubeletep.(string) + path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		// The error body only carries the kubelet's message.
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("failed to scrape from kubelet endpoint: unexpected status code %d: %s", resp.StatusCode, string(content))
+	}
+	return resp.Body, nil
+}
+
+// newStatsSource picks the transport matching the collector settings:
+// direct kubelet scraping is only used in Deployment mode, otherwise the
+// apiserver proxy is queried. With statsSource "metrics" the transport reads
+// the kubelet Prometheus endpoints instead of /stats/summary, with "cri" the
+// local container runtime is queried without going through the kubelet.
+func (n *Node) newStatsSource() StatsSource {
+	if n.statsSource == "cri" {
+		source, err := NewCRISource(n.criEndpoint, time.Duration(n.sampleInterval)*time.Second)
+		if err != nil {
+			log.Error().Err(err).Msgf("invalid CRI runtime endpoint %s", n.criEndpoint)
+			os.Exit(1)
+		}
+		return source
+	}
+
+	transport := n.newKubeletTransport()
+	if n.statsSource == "metrics" {
+		return &metricsSource{transport: transport}
+	}
+	return transport
+}
+
+// newKubeletTransport picks how kubelet paths are fetched: straight from the
+// kubelet in Deployment mode with SCRAPE_FROM_KUBELET, through the apiserver
+// node proxy otherwise.
+func (n *Node) newKubeletTransport() kubeletTransport {
+	switch {
+	case !n.scrapeFromKubelet || n.deployType != "Deployment":
+		return &proxySource{clientset: n.cluster.Clientset}
+	case n.kubeletReadOnlyPort > 0:
+		return &kubeletSource{endpoints: n.KubeletEndpoint, client: n.cluster.ClientAno}
+	default:
+		return &kubeletSource{endpoints: n.KubeletEndpoint, client: n.cluster.ClientRaw}
+	}
+}
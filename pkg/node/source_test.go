@@ -0,0 +1,282 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeSource is a StatsSource returning canned responses, counting calls.
+type fakeSource struct {
+	mu      sync.Mutex
+	calls   int
+	content []byte
+	err     error
+}
+
+func (f *fakeSource) Summary(string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// readAll drains a summary body the way the tests used to get it as bytes.
+func readAll(body io.ReadCloser, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func TestProxySource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/nodes/node-1/proxy/stats/summary" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"node":{"nodeName":"node-1"}}`))
+	}))
+	defer srv.Close()
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatalf("NewForConfig: %v", err)
+	}
+	content, err := readAll(NewProxySource(clientset).Summary("node-1"))
+	if err != nil {
+		t.Fatalf("Summary: %v", err)
+	}
+	if string(content) != `{"node":{"nodeName":"node-1"}}` {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	if _, err := NewProxySource(clientset).Summary("missing"); err == nil {
+		t.Error("expected error for unknown node")
+	}
+}
+
+func TestKubeletSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats/summary" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer failing.Close()
+
+	endpoints := &sync.Map{}
+	endpoints.Store("ok-node", srv.URL)
+	endpoints.Store("forbidden-node", failing.URL)
+	source := NewKubeletSource(endpoints, srv.Client())
+
+	t.Run("ok", func(t *testing.T) {
+		content, err := readAll(source.Summary("ok-node"))
+		if err != nil {
+			t.Fatalf("Summary: %v", err)
+		}
+		if string(content) != "{}" {
+			t.Errorf("unexpected content %q", content)
+		}
+	})
+
+	t.Run("unknown_endpoint", func(t *testing.T) {
+		_, err := source.Summary("unknown-node")
+		if err == nil || !strings.Contains(err.Error(), "kubelet endpoint not found") {
+			t.Errorf("expected endpoint error, got %v", err)
+		}
+	})
+
+	t.Run("unexpected_status", func(t *testing.T) {
+		_, err := source.Summary("forbidden-node")
+		if err == nil || !strings.Contains(err.Error(), "unexpected status code 403") {
+			t.Errorf("expected status error, got %v", err)
+		}
+	})
+}
+
+func TestNewStatsSource(t *testing.T) {
+	tests := []struct {
+		name              string
+		deployType        string
+		scrapeFromKubelet bool
+		statsSource       string
+		want              string
+	}{
+		{"daemonset", "DaemonSet", false, "summary", "*node.proxySource"},
+		{"daemonset ignores kubelet scraping", "DaemonSet", true, "summary", "*node.proxySource"},
+		{"deployment", "Deployment", false, "summary", "*node.proxySource"},
+		{"deployment kubelet scraping", "Deployment", true, "summary", "*node.kubeletSource"},
+		{"metrics endpoints", "DaemonSet", false, "metrics", "*node.metricsSource"},
+		{"metrics endpoints kubelet scraping", "Deployment", true, "metrics", "*node.metricsSource"},
+		{"container runtime", "DaemonSet", false, "cri", "*node.criSource"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Node{deployType: tt.deployType, scrapeFromKubelet: tt.scrapeFromKubelet, statsSource: tt.statsSource, criEndpoint: "unix:///run/containerd/containerd.sock", KubeletEndpoint: &sync.Map{}}
+			if got := fmt.Sprintf("%T", n.newStatsSource()); got != tt.want {
+				t.Errorf("newStatsSource() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryUsesSource(t *testing.T) {
+	initPodGauges()
+	if nodeAvailableGaugeVec == nil {
+		(&Node{}).createMetrics()
+	}
+
+	t.Run("success", func(t *testing.T) {
+		source := &fakeSource{content: []byte(`{}`)}
+		n := &Node{sampleInterval: 1, Source: source, Set: mapset.NewSet[string]("ok-node")}
+		content, err := readAll(n.Query("ok-node"))
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if string(content) != "{}" || source.calls != 1 {
+			t.Errorf("content=%q calls=%d, want {} and 1 call", content, source.calls)
+		}
+	})
+
+	t.Run("failure_evicts_node", func(t *testing.T) {
+		source := &fakeSource{err: errors.New("boom")}
+		n := &Node{sampleInterval: 1, Source: source, Set: mapset.NewSet[string]("bad-node")}
+		if _, err := n.Query("bad-node"); err == nil {
+			t.Fatal("expected error")
+		}
+		if source.calls < 2 {
+			t.Errorf("expected retries, got %d calls", source.calls)
+		}
+		if n.Set.Contains("bad-node") {
+			t.Error("expected bad-node to be evicted")
+		}
+	})
+
+	t.Run("failures_within_tolerance_keep_metrics", func(t *testing.T) {
+		source := &fakeSource{err: errors.New("boom")}
+		n := &Node{sampleInterval: 1, scrapeFailureTolerance: 2, nodeAvailable: true, Source: source, Set: mapset.NewSet[string]("flaky-node")}
+		labels := prometheus.Labels{"node_name": "flaky-node"}
+		n.SetMetrics("flaky-node", 10, 100)
+
+		if _, err := n.Query("flaky-node"); err == nil {
+			t.Fatal("expected error")
+		}
+		if !n.Set.Contains("flaky-node") || getGaugeValue(t, nodeScrapeStaleGaugeVec, labels) != 1 {
+			t.Error("expected flaky-node to be kept and flagged stale after 1 of 2 failures")
+		}
+		if got := getGaugeValue(t, nodeAvailableGaugeVec, labels); got != 10 {
+			t.Errorf("available = %v, want the last value 10", got)
+		}
+
+		// A successful scrape resets the count.
+		source.mu.Lock()
+		source.err, source.content = nil, []byte(`{}`)
+		source.mu.Unlock()
+		_, err := readAll(n.Query("flaky-node"))
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		n.Decoded("flaky-node", err)
+		if getGaugeValue(t, nodeScrapeStaleGaugeVec, labels) != 0 {
+			t.Error("expected stale flag cleared after a successful scrape")
+		}
+
+		source.mu.Lock()
+		source.err = errors.New("boom")
+		source.mu.Unlock()
+		_, _ = n.Query("flaky-node")
+		if !n.Set.Contains("flaky-node") {
+			t.Fatal("expected flaky-node to be kept after 1 failure following a success")
+		}
+		_, _ = n.Query("flaky-node")
+		if n.Set.Contains("flaky-node") {
+			t.Error("expected flaky-node to be evicted after 2 consecutive failures")
+		}
+		if nodeScrapeStaleGaugeVec.Delete(labels) || nodeAvailableGaugeVec.Delete(labels) {
+			t.Error("expected the node series to be deleted on eviction")
+		}
+	})
+
+	t.Run("oversized_summary_is_a_tolerated_failure", func(t *testing.T) {
+		content := kubeletSummary(2)
+		n := &Node{sampleInterval: 1, maxSummaryBytes: int64(len(content)) - 1, scrapeFailureTolerance: 2, Source: &fakeSource{content: content}, Set: mapset.NewSet[string]("big-node")}
+		body, err := n.Query("big-node")
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		_, err = DecodeSummary(body, func(*PodStats) error { return nil })
+		body.Close()
+		n.Decoded("big-node", err)
+		if !n.Set.Contains("big-node") || getGaugeValue(t, nodeScrapeStaleGaugeVec, prometheus.Labels{"node_name": "big-node"}) != 1 {
+			t.Error("expected big-node to be kept and flagged stale after an oversized summary")
+		}
+	})
+
+	t.Run("undecodable_summaries_keep_node_scraped", func(t *testing.T) {
+		content := kubeletSummary(2)
+		source := &fakeSource{content: content}
+		n := &Node{sampleInterval: 1, deployType: "DaemonSet", nodeAvailable: true, maxSummaryBytes: int64(len(content)) - 1,
+			scrapeFailureTolerance: 2, Source: source, Set: mapset.NewSet[string]("own-node")}
+		labels := prometheus.Labels{"node_name": "own-node"}
+		scrape := func() error {
+			body, err := n.Query("own-node")
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			defer body.Close()
+			_, err = DecodeSummary(body, func(*PodStats) error { return nil })
+			n.Decoded("own-node", err)
+			if err == nil {
+				n.SetMetrics("own-node", 10, 100)
+			}
+			return err
+		}
+		n.SetMetrics("own-node", 10, 100)
+
+		for range 3 {
+			if err := scrape(); err == nil {
+				t.Fatal("expected the oversized summary to fail")
+			}
+		}
+		if !n.Set.Contains("own-node") {
+			t.Fatal("expected own-node to stay in the scrape set")
+		}
+		if nodeAvailableGaugeVec.Delete(labels) {
+			t.Error("expected the node series to be dropped past the tolerance")
+		}
+		if getGaugeValue(t, nodeScrapeStaleGaugeVec, labels) != 1 {
+			t.Error("expected own-node to be flagged stale")
+		}
+
+		// Nothing adds the node back in DaemonSet mode, scraping resumes
+		// with the next summary that decodes.
+		n.maxSummaryBytes = int64(len(content))
+		if err := scrape(); err != nil {
+			t.Fatalf("scrape: %v", err)
+		}
+		if getGaugeValue(t, nodeScrapeStaleGaugeVec, labels) != 0 || getGaugeValue(t, nodeAvailableGaugeVec, labels) != 10 {
+			t.Error("expected own-node metrics back and not stale after a valid summary")
+		}
+	})
+}
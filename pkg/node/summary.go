@@ -0,0 +1,61 @@
+package node
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// Summary is the subset of the kubelet stats summary the exporter reads.
+// Every StatsSource returns this shape encoded as JSON.
+type Summary struct {
+	Node NodeStats  `json:"node"`
+	Pods []PodStats `json:"pods"`
+}
+
+type NodeStats struct {
+	NodeName string `json:"nodeName"`
+	// Fs is the filesystem of the kubelet root dir, nil for sources without
+	// filesystem stats.
+	Fs      *FsStats     `json:"fs,omitempty"`
+	Runtime RuntimeStats `json:"runtime"`
+}
+
+type RuntimeStats struct {
+	ImageFs *FsStats `json:"imageFs,omitempty"`
+}
+
+type PodStats struct {
+	PodRef           PodReference         `json:"podRef"`
+	EphemeralStorage FsStats              `json:"ephemeral-storage"`
+	Containers       []pod.ContainerStats `json:"containers,omitempty"`
+	Volumes          []pod.Volume         `json:"volume,omitempty"`
+}
+
+type PodReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type FsStats struct {
+	// Time the kubelet sampled the stats, zero for sources without one.
+	Time           time.Time `json:"time,omitzero"`
+	AvailableBytes float64   `json:"availableBytes"`
+	CapacityBytes  float64   `json:"capacityBytes"`
+	UsedBytes      float64   `json:"usedBytes"`
+	Inodes         float64   `json:"inodes"`
+	InodesFree     float64   `json:"inodesFree"`
+	InodesUsed     float64   `json:"inodesUsed"`
+}
+
+// sortPods orders pods by namespace and name so sources that build a summary
+// from maps return it deterministically.
+func sortPods(pods []PodStats) {
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].PodRef.Namespace != pods[j].PodRef.Namespace {
+			return pods[i].PodRef.Namespace < pods[j].PodRef.Namespace
+		}
+		return pods[i].PodRef.Name < pods[j].PodRef.Name
+	})
+}
@@ -0,0 +1,135 @@
+package pod
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the Warning events emitted on pods.
+const (
+	ContainerLimitReason    = "EphemeralStorageContainerLimit"
+	EmptyDirSizeLimitReason = "EphemeralStorageEmptyDirSizeLimit"
+)
+
+// podEvents tracks which containers and emptyDirs of a pod are above the
+// event threshold and when the pod last got an event.
+type podEvents struct {
+	last  time.Time
+	above map[string]bool // keyed by "container/<name>" or "volume/<name>"
+}
+
+var (
+	eventsMutex sync.Mutex
+	eventsState = map[string]*podEvents{} // keyed by cluster and pod name, like the pod lookup
+
+	eventsNow = time.Now
+)
+
+// newEventRecorder returns an EventRecorder writing events through the
+// apiserver client of a cluster.
+func newEventRecorder(clientset kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "k8s-ephemeral-storage-metrics"})
+}
+
+// crossing is a container or emptyDir above the event threshold.
+type crossing struct {
+	key     string
+	reason  string
+	message string
+}
+
+// setEvents emits a Warning event on a pod when one of its containers
+// crosses the event threshold in percent of its ephemeral-storage limit, or
+// one of its disk backed emptyDirs crosses it in percent of its sizeLimit.
+// A container or emptyDir only fires again after its usage dropped below
+// the threshold, and a pod gets at most one event per eventInterval;
+// crossings held back by the interval are emitted by a later scrape if they
+// still hold.
+func (cr Collector) setEvents(podResult pod, podName string, podNamespace string, volumes []Volume, containers []ContainerStats) {
+	var crossings []crossing
+	below := map[string]bool{}
+
+	usage := make(map[string]float64, len(containers))
+	for _, c := range containers {
+		// Kubelet counts the writable layer and the logs against a container's limit.
+		usage[c.Name] = float64(c.Rootfs.UsedBytes + c.Logs.UsedBytes)
+	}
+	for _, c := range podResult.containers {
+		used, ok := usage[c.name]
+		if !ok || c.limit <= 0 {
+			continue
+		}
+		key := "container/" + c.name
+		percentage := used / c.limit * 100.0
+		if percentage < cr.eventThreshold {
+			below[key] = true
+			continue
+		}
+		crossings = append(crossings, crossing{key: key, reason: ContainerLimitReason, message: fmt.Sprintf(
+			"Container %s uses %.1f%% of its %s ephemeral-storage limit; kubelet evicts the pod once it is exceeded",
+			c.name, percentage, resource.NewQuantity(int64(c.limit), resource.BinarySI))})
+	}
+	for _, v := range volumes {
+		sizeLimit, ok := podResult.emptyDirSizeLimits[v.Name]
+		if !ok {
+			continue
+		}
+		key := "volume/" + v.Name
+		percentage := float64(v.UsedBytes) / sizeLimit * 100.0
+		if percentage < cr.eventThreshold {
+			below[key] = true
+			continue
+		}
+		crossings = append(crossings, crossing{key: key, reason: EmptyDirSizeLimitReason, message: fmt.Sprintf(
+			"emptyDir %s uses %.1f%% of its %s sizeLimit; kubelet evicts the pod once it is exceeded",
+			v.Name, percentage, resource.NewQuantity(int64(sizeLimit), resource.BinarySI))})
+	}
+
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	state, ok := eventsState[cr.key(podName)]
+	if !ok {
+		if len(crossings) == 0 {
+			return
+		}
+		state = &podEvents{above: map[string]bool{}}
+		eventsState[cr.key(podName)] = state
+	}
+	for key := range below {
+		delete(state.above, key)
+	}
+
+	now := eventsNow()
+	ref := &v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: podNamespace, Name: podName, UID: types.UID(podResult.uid)}
+	for _, c := range crossings {
+		if state.above[c.key] {
+			continue
+		}
+		if !state.last.IsZero() && now.Sub(state.last) < cr.eventInterval {
+			log.Debug().Msgf("pod %s/%s: holding back event %s, last event %v ago", podNamespace, podName, c.reason, now.Sub(state.last))
+			break
+		}
+		cr.eventRecorder.Event(ref, v1.EventTypeWarning, c.reason, c.message)
+		state.above[c.key] = true
+		state.last = now
+	}
+}
+
+// forgetEvents drops the event state of an evicted pod.
+func forgetEvents(podName string) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	delete(eventsState, podName)
+}
@@ -0,0 +1,99 @@
+package pod
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	origNow := eventsNow
+	eventsNow = func() time.Time { return now }
+	defer func() { eventsNow = origNow }()
+
+	cr := Collector{
+		eventRecorder:  recorder,
+		eventThreshold: 90,
+		eventInterval:  10 * time.Minute,
+		lookup:         &map[string]pod{},
+		lookupMutex:    &sync.RWMutex{},
+	}
+	sizeLimit := resource.MustParse("1000")
+	cr.getPodData(v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-1"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "app", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+				v1.ResourceEphemeralStorage: resource.MustParse("1000"),
+			}}}},
+			Volumes: []v1.Volume{{Name: "scratch", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{SizeLimit: &sizeLimit}}}},
+		},
+	})
+	podResult := (*cr.lookup)["web"]
+	if podResult.uid != "uid-1" || podResult.containers[0].limit != 1000 || podResult.emptyDirSizeLimits["scratch"] != 1000 {
+		t.Fatalf("limits not recorded for events: %+v", podResult)
+	}
+	t.Cleanup(func() { forgetEvents("web") })
+
+	scrape := func(containerUsed int, volumeUsed int) {
+		cr.setEvents(podResult, "web", "default",
+			[]Volume{{Name: "scratch", UsedBytes: volumeUsed}},
+			[]ContainerStats{{Name: "app", Rootfs: FsStats{UsedBytes: containerUsed / 2}, Logs: FsStats{UsedBytes: containerUsed / 2}}})
+	}
+	events := func() []string {
+		var got []string
+		for {
+			select {
+			case e := <-recorder.Events:
+				got = append(got, e)
+			default:
+				return got
+			}
+		}
+	}
+
+	scrape(500, 100)
+	if got := events(); len(got) != 0 {
+		t.Fatalf("events below the threshold: %q", got)
+	}
+
+	// Rootfs and logs count against the container limit.
+	scrape(920, 100)
+	got := events()
+	if len(got) != 1 || !strings.HasPrefix(got[0], "Warning "+ContainerLimitReason+" Container app uses 92.0% of its 1k") {
+		t.Fatalf("events = %q", got)
+	}
+
+	// Staying above does not fire again, and the emptyDir crossing is held
+	// back by the per pod interval.
+	now = now.Add(time.Minute)
+	scrape(950, 950)
+	if got := events(); len(got) != 0 {
+		t.Fatalf("events within the interval: %q", got)
+	}
+	now = now.Add(10 * time.Minute)
+	scrape(950, 950)
+	got = events()
+	if len(got) != 1 || !strings.HasPrefix(got[0], "Warning "+EmptyDirSizeLimitReason+" emptyDir scratch uses 95.0%") {
+		t.Fatalf("events = %q", got)
+	}
+
+	// Only dropping below the threshold re-arms an event.
+	now = now.Add(time.Hour)
+	scrape(950, 950)
+	scrape(800, 950)
+	if got := events(); len(got) != 0 {
+		t.Fatalf("events without a new crossing: %q", got)
+	}
+	scrape(990, 950)
+	if got := events(); len(got) != 1 {
+		t.Fatalf("events after usage rose back above = %q, want 1", got)
+	}
+}
@@ -4,16 +4,30 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/tools/record"
 
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/alert"
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/remediate"
 )
 
-var (
-	lookupMutex sync.RWMutex
-	waitGroup   sync.WaitGroup
-)
+// remediators act on pods nearing their eviction limit, one per cluster
+// while a remediation policy is configured.
+// Keyed by cluster name; value is *remediate.Controller.
+var remediators sync.Map
+
+// remediatorOf returns the remediator of cluster, nil when no remediation
+// policy is configured.
+func remediatorOf(cluster string) *remediate.Controller {
+	if r, ok := remediators.Load(cluster); ok {
+		return r.(*remediate.Controller)
+	}
+	return nil
+}
 
 type Collector struct {
 	containerVolumeUsage            bool
@@ -21,7 +35,14 @@ type Collector struct {
 	containerVolumeLimitsPercentage bool
 	containerRootfsUsage            bool
 	containerLogsUsage              bool
+	containerLogsRotation           bool
 	inodes                          bool
+	podLimit                        bool
+	podPhase                        bool
+	thresholds                      bool
+	defaultThresholds               thresholds
+	eventThreshold                  float64
+	eventInterval                   time.Duration
 	lookup                          *map[string]pod
 	lookupMutex                     *sync.RWMutex
 	podUsage                        bool
@@ -32,23 +53,65 @@ type Collector struct {
 
 	deployAsDaemonSet bool
 	currentNodeName   string
+
+	filter Filter
+
+	cluster       dev.Cluster
+	eventRecorder record.EventRecorder // nil while events are disabled
+
+	// stop ends the alert webhooks and the remediation shared by the
+	// collectors of one NewClusterCollectors call.
+	stop     chan struct{}
+	stopOnce *sync.Once
 }
 
+// NewCollector returns the collector of the cluster of KUBECONFIG or the one
+// the exporter runs in.
 func NewCollector(sampleInterval int64) Collector {
-	podUsage, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_POD_USAGE", "false"))
-	containerVolumeUsage, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_CONTAINER_VOLUME_USAGE", "false"))
-	containerLimitsPercentage, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_CONTAINER_LIMIT_PERCENTAGE", "false"))
-	containerVolumeLimitsPercentage, _ := strconv.ParseBool(
-		dev.GetEnv(
-			"EPHEMERAL_STORAGE_CONTAINER_VOLUME_LIMITS_PERCENTAGE", "false",
-		),
-	)
-	containerRootfsUsage, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_CONTAINER_ROOTFS_USAGE", "false"))
-	containerLogsUsage, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_CONTAINER_LOGS_USAGE", "false"))
-	inodes, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_INODES", "false"))
-	lookup := make(map[string]pod)
+	return NewClusterCollectors(sampleInterval, []dev.Cluster{dev.DefaultCluster()})[0]
+}
 
-	listPodsWithCache, _ := strconv.ParseBool(dev.GetEnv("EPHEMERAL_STORAGE_LIST_PODS_WITH_CACHE", "false"))
+// NewClusterCollectors returns a collector per cluster. They share the
+// configuration, the metric families and the alert webhooks, but each one
+// watches the pods of its cluster with its own clients.
+func NewClusterCollectors(sampleInterval int64, clusters []dev.Cluster) []Collector {
+	podUsage := boolEnv("EPHEMERAL_STORAGE_POD_USAGE", "false")
+	containerVolumeUsage := boolEnv("EPHEMERAL_STORAGE_CONTAINER_VOLUME_USAGE", "false")
+	containerLimitsPercentage := boolEnv("EPHEMERAL_STORAGE_CONTAINER_LIMIT_PERCENTAGE", "false")
+	containerVolumeLimitsPercentage := boolEnv("EPHEMERAL_STORAGE_CONTAINER_VOLUME_LIMITS_PERCENTAGE", "false")
+	containerRootfsUsage := boolEnv("EPHEMERAL_STORAGE_CONTAINER_ROOTFS_USAGE", "false")
+	containerLogsUsage := boolEnv("EPHEMERAL_STORAGE_CONTAINER_LOGS_USAGE", "false")
+	containerLogsRotation := boolEnv("EPHEMERAL_STORAGE_CONTAINER_LOGS_ROTATION", "false")
+	inodes := boolEnv("EPHEMERAL_STORAGE_INODES", "false")
+	podLimit := boolEnv("EPHEMERAL_STORAGE_POD_LIMIT", "false")
+	podPhase := boolEnv("EPHEMERAL_STORAGE_POD_PHASE", "false")
+	thresholdsEnabled := boolEnv("EPHEMERAL_STORAGE_THRESHOLDS", "false")
+	stop := make(chan struct{})
+	// Replays rebuild metrics from a recording and must not notify webhooks.
+	if dev.ReplayPath() == "" {
+		evaluator, err := alert.NewEvaluator()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up alerting")
+			os.Exit(1)
+		}
+		if evaluator != nil {
+			// Alerts are evaluated against the thresholds.
+			thresholdsEnabled = true
+			alerts = evaluator
+			go evaluator.Run(stop)
+		}
+	}
+	// Defaults go through the annotation parser; empty variables leave a level unset.
+	defaultAnnotations := map[string]string{}
+	if warn := dev.GetEnv("THRESHOLD_WARN_PERCENT", ""); warn != "" {
+		defaultAnnotations[WarnPercentAnnotation] = warn
+	}
+	if critical := dev.GetEnv("THRESHOLD_CRITICAL_PERCENT", ""); critical != "" {
+		defaultAnnotations[CriticalPercentAnnotation] = critical
+	}
+	defaultThresholds := parseThresholds("default", "thresholds", defaultAnnotations)
+
+	listPodsWithCache := boolEnv("EPHEMERAL_STORAGE_LIST_PODS_WITH_CACHE", "false")
 
 	deployAsDaemonSet := dev.DeployAsDaemonSet()
 	currentNodeName := dev.CurrentNodeName()
@@ -58,38 +121,149 @@ func NewCollector(sampleInterval int64) Collector {
 		os.Exit(1)
 	}
 
+	eventThresholdEnv := dev.GetEnv("EVENTS_THRESHOLD_PERCENT", "0")
+	eventThreshold, err := strconv.ParseFloat(eventThresholdEnv, 64)
+	if err != nil || eventThreshold < 0 || eventThreshold > 100 {
+		log.Error().Msgf("EVENTS_THRESHOLD_PERCENT must be a percentage between 0 and 100, got %s", eventThresholdEnv)
+		os.Exit(1)
+	}
+	eventInterval, err := time.ParseDuration(dev.GetEnv("EVENTS_MIN_INTERVAL", "10m"))
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid EVENTS_MIN_INTERVAL")
+		os.Exit(1)
+	}
+	if dev.ReplayPath() != "" {
+		eventThreshold = 0
+	}
+
+	podAnnotationOptOut := boolEnv("POD_ANNOTATION_OPT_OUT", "false")
+	filter, err := NewFilter(
+		dev.GetEnv("NAMESPACE_INCLUDE", ""),
+		dev.GetEnv("NAMESPACE_EXCLUDE", ""),
+		dev.GetEnv("POD_LABEL_SELECTOR", ""),
+		podAnnotationOptOut,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid pod filter")
+		os.Exit(1)
+	}
+
 	var c = Collector{
 		containerVolumeUsage:            containerVolumeUsage,
 		containerLimitsPercentage:       containerLimitsPercentage,
 		containerVolumeLimitsPercentage: containerVolumeLimitsPercentage,
 		containerRootfsUsage:            containerRootfsUsage,
 		containerLogsUsage:              containerLogsUsage,
+		containerLogsRotation:           containerLogsRotation,
 		inodes:                          inodes,
-		lookup:                          &lookup,
-		lookupMutex:                     &lookupMutex,
+		podLimit:                        podLimit,
+		podPhase:                        podPhase,
+		thresholds:                      thresholdsEnabled,
+		defaultThresholds:               defaultThresholds,
+		eventThreshold:                  eventThreshold,
+		eventInterval:                   eventInterval,
 		podUsage:                        podUsage,
 		sampleInterval:                  sampleInterval,
-		WaitGroup:                       &waitGroup,
 
 		listPodsWithCache: listPodsWithCache,
 
 		deployAsDaemonSet: deployAsDaemonSet,
 		currentNodeName:   currentNodeName,
+
+		filter: filter,
+
+		stop:     stop,
+		stopOnce: &sync.Once{},
 	}
 
 	c.createMetrics()
 
-	tolerance, _ := strconv.Atoi(dev.GetEnv("SCRAPE_MISS_TOLERANCE", "2"))
-	if tolerance < 1 {
-		tolerance = 2
+	toleranceEnv := dev.GetEnv("SCRAPE_MISS_TOLERANCE", "2")
+	tolerance, err := strconv.Atoi(toleranceEnv)
+	if err != nil || tolerance < 1 {
+		log.Error().Msgf("SCRAPE_MISS_TOLERANCE must be a positive integer, got %s", toleranceEnv)
+		os.Exit(1)
 	}
 	scrapeMissTolerance = tolerance
 
-	if containerLimitsPercentage || containerVolumeLimitsPercentage {
-		waitGroup.Add(1)
-		go c.initGetPodsData()
-		go c.podWatch()
+	// In replay mode the lookup is filled from recorded manifests via LoadPods.
+	// Filters on pod labels or annotations and the host scanners, which map
+	// pod UIDs and container IDs to names, need the watch as well.
+	emptyDirScan := boolEnv("EMPTYDIR_SCAN", "false")
+	deletedFilesScan := boolEnv("DELETED_FILES_SCAN", "false")
+	containerWriteBytes := boolEnv("EPHEMERAL_STORAGE_CONTAINER_WRITE_BYTES", "false")
+	watchPods := containerLimitsPercentage || containerVolumeLimitsPercentage || podLimit || podPhase || thresholdsEnabled || eventThreshold > 0 || emptyDirScan || deletedFilesScan || containerWriteBytes || filter.NeedsPods()
+
+	collectors := make([]Collector, 0, len(clusters))
+	for _, cluster := range clusters {
+		cr := c
+		lookup := make(map[string]pod)
+		cr.lookup = &lookup
+		cr.lookupMutex = &sync.RWMutex{}
+		cr.WaitGroup = &sync.WaitGroup{}
+		cr.cluster = cluster
+		if eventThreshold > 0 {
+			cr.eventRecorder = newEventRecorder(cluster.Clientset)
+		}
+
+		if dev.ReplayPath() == "" {
+			controller, err := remediate.NewController(cluster.Clientset)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to set up remediation")
+				os.Exit(1)
+			}
+			if controller != nil {
+				remediators.Store(cluster.Name, controller)
+				go controller.Run(stop)
+			}
+		}
+
+		if dev.ReplayPath() == "" && (watchPods || remediatorOf(cluster.Name) != nil) {
+			cr.WaitGroup.Add(1)
+			go cr.initGetPodsData()
+			go cr.podWatch()
+		}
+		if dev.ReplayPath() == "" && thresholdsEnabled {
+			go cr.namespaceWatch()
+		}
+		collectors = append(collectors, cr)
 	}
 
-	return c
+	return collectors
+}
+
+// boolEnv returns the boolean environment variable name and exits when it
+// is set to anything else.
+func boolEnv(name string, fallback string) bool {
+	value, err := strconv.ParseBool(dev.GetEnv(name, fallback))
+	if err != nil {
+		log.Error().Err(err).Msgf("Invalid %s", name)
+		os.Exit(1)
+	}
+	return value
+}
+
+// Stop ends the alert webhooks and the remediation started with the
+// collector. It is shared by the collectors of every cluster.
+func (cr Collector) Stop() {
+	cr.stopOnce.Do(func() { close(cr.stop) })
+}
+
+// labels adds the cluster label to the labels of a series while several
+// clusters are scraped.
+func (cr Collector) labels(labels prometheus.Labels) prometheus.Labels {
+	return withCluster(cr.cluster.Name, labels)
+}
+
+// key qualifies the name of a pod or node with the collector's cluster.
+func (cr Collector) key(name string) string {
+	return dev.ClusterKey(cr.cluster.Name, name)
+}
+
+// withCluster adds the cluster label to labels unless cluster is empty.
+func withCluster(cluster string, labels prometheus.Labels) prometheus.Labels {
+	if cluster != "" {
+		labels["cluster"] = cluster
+	}
+	return labels
 }
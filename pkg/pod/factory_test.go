@@ -41,22 +41,103 @@ func TestFactory(t *testing.T) {
 		}
 	})
 
+	t.Run("getPodData_init_and_ephemeral", func(t *testing.T) {
+		lookup := make(map[string]pod)
+		cr := Collector{
+			containerLimitsPercentage: true,
+			lookup:                    &lookup,
+			lookupMutex:               &sync.RWMutex{},
+		}
+		always := v1.ContainerRestartPolicyAlways
+		p := v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "sidecar-pod"},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "c1"}},
+				InitContainers: []v1.Container{{
+					Name:          "sidecar",
+					RestartPolicy: &always,
+					Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+						v1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+					}},
+				}},
+				EphemeralContainers: []v1.EphemeralContainer{
+					{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger"}},
+				},
+			},
+		}
+		cr.getPodData(p)
+		pd := (*cr.lookup)["sidecar-pod"]
+		if len(pd.containers) != 3 {
+			t.Fatalf("expected 3 containers, got %d", len(pd.containers))
+		}
+		want := []struct{ name, containerType string }{
+			{"c1", "container"},
+			{"sidecar", "init"},
+			{"debugger", "ephemeral"},
+		}
+		for i, w := range want {
+			if pd.containers[i].name != w.name || pd.containers[i].containerType != w.containerType {
+				t.Errorf("container %d = %s/%s, want %s/%s", i, pd.containers[i].name, pd.containers[i].containerType, w.name, w.containerType)
+			}
+		}
+		if pd.containers[1].limit != 1024*1024*1024 {
+			t.Errorf("expected sidecar limit 1Gi, got %f", pd.containers[1].limit)
+		}
+	})
+
 	t.Run("getPodData_not_running", func(t *testing.T) {
 		lookup := make(map[string]pod)
 		cr := Collector{
 			lookup:      &lookup,
 			lookupMutex: &sync.RWMutex{},
 		}
+		for _, phase := range []v1.PodPhase{v1.PodPending, v1.PodSucceeded, v1.PodFailed} {
+			p := v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "phase-pod"},
+				Status:     v1.PodStatus{Phase: phase},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "c1"}}},
+			}
+			cr.getPodData(p)
+			cr.lookupMutex.RLock()
+			pd, ok := (*cr.lookup)["phase-pod"]
+			cr.lookupMutex.RUnlock()
+			if !ok {
+				t.Fatalf("expected entry for %s pod", phase)
+			}
+			if pd.phase != phase {
+				t.Fatalf("expected phase %s, got %s", phase, pd.phase)
+			}
+		}
+	})
+
+	t.Run("getPodData_update_replaces_entry", func(t *testing.T) {
+		lookup := make(map[string]pod)
+		cr := Collector{
+			containerLimitsPercentage: true,
+			lookup:                    &lookup,
+			lookupMutex:               &sync.RWMutex{},
+		}
 		p := v1.Pod{
-			ObjectMeta: metav1.ObjectMeta{Name: "pending-pod"},
-			Status:     v1.PodStatus{Phase: v1.PodPending},
+			ObjectMeta: metav1.ObjectMeta{Name: "job-pod"},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+			Spec: v1.PodSpec{
+				Containers:          []v1.Container{{Name: "c1"}},
+				EphemeralContainers: []v1.EphemeralContainer{{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger"}}},
+			},
 		}
 		cr.getPodData(p)
-		cr.lookupMutex.RLock()
-		_, ok := (*cr.lookup)["pending-pod"]
-		cr.lookupMutex.RUnlock()
-		if ok {
-			t.Fatal("expected no entry for pending pod")
+
+		p.Status.Phase = v1.PodSucceeded
+		p.Spec.EphemeralContainers = nil
+		cr.getPodData(p)
+
+		pd := (*cr.lookup)["job-pod"]
+		if pd.phase != v1.PodSucceeded {
+			t.Fatalf("expected phase Succeeded, got %s", pd.phase)
+		}
+		if len(pd.containers) != 1 {
+			t.Fatalf("expected 1 container after update, got %d", len(pd.containers))
 		}
 	})
 
@@ -64,7 +145,7 @@ func TestFactory(t *testing.T) {
 		cr := Collector{}
 		c := v1.Container{Name: "c1"}
 		p := v1.Pod{}
-		result := cr.getContainerData(c, p)
+		result := cr.getContainerData(c, p, "container")
 		if result.name != "c1" {
 			t.Fatalf("expected c1, got %s", result.name)
 		}
@@ -87,7 +168,7 @@ func TestFactory(t *testing.T) {
 			},
 		}
 		p := v1.Pod{}
-		result := cr.getContainerData(c, p)
+		result := cr.getContainerData(c, p, "container")
 		if result.limit != 2*1024*1024*1024 {
 			t.Fatalf("expected limit 2147483648, got %f", result.limit)
 		}
@@ -104,7 +185,7 @@ func TestFactory(t *testing.T) {
 			},
 		}
 		p := v1.Pod{}
-		result := cr.getContainerData(c, p)
+		result := cr.getContainerData(c, p, "container")
 		if result.limit != 0 {
 			t.Fatalf("expected limit 0 when no ephemeral-storage limit, got %f", result.limit)
 		}
@@ -129,7 +210,7 @@ func TestFactory(t *testing.T) {
 				},
 			},
 		}
-		result := cr.getContainerData(c, p)
+		result := cr.getContainerData(c, p, "container")
 		if len(result.emptyDirVolumes) != 1 {
 			t.Fatalf("expected 1 volume, got %d", len(result.emptyDirVolumes))
 		}
@@ -161,10 +242,179 @@ func TestFactory(t *testing.T) {
 				},
 			},
 		}
-		result := cr.getContainerData(c, p)
+		result := cr.getContainerData(c, p, "container")
 		if len(result.emptyDirVolumes) != 0 {
 			t.Fatalf("expected 0 volumes when containerVolumeUsage disabled, got %d", len(result.emptyDirVolumes))
 		}
 	})
 
+	t.Run("getPodData_podLimit", func(t *testing.T) {
+		lookup := make(map[string]pod)
+		cr := Collector{
+			podLimit:    true,
+			lookup:      &lookup,
+			lookupMutex: &sync.RWMutex{},
+		}
+		p := v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "limit-pod"},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{
+					{Name: "c1", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+						v1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+					}}},
+				},
+				Volumes: []v1.Volume{
+					{Name: "vol1", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{
+						SizeLimit: resource.NewQuantity(500*1024*1024, resource.BinarySI),
+					}}},
+				},
+			},
+		}
+		cr.getPodData(p)
+		pd := (*cr.lookup)["limit-pod"]
+		if pd.limit != 1024*1024*1024 || pd.limitSource != "container" {
+			t.Fatalf("expected 1Gi container limit, got %f from %q", pd.limit, pd.limitSource)
+		}
+		if pd.emptyDirSizeLimits["vol1"] != 500*1024*1024 {
+			t.Fatalf("expected vol1 sizeLimit 524288000, got %f", pd.emptyDirSizeLimits["vol1"])
+		}
+	})
+
+	t.Run("Stop_sharedByClusterCollectors", func(t *testing.T) {
+		first := Collector{stop: make(chan struct{}), stopOnce: &sync.Once{}}
+		second := first
+		first.Stop()
+		second.Stop()
+		select {
+		case <-second.stop:
+		default:
+			t.Fatal("expected Stop to close the stop channel of every cluster collector")
+		}
+	})
+}
+
+func TestGetPodLimit(t *testing.T) {
+	always := v1.ContainerRestartPolicyAlways
+	limits := func(q string) v1.ResourceRequirements {
+		return v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse(q)}}
+	}
+
+	tests := []struct {
+		name       string
+		spec       v1.PodSpec
+		wantLimit  float64
+		wantSource string
+	}{
+		{
+			name:       "no limits",
+			spec:       v1.PodSpec{Containers: []v1.Container{{Name: "c1"}}},
+			wantLimit:  0,
+			wantSource: "",
+		},
+		{
+			name: "containers summed",
+			spec: v1.PodSpec{Containers: []v1.Container{
+				{Name: "c1", Resources: limits("100")},
+				{Name: "c2", Resources: limits("200")},
+				{Name: "c3"},
+			}},
+			wantLimit:  300,
+			wantSource: "container",
+		},
+		{
+			name: "sidecars added to containers",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{{Name: "sidecar", RestartPolicy: &always, Resources: limits("50")}},
+				Containers:     []v1.Container{{Name: "c1", Resources: limits("100")}},
+			},
+			wantLimit:  150,
+			wantSource: "container",
+		},
+		{
+			name: "larger init container wins",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{
+					{Name: "sidecar", RestartPolicy: &always, Resources: limits("50")},
+					{Name: "init", Resources: limits("400")},
+				},
+				Containers: []v1.Container{{Name: "c1", Resources: limits("100")}},
+			},
+			wantLimit:  450,
+			wantSource: "container",
+		},
+		{
+			name: "init container only",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{{Name: "init", Resources: limits("400")}},
+				Containers:     []v1.Container{{Name: "c1"}},
+			},
+			wantLimit:  400,
+			wantSource: "container",
+		},
+		{
+			name: "overhead added",
+			spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "c1", Resources: limits("100")}},
+				Overhead:   v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("10")},
+			},
+			wantLimit:  110,
+			wantSource: "container",
+		},
+		{
+			name: "overhead without limits",
+			spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "c1"}},
+				Overhead:   v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("10")},
+			},
+			wantLimit:  0,
+			wantSource: "",
+		},
+		{
+			name: "pod level resources win",
+			spec: v1.PodSpec{
+				Resources:  &v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("1000")}},
+				Containers: []v1.Container{{Name: "c1", Resources: limits("100")}},
+			},
+			wantLimit:  1000,
+			wantSource: "pod",
+		},
+		{
+			name: "pod level resources without ephemeral storage",
+			spec: v1.PodSpec{
+				Resources:  &v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+				Containers: []v1.Container{{Name: "c1", Resources: limits("100")}},
+			},
+			wantLimit:  100,
+			wantSource: "container",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, source := getPodLimit(v1.Pod{Spec: tt.spec})
+			if limit != tt.wantLimit || source != tt.wantSource {
+				t.Errorf("getPodLimit() = (%f, %q), want (%f, %q)", limit, source, tt.wantLimit, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestGetEmptyDirSizeLimits(t *testing.T) {
+	p := v1.Pod{Spec: v1.PodSpec{Volumes: []v1.Volume{
+		{Name: "disk", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{
+			SizeLimit: resource.NewQuantity(100, resource.BinarySI),
+		}}},
+		{Name: "memory", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{
+			Medium:    v1.StorageMediumMemory,
+			SizeLimit: resource.NewQuantity(100, resource.BinarySI),
+		}}},
+		{Name: "unbounded", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+		{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{}}},
+	}}}
+
+	sizeLimits := getEmptyDirSizeLimits(p)
+	if len(sizeLimits) != 1 || sizeLimits["disk"] != 100 {
+		t.Fatalf("expected only disk emptyDir with sizeLimit 100, got %v", sizeLimits)
+	}
 }
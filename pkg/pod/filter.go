@@ -0,0 +1,135 @@
+package pod
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ExcludeAnnotation opts a pod out of the exporter when set to "true" and
+// the annotation opt-out is enabled.
+const ExcludeAnnotation = "ephemeral-storage-metrics/exclude"
+
+// Filter decides which pods the exporter tracks. Namespaces are matched
+// against comma separated include and exclude lists, where every entry is a
+// regular expression matching the whole namespace name, so plain names work
+// as is. The zero Filter admits every pod.
+type Filter struct {
+	include       []*regexp.Regexp
+	exclude       []*regexp.Regexp
+	includeNames  []string // include entries without regexp syntax
+	excludeNames  []string // exclude entries without regexp syntax
+	labelSelector string
+	selector      labels.Selector
+	// annotationOptOut honours ExcludeAnnotation.
+	annotationOptOut bool
+}
+
+// NewFilter parses the namespace lists and the pod label selector.
+func NewFilter(include string, exclude string, labelSelector string, annotationOptOut bool) (Filter, error) {
+	f := Filter{annotationOptOut: annotationOptOut}
+	var err error
+	if f.include, f.includeNames, err = parseNamespaces(include); err != nil {
+		return f, fmt.Errorf("namespace include: %w", err)
+	}
+	if f.exclude, f.excludeNames, err = parseNamespaces(exclude); err != nil {
+		return f, fmt.Errorf("namespace exclude: %w", err)
+	}
+	if labelSelector != "" {
+		if f.selector, err = labels.Parse(labelSelector); err != nil {
+			return f, fmt.Errorf("pod label selector: %w", err)
+		}
+		f.labelSelector = labelSelector
+	}
+	return f, nil
+}
+
+func parseNamespaces(list string) ([]*regexp.Regexp, []string, error) {
+	var patterns []*regexp.Regexp
+	var names []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + entry + ")$")
+		if err != nil {
+			return nil, nil, err
+		}
+		patterns = append(patterns, re)
+		if regexp.QuoteMeta(entry) == entry {
+			names = append(names, entry)
+		}
+	}
+	return patterns, names, nil
+}
+
+// AdmitNamespace reports whether pods in namespace are tracked.
+func (f Filter) AdmitNamespace(namespace string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(namespace) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// Admit reports whether p is tracked.
+func (f Filter) Admit(p v1.Pod) bool {
+	if !f.AdmitNamespace(p.Namespace) {
+		return false
+	}
+	if f.annotationOptOut && p.Annotations[ExcludeAnnotation] == "true" {
+		return false
+	}
+	return f.selector == nil || f.selector.Matches(labels.Set(p.Labels))
+}
+
+// NeedsPods reports whether the filter needs pod metadata beyond the
+// namespace the stats summary carries, i.e. whether pods must be watched to
+// apply it.
+func (f Filter) NeedsPods() bool {
+	return f.selector != nil || f.annotationOptOut
+}
+
+// fieldSelector returns the namespace requirements the apiserver can apply
+// itself: a single literal include, and every literal exclude. Regular
+// expressions are only matched by the exporter.
+func (f Filter) fieldSelector() []string {
+	var requirements []string
+	if len(f.include) == 1 && len(f.includeNames) == 1 {
+		requirements = append(requirements, "metadata.namespace="+f.includeNames[0])
+	}
+	for _, name := range f.excludeNames {
+		requirements = append(requirements, "metadata.namespace!="+name)
+	}
+	return requirements
+}
+
+// Admit reports whether the pod podName in podNamespace of a stats summary
+// is tracked. Filters on pod metadata only admit pods the watch has seen and
+// admitted, so a new pod is exported from the first scrape after the watch
+// delivered it.
+func (cr Collector) Admit(podName string, podNamespace string) bool {
+	if !cr.filter.AdmitNamespace(podNamespace) {
+		return false
+	}
+	if !cr.filter.NeedsPods() {
+		return true
+	}
+	cr.lookupMutex.RLock()
+	defer cr.lookupMutex.RUnlock()
+	p, ok := (*cr.lookup)[podName]
+	return ok && p.namespace == podNamespace
+}
@@ -0,0 +1,119 @@
+package pod
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFilter(t *testing.T) {
+	newPod := func(namespace string, labels map[string]string, annotations map[string]string) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: namespace, Labels: labels, Annotations: annotations}}
+	}
+	optOut := map[string]string{ExcludeAnnotation: "true"}
+
+	tests := []struct {
+		name          string
+		include       string
+		exclude       string
+		labelSelector string
+		optOut        bool
+		pod           v1.Pod
+		want          bool
+	}{
+		{"zero filter", "", "", "", false, newPod("default", nil, nil), true},
+		{"included name", "default, team-a", "", "", false, newPod("team-a", nil, nil), true},
+		{"not included", "default,team-a", "", "", false, newPod("team-b", nil, nil), false},
+		{"names match whole namespaces", "team", "", "", false, newPod("team-a", nil, nil), false},
+		{"included regexp", "team-.*", "", "", false, newPod("team-b", nil, nil), true},
+		{"excluded name", "", "kube-system", "", false, newPod("kube-system", nil, nil), false},
+		{"exclude wins over include", "team-.*", "team-b", "", false, newPod("team-b", nil, nil), false},
+		{"excluded regexp", "", "kube-.*", "", false, newPod("kube-public", nil, nil), false},
+		{"label selector matches", "", "", "app=web,tier!=cache", false, newPod("default", map[string]string{"app": "web"}, nil), true},
+		{"label selector rejects", "", "", "app=web", false, newPod("default", map[string]string{"app": "db"}, nil), false},
+		{"annotation opt-out", "", "", "", true, newPod("default", nil, optOut), false},
+		{"annotation ignored when disabled", "", "", "", false, newPod("default", nil, optOut), true},
+		{"annotation other value", "", "", "", true, newPod("default", nil, map[string]string{ExcludeAnnotation: "false"}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.include, tt.exclude, tt.labelSelector, tt.optOut)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Admit(tt.pod); got != tt.want {
+				t.Errorf("Admit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range [][3]string{{"team-(", "", ""}, {"", "[", ""}, {"", "", "app in (web"}} {
+		if _, err := NewFilter(invalid[0], invalid[1], invalid[2], false); err == nil {
+			t.Errorf("NewFilter(%q) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestFilterFieldSelector(t *testing.T) {
+	tests := []struct {
+		include string
+		exclude string
+		want    []string
+	}{
+		{"", "", nil},
+		{"team-a", "", []string{"metadata.namespace=team-a"}},
+		{"team-a,team-b", "", nil},
+		{"team-.*", "", nil},
+		{"", "kube-system, kube-.*,monitoring", []string{"metadata.namespace!=kube-system", "metadata.namespace!=monitoring"}},
+	}
+	for _, tt := range tests {
+		f, err := NewFilter(tt.include, tt.exclude, "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.fieldSelector(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fieldSelector(%q, %q) = %q, want %q", tt.include, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+func TestCollectorAdmit(t *testing.T) {
+	filter, err := NewFilter("", "kube-system", "app=web", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := Collector{
+		lookup:      &map[string]pod{},
+		lookupMutex: &sync.RWMutex{},
+		filter:      filter,
+	}
+	cr.getPodData(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"app": "web"}}})
+	cr.getPodData(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", Labels: map[string]string{"app": "db"}}})
+
+	if _, ok := (*cr.lookup)["db"]; ok {
+		t.Error("filtered pod stored in the lookup")
+	}
+	for _, tt := range []struct {
+		name, namespace string
+		want            bool
+	}{
+		{"web", "default", true},
+		{"web", "other", false}, // same name, pod not watched
+		{"db", "default", false},
+		{"unknown", "default", false},
+		{"web", "kube-system", false},
+	} {
+		if got := cr.Admit(tt.name, tt.namespace); got != tt.want {
+			t.Errorf("Admit(%s, %s) = %v, want %v", tt.name, tt.namespace, got, tt.want)
+		}
+	}
+
+	// Namespace filters need no pod metadata.
+	cr.filter, _ = NewFilter("", "kube-system", "", false)
+	if !cr.Admit("unknown", "default") || cr.Admit("unknown", "kube-system") {
+		t.Error("namespace only filter must admit unwatched pods by namespace")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
@@ -15,11 +16,50 @@ import (
 )
 
 type pod struct {
+	namespace  string
+	uid        string
 	containers []container
+	// containerIDs maps the runtime ID of each started container to its name.
+	containerIDs map[string]string
+	phase        v1.PodPhase
+	// limit is the pod-wide ephemeral-storage limit kubelet evicts on and
+	// limitSource records where it came from ("pod" or "container").
+	limit              float64
+	limitSource        string
+	emptyDirSizeLimits map[string]float64
+	// thresholds set through the pod's annotations.
+	thresholds thresholds
+}
+
+// PodRef identifies a pod known from the pod watch.
+type PodRef struct {
+	Name      string
+	Namespace string
+	UID       string
+	// Containers maps runtime container IDs, without the runtime scheme,
+	// to container names.
+	Containers map[string]string
+}
+
+// Pods returns the pods in the lookup, for scanners walking their
+// directories on the node.
+func (cr Collector) Pods() []PodRef {
+	cr.lookupMutex.RLock()
+	defer cr.lookupMutex.RUnlock()
+	pods := make([]PodRef, 0, len(*cr.lookup))
+	for name, p := range *cr.lookup {
+		if p.uid != "" {
+			pods = append(pods, PodRef{Name: name, Namespace: p.namespace, UID: p.uid, Containers: p.containerIDs})
+		}
+	}
+	return pods
 }
 
 type container struct {
-	name            string
+	name string
+	// containerType is "container", "init" or "ephemeral" depending on the
+	// pod spec list the container was declared in.
+	containerType   string
 	limit           float64
 	emptyDirVolumes []emptyDirVolumes
 }
@@ -31,17 +71,74 @@ type emptyDirVolumes struct {
 }
 
 // Collector for pod data
+// Pods are tracked in every phase, since Succeeded and Failed pods keep their
+// ephemeral storage on disk until they are garbage collected. Each update
+// replaces the entry so the lookup follows the pod's current spec and phase.
+// Pods the filter rejects are dropped together with their metrics, e.g. when
+// a label change moves them out of the pod label selector.
 func (cr Collector) getPodData(p v1.Pod) {
-	if p.Status.Phase == "Running" {
-		var collectContainers []container
+	if !cr.filter.Admit(p) {
+		cr.lookupMutex.Lock()
+		old, ok := (*cr.lookup)[p.Name]
+		ok = ok && old.namespace == p.Namespace
+		if ok {
+			delete(*cr.lookup, p.Name)
+		}
+		cr.lookupMutex.Unlock()
+		if ok {
+			evictPodByName(cr.cluster.Name, p)
+		}
+		return
+	}
+
+	var collectContainers []container
+
+	for _, x := range p.Spec.Containers {
+		collectContainers = append(collectContainers, cr.getContainerData(x, p, "container"))
+	}
+	// Init containers cover native sidecars (restartable init containers),
+	// which keep running and writing next to the regular containers.
+	for _, x := range p.Spec.InitContainers {
+		collectContainers = append(collectContainers, cr.getContainerData(x, p, "init"))
+	}
+	for _, x := range p.Spec.EphemeralContainers {
+		collectContainers = append(collectContainers, cr.getContainerData(v1.Container(x.EphemeralContainerCommon), p, "ephemeral"))
+	}
 
-		for _, x := range p.Spec.Containers {
-			collectContainers = append(collectContainers, cr.getContainerData(x, p))
+	podData := pod{namespace: p.Namespace, uid: string(p.UID), containers: collectContainers, phase: p.Status.Phase,
+		containerIDs: getContainerIDs(p)}
+	if cr.podLimit || cr.thresholds || cr.eventThreshold > 0 || remediatorOf(cr.cluster.Name) != nil {
+		podData.limit, podData.limitSource = getPodLimit(p)
+		podData.emptyDirSizeLimits = getEmptyDirSizeLimits(p)
+	}
+	if cr.thresholds {
+		podData.thresholds = parseThresholds("pod", p.Namespace+"/"+p.Name, p.Annotations)
+	}
+
+	cr.lookupMutex.Lock()
+	(*cr.lookup)[p.Name] = podData
+	cr.lookupMutex.Unlock()
+}
+
+// getContainerIDs maps the runtime IDs of the pod's started containers, e.g.
+// "containerd://<id>", to their names.
+func getContainerIDs(p v1.Pod) map[string]string {
+	ids := map[string]string{}
+	for _, statuses := range [][]v1.ContainerStatus{p.Status.ContainerStatuses, p.Status.InitContainerStatuses, p.Status.EphemeralContainerStatuses} {
+		for _, status := range statuses {
+			if _, id, ok := strings.Cut(status.ContainerID, "://"); ok {
+				ids[id] = status.Name
+			}
 		}
+	}
+	return ids
+}
 
-		cr.lookupMutex.Lock()
-		(*cr.lookup)[p.Name] = pod{containers: collectContainers}
-		cr.lookupMutex.Unlock()
+// LoadPods fills the pod lookup from pod manifests instead of the apiserver,
+// e.g. when replaying a recording.
+func (cr Collector) LoadPods(pods []v1.Pod) {
+	for _, p := range pods {
+		cr.getPodData(p)
 	}
 }
 
@@ -51,7 +148,14 @@ func (cr Collector) initGetPodsData() {
 
 	allPods := make([]v1.Pod, 0, 500)
 	for {
-		pods, err := dev.Clientset.CoreV1().Pods("").List(context.TODO(), listOpts)
+		pods, err := cr.cluster.Clientset.CoreV1().Pods("").List(context.TODO(), listOpts)
+		if err != nil && cr.cluster.Name != "" {
+			// Other clusters keep being scraped while this one is unreachable.
+			log.Error().Msgf("Error getting pods of cluster %s, retrying: %v", cr.cluster.Name, err)
+			time.Sleep(time.Duration(cr.sampleInterval) * time.Second)
+			listOpts, allPods = cr.getPodsListOptions(), allPods[:0]
+			continue
+		}
 		if err != nil {
 			log.Error().Msgf("Error getting pods: %v\n", err)
 			os.Exit(1)
@@ -78,29 +182,40 @@ func (cr Collector) getPodsListOptions() metav1.ListOptions {
 	if cr.listPodsWithCache {
 		listOpts.ResourceVersion = "0"
 	}
-	if cr.deployAsDaemonSet {
-		listOpts.FieldSelector = fmt.Sprintf("spec.nodeName=%s", cr.currentNodeName)
-	}
+	cr.tweakListOptions(&listOpts)
 	listOpts.Limit = 500
 	return listOpts
 }
 
+// tweakListOptions narrows pod lists and watches down to the pods of the
+// current node in DaemonSet mode and to the pods the filter can reject on
+// the apiserver, so filtered pods are never sent to the exporter.
+func (cr Collector) tweakListOptions(options *metav1.ListOptions) {
+	fieldSelector := cr.filter.fieldSelector()
+	if cr.deployAsDaemonSet {
+		fieldSelector = append([]string{fmt.Sprintf("spec.nodeName=%s", cr.currentNodeName)}, fieldSelector...)
+	}
+	options.FieldSelector = strings.Join(fieldSelector, ",")
+	options.LabelSelector = cr.filter.labelSelector
+}
+
 func (cr Collector) podWatch() {
 	cr.WaitGroup.Wait()
 	stopCh := make(chan struct{})
 	defer close(stopCh)
-	var sharedInformerFactory informers.SharedInformerFactory
-	if cr.deployAsDaemonSet {
-		sharedInformerFactory = informers.NewSharedInformerFactoryWithOptions(dev.Clientset, time.Duration(cr.sampleInterval)*time.Second, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
-			options.FieldSelector = fmt.Sprintf("spec.nodeName=%s", cr.currentNodeName)
-		}))
-	} else {
-		sharedInformerFactory = informers.NewSharedInformerFactory(dev.Clientset, time.Duration(cr.sampleInterval)*time.Second)
-	}
+	sharedInformerFactory := informers.NewSharedInformerFactoryWithOptions(cr.cluster.Clientset, time.Duration(cr.sampleInterval)*time.Second, informers.WithTweakListOptions(cr.tweakListOptions))
 	podInformer := sharedInformerFactory.Core().V1().Pods().Informer()
 
 	// Define event handlers for Pod events
 	eventHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			p, ok := obj.(*v1.Pod)
+			if !ok {
+				log.Error().Msgf("podWatch: AddFunc got unexpected type %T", obj)
+				return
+			}
+			cr.getPodData(*p)
+		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			p, ok := newObj.(*v1.Pod)
 			if !ok {
@@ -128,15 +243,15 @@ func (cr Collector) podWatch() {
 			cr.lookupMutex.Lock()
 			delete(*cr.lookup, p.Name)
 			cr.lookupMutex.Unlock()
-			evictPodByName(*p)
+			evictPodByName(cr.cluster.Name, *p)
 		},
 	}
 
 	// Register the event handlers with the informer
 	_, err := podInformer.AddEventHandler(eventHandler)
 	if err != nil {
-		log.Err(err)
-		os.Exit(1)
+		dev.WatchStopped(cr.cluster.Name, "podWatch", err)
+		return
 	}
 
 	// Start the informer to begin watching for Pod events
@@ -146,18 +261,19 @@ func (cr Collector) podWatch() {
 		time.Sleep(time.Duration(cr.sampleInterval) * time.Second)
 		select {
 		case <-stopCh:
-			log.Error().Msg("Watcher podWatch stopped.")
-			os.Exit(1)
+			dev.WatchStopped(cr.cluster.Name, "podWatch", nil)
+			return
 		}
 	}
 
 }
 
 // Collector for container data
-func (cr Collector) getContainerData(c v1.Container, p v1.Pod) container {
+func (cr Collector) getContainerData(c v1.Container, p v1.Pod, containerType string) container {
 
 	setContainer := container{}
 	setContainer.name = c.Name
+	setContainer.containerType = containerType
 	matchKey := v1.ResourceName("ephemeral-storage")
 
 	if (cr.containerVolumeUsage || cr.containerVolumeLimitsPercentage) && p.Spec.Volumes != nil {
@@ -189,7 +305,7 @@ func (cr Collector) getContainerData(c v1.Container, p v1.Pod) container {
 		}
 
 	}
-	if cr.containerLimitsPercentage {
+	if cr.containerLimitsPercentage || cr.eventThreshold > 0 {
 		for key, val := range c.Resources.Limits {
 			if key == matchKey {
 				setContainer.limit = val.AsApproximateFloat64()
@@ -199,3 +315,75 @@ func (cr Collector) getContainerData(c v1.Container, p v1.Pod) container {
 	}
 	return setContainer
 }
+
+// getPodLimit returns the pod-wide ephemeral-storage limit kubelet compares
+// the pod's total usage against before evicting it. It mirrors
+// resourcehelper.PodLimits: a pod-level spec.resources limit wins when set,
+// otherwise regular and sidecar container limits are summed, raised to the
+// largest init container footprint and, when any limit is set, increased by
+// the pod overhead.
+// A zero limit means kubelet does not enforce a pod-wide limit.
+func getPodLimit(p v1.Pod) (float64, string) {
+	if p.Spec.Resources != nil {
+		if val, ok := p.Spec.Resources.Limits[v1.ResourceEphemeralStorage]; ok {
+			return val.AsApproximateFloat64(), "pod"
+		}
+	}
+
+	var limit, sidecarLimit, initLimit float64
+	var found, sidecarFound, initFound bool
+	for _, c := range p.Spec.Containers {
+		if val, ok := c.Resources.Limits[v1.ResourceEphemeralStorage]; ok {
+			limit += val.AsApproximateFloat64()
+			found = true
+		}
+	}
+
+	for _, c := range p.Spec.InitContainers {
+		val, ok := c.Resources.Limits[v1.ResourceEphemeralStorage]
+		candidate, candidateFound := sidecarLimit, sidecarFound
+		if c.RestartPolicy != nil && *c.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			// Sidecars keep running next to the regular containers.
+			if ok {
+				limit += val.AsApproximateFloat64()
+				sidecarLimit += val.AsApproximateFloat64()
+				found, sidecarFound = true, true
+			}
+			candidate, candidateFound = sidecarLimit, sidecarFound
+		} else if ok {
+			// Regular init containers run alongside the sidecars started before them.
+			candidate += val.AsApproximateFloat64()
+			candidateFound = true
+		}
+		if candidateFound && (!initFound || candidate > initLimit) {
+			initLimit, initFound = candidate, true
+		}
+	}
+
+	if initFound && (!found || initLimit > limit) {
+		limit, found = initLimit, true
+	}
+	if val, ok := p.Spec.Overhead[v1.ResourceEphemeralStorage]; ok && found {
+		limit += val.AsApproximateFloat64()
+	}
+	if !found {
+		return 0, ""
+	}
+	return limit, "container"
+}
+
+// getEmptyDirSizeLimits returns the sizeLimit of every disk backed emptyDir,
+// since kubelet evicts the pod as soon as one of them is exceeded.
+func getEmptyDirSizeLimits(p v1.Pod) map[string]float64 {
+	sizeLimits := make(map[string]float64)
+	for _, v := range p.Spec.Volumes {
+		emptyDir := v.VolumeSource.EmptyDir
+		if emptyDir == nil || emptyDir.Medium != v1.StorageMediumDefault || emptyDir.SizeLimit == nil {
+			continue
+		}
+		if sizeLimit := emptyDir.SizeLimit.AsApproximateFloat64(); sizeLimit > 0 {
+			sizeLimits[v.Name] = sizeLimit
+		}
+	}
+	return sizeLimits
+}
@@ -1,7 +1,10 @@
 package pod
 
 import (
+	"maps"
 	"testing"
+
+	v1 "k8s.io/api/core/v1"
 )
 
 func TestGetPodsListOptions(t *testing.T) {
@@ -10,8 +13,10 @@ func TestGetPodsListOptions(t *testing.T) {
 		listPodsWithCache bool
 		deployAsDaemonSet bool
 		currentNodeName   string
+		filter            [3]string
 		wantResourceVer   string
 		wantFieldSelector string
+		wantLabelSelector string
 		wantLimit         int64
 	}{
 		{
@@ -50,14 +55,34 @@ func TestGetPodsListOptions(t *testing.T) {
 			wantFieldSelector: "spec.nodeName=node-2",
 			wantLimit:         500,
 		},
+		{
+			name:              "filters",
+			filter:            [3]string{"team-a", "", "app=web"},
+			wantFieldSelector: "metadata.namespace=team-a",
+			wantLabelSelector: "app=web",
+			wantLimit:         500,
+		},
+		{
+			name:              "daemonset + filters",
+			deployAsDaemonSet: true,
+			currentNodeName:   "node-3",
+			filter:            [3]string{"team-.*", "kube-system,kube-public", ""},
+			wantFieldSelector: "spec.nodeName=node-3,metadata.namespace!=kube-system,metadata.namespace!=kube-public",
+			wantLimit:         500,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter(tt.filter[0], tt.filter[1], tt.filter[2], false)
+			if err != nil {
+				t.Fatal(err)
+			}
 			c := Collector{
 				listPodsWithCache: tt.listPodsWithCache,
 				deployAsDaemonSet: tt.deployAsDaemonSet,
 				currentNodeName:   tt.currentNodeName,
+				filter:            filter,
 			}
 			opts := c.getPodsListOptions()
 			if opts.ResourceVersion != tt.wantResourceVer {
@@ -66,9 +91,24 @@ func TestGetPodsListOptions(t *testing.T) {
 			if opts.FieldSelector != tt.wantFieldSelector {
 				t.Errorf("FieldSelector = %q, want %q", opts.FieldSelector, tt.wantFieldSelector)
 			}
+			if opts.LabelSelector != tt.wantLabelSelector {
+				t.Errorf("LabelSelector = %q, want %q", opts.LabelSelector, tt.wantLabelSelector)
+			}
 			if opts.Limit != tt.wantLimit {
 				t.Errorf("Limit = %d, want %d", opts.Limit, tt.wantLimit)
 			}
 		})
 	}
 }
+
+func TestGetContainerIDs(t *testing.T) {
+	p := v1.Pod{Status: v1.PodStatus{
+		ContainerStatuses:          []v1.ContainerStatus{{Name: "app", ContainerID: "containerd://abc"}, {Name: "waiting"}},
+		InitContainerStatuses:      []v1.ContainerStatus{{Name: "sidecar", ContainerID: "cri-o://def"}},
+		EphemeralContainerStatuses: []v1.ContainerStatus{{Name: "debug", ContainerID: "docker://123"}},
+	}}
+	want := map[string]string{"abc": "app", "def": "sidecar", "123": "debug"}
+	if got := getContainerIDs(p); !maps.Equal(got, want) {
+		t.Errorf("getContainerIDs = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package pod
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LogRotation holds the container log rotation settings of a node's kubelet.
+type LogRotation struct {
+	MaxBytes        float64       // containerLogMaxSize
+	MaxFiles        int           // containerLogMaxFiles
+	MonitorInterval time.Duration // containerLogMonitorInterval
+}
+
+// Budget is the most log bytes kubelet keeps per container: the live file
+// plus the rotated ones, each up to MaxBytes.
+func (r LogRotation) Budget() float64 {
+	return r.MaxBytes * float64(r.MaxFiles)
+}
+
+// logSample is the log usage of a container at a scrape.
+type logSample struct {
+	usedBytes float64
+	at        time.Time
+}
+
+var (
+	// logRotations holds the rotation settings read from kubelet configz.
+	// Keyed by cluster and nodeName; value is LogRotation.
+	logRotations sync.Map
+
+	logSamplesMutex sync.Mutex
+	logSamples      = map[string]map[string]logSample{} // keyed by cluster and pod name, then container
+
+	logsNow = time.Now
+)
+
+// SetLogRotation records the log rotation settings of node.
+func SetLogRotation(node string, r LogRotation) {
+	logRotations.Store(node, r)
+}
+
+// setLogRotationMetrics exports the log usage of each container in percent
+// of the node's rotation budget, and flags containers whose logs outpace
+// rotation: kubelet only rotates every MonitorInterval, so a container
+// writing more than MaxBytes per interval grows past the budget, and usage
+// above the budget shows rotation did not keep up. Containers on nodes whose
+// settings are unknown are not exported.
+func (cr Collector) setLogRotationMetrics(podName string, podNamespace string, nodeName string, containers []ContainerStats) {
+	value, ok := logRotations.Load(cr.key(nodeName))
+	rotation, _ := value.(LogRotation)
+	now := logsNow()
+
+	logSamplesMutex.Lock()
+	previous := logSamples[cr.key(podName)]
+	logSamplesMutex.Unlock()
+	current := make(map[string]logSample, len(containers))
+
+	for _, c := range containers {
+		labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+			"pod_name": podName, "node_name": nodeName, "container": c.Name})
+		used := float64(c.Logs.UsedBytes)
+		current[c.Name] = logSample{usedBytes: used, at: now}
+		if !ok || rotation.Budget() <= 0 {
+			containerLogsRotationPercentageVec.Delete(labels)
+			containerLogsRotationOutpacedVec.Delete(labels)
+			continue
+		}
+		containerLogsRotationPercentageVec.With(labels).Set(used / rotation.Budget() * 100.0)
+
+		outpaced := used > rotation.Budget()
+		if last, seen := previous[c.Name]; seen && used > last.usedBytes && now.After(last.at) && rotation.MonitorInterval > 0 {
+			// A drop means rotation ran in between, the rate is only known while growing.
+			rate := (used - last.usedBytes) / now.Sub(last.at).Seconds()
+			outpaced = outpaced || rate*rotation.MonitorInterval.Seconds() > rotation.MaxBytes
+		}
+		setValue := 0.0
+		if outpaced {
+			setValue = 1
+		}
+		containerLogsRotationOutpacedVec.With(labels).Set(setValue)
+	}
+
+	logSamplesMutex.Lock()
+	logSamples[cr.key(podName)] = current
+	logSamplesMutex.Unlock()
+}
+
+// forgetLogSamples drops the log usage samples of an evicted pod.
+func forgetLogSamples(podName string) {
+	logSamplesMutex.Lock()
+	defer logSamplesMutex.Unlock()
+	delete(logSamples, podName)
+}
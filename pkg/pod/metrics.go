@@ -10,6 +10,10 @@ import (
 	"github.com/rs/zerolog/log"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/remediate"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/sample"
 )
 
 var (
@@ -34,11 +38,22 @@ var (
 	inodesGaugeVec                     *prometheus.GaugeVec
 	inodesFreeGaugeVec                 *prometheus.GaugeVec
 	inodesUsedGaugeVec                 *prometheus.GaugeVec
+	podLimitBytesVec                   *prometheus.GaugeVec
+	podLimitPercentageVec              *prometheus.GaugeVec
+	podPhaseVec                        *prometheus.GaugeVec
+	thresholdPercentageVec             *prometheus.GaugeVec
+	thresholdBreachVec                 *prometheus.GaugeVec
+	containerLogsRotationPercentageVec *prometheus.GaugeVec
+	containerLogsRotationOutpacedVec   *prometheus.GaugeVec
 
 	// nodeTrackers holds per-node scrape-driven eviction state.
-	// Keyed by nodeName; value is *podTracker.
+	// Keyed by cluster and nodeName; value is *podTracker.
 	nodeTrackers sync.Map
 
+	// podContainers holds the containers last seen in the stats summary per pod.
+	// Keyed by cluster and podName; value is *containerTracker.
+	podContainers sync.Map
+
 	// scrapeMissTolerance is the number of consecutive scrapes a pod
 	// can be missing from the stats summary before its metrics are evicted.
 	// Set in NewCollector from the SCRAPE_MISS_TOLERANCE env var.
@@ -51,7 +66,22 @@ var (
 // it reaches scrapeMissTolerance, the pod's metrics are evicted.
 type podTracker struct {
 	mu       sync.Mutex
-	lastSeen map[string]int
+	lastSeen map[string]trackedPod
+}
+
+// trackedPod is the namespace and miss count of a pod in a podTracker, keyed
+// by pod name.
+type trackedPod struct {
+	namespace string
+	misses    int
+}
+
+// containerTracker records which containers of a pod were exported on the
+// previous scrape, so series of containers that left the stats summary
+// (completed init containers, removed ephemeral containers) can be evicted.
+type containerTracker struct {
+	nodeName string // qualified with the cluster like the podContainers keys
+	names    map[string]struct{}
 }
 
 type FsStats struct {
@@ -82,23 +112,23 @@ func (cr Collector) createMetrics() {
 		Name: "ephemeral_storage_pod_usage",
 		Help: "Current ephemeral byte usage of pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
 			"pod_namespace",
 			// Name of Node where pod is placed.
 			"node_name",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(podGaugeVec)
+	sample.MustRegister(podGaugeVec)
 
 	containerVolumeUsageVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_volume_usage",
 		Help: "Current ephemeral storage used by a container's volume in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -107,20 +137,22 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
+			// Type of container ("container", "init" or "ephemeral")
+			"container_type",
 			// Name of Volume
 			"volume_name",
 			// Name of Mount Path
 			"mount_path",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(containerVolumeUsageVec)
+	sample.MustRegister(containerVolumeUsageVec)
 
 	containerPercentageLimitsVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_limit_percentage",
 		Help: "Percentage of ephemeral storage used by a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -129,18 +161,20 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
+			// Type of container ("container", "init" or "ephemeral")
+			"container_type",
 			// Source of the limit (either "container" for pod.spec.containers.resources.limits or "node")
 			"source",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(containerPercentageLimitsVec)
+	sample.MustRegister(containerPercentageLimitsVec)
 
 	containerPercentageVolumeLimitsVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_volume_limit_percentage",
 		Help: "Percentage of ephemeral storage used by a container's volume in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -149,20 +183,22 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
+			// Type of container ("container", "init" or "ephemeral")
+			"container_type",
 			// Name of Volume
 			"volume_name",
 			// Name of Mount Path
 			"mount_path",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(containerPercentageVolumeLimitsVec)
+	sample.MustRegister(containerPercentageVolumeLimitsVec)
 
 	containerRootfsUsedBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_rootfs_used_bytes",
 		Help: "Current rootfs bytes used by a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -171,15 +207,15 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerRootfsUsedBytesVec)
+	sample.MustRegister(containerRootfsUsedBytesVec)
 
 	containerRootfsAvailableBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_rootfs_available_bytes",
 		Help: "Current rootfs bytes available to a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -188,15 +224,15 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerRootfsAvailableBytesVec)
+	sample.MustRegister(containerRootfsAvailableBytesVec)
 
 	containerRootfsCapacityBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_rootfs_capacity_bytes",
 		Help: "Current rootfs bytes capacity for a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -205,15 +241,15 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerRootfsCapacityBytesVec)
+	sample.MustRegister(containerRootfsCapacityBytesVec)
 
 	containerLogsUsedBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_logs_used_bytes",
 		Help: "Current logs bytes used by a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -222,15 +258,15 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerLogsUsedBytesVec)
+	sample.MustRegister(containerLogsUsedBytesVec)
 
 	containerLogsAvailableBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_logs_available_bytes",
 		Help: "Current logs bytes available to a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -239,15 +275,15 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerLogsAvailableBytesVec)
+	sample.MustRegister(containerLogsAvailableBytesVec)
 
 	containerLogsCapacityBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_logs_capacity_bytes",
 		Help: "Current logs bytes capacity for a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
@@ -256,161 +292,289 @@ func (cr Collector) createMetrics() {
 			"node_name",
 			// Name of container
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerLogsCapacityBytesVec)
+	sample.MustRegister(containerLogsCapacityBytesVec)
 
 	containerRootfsUsagePercentageVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_rootfs_usage_percentage",
 		Help: "Percentage of rootfs capacity used by a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			"pod_name",
 			"pod_namespace",
 			"node_name",
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerRootfsUsagePercentageVec)
+	sample.MustRegister(containerRootfsUsagePercentageVec)
 
 	containerLogsUsagePercentageVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_logs_usage_percentage",
 		Help: "Percentage of logs capacity used by a container in a pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			"pod_name",
 			"pod_namespace",
 			"node_name",
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerLogsUsagePercentageVec)
+	sample.MustRegister(containerLogsUsagePercentageVec)
 
 	containerRootfsInodesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_rootfs_inodes",
 		Help: "Maximum number of inodes in the container rootfs",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			"pod_name",
 			"pod_namespace",
 			"node_name",
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerRootfsInodesVec)
+	sample.MustRegister(containerRootfsInodesVec)
 
 	containerRootfsInodesFreeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_rootfs_inodes_free",
 		Help: "Number of free inodes in the container rootfs",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			"pod_name",
 			"pod_namespace",
 			"node_name",
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerRootfsInodesFreeVec)
+	sample.MustRegister(containerRootfsInodesFreeVec)
 
 	containerRootfsInodesUsedVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_rootfs_inodes_used",
 		Help: "Number of used inodes in the container rootfs",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			"pod_name",
 			"pod_namespace",
 			"node_name",
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerRootfsInodesUsedVec)
+	sample.MustRegister(containerRootfsInodesUsedVec)
 
 	containerLogsInodesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_logs_inodes",
 		Help: "Maximum number of inodes in the container logs",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			"pod_name",
 			"pod_namespace",
 			"node_name",
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerLogsInodesVec)
+	sample.MustRegister(containerLogsInodesVec)
 
 	containerLogsInodesFreeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_logs_inodes_free",
 		Help: "Number of free inodes in the container logs",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			"pod_name",
 			"pod_namespace",
 			"node_name",
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerLogsInodesFreeVec)
+	sample.MustRegister(containerLogsInodesFreeVec)
 
 	containerLogsInodesUsedVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_container_logs_inodes_used",
 		Help: "Number of used inodes in the container logs",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			"pod_name",
 			"pod_namespace",
 			"node_name",
 			"container",
-		},
+		}),
 	)
-	prometheus.MustRegister(containerLogsInodesUsedVec)
+	sample.MustRegister(containerLogsInodesUsedVec)
 
 	inodesGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_inodes",
 		Help: "Maximum number of inodes in the pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
 			"pod_namespace",
 			// Name of Node where pod is placed.
 			"node_name",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(inodesGaugeVec)
+	sample.MustRegister(inodesGaugeVec)
 
 	inodesFreeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_inodes_free",
 		Help: "Number of free inodes in the pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
 			"pod_namespace",
 			// Name of Node where pod is placed.
 			"node_name",
-		},
+		}),
 	)
 
-	prometheus.MustRegister(inodesFreeGaugeVec)
+	sample.MustRegister(inodesFreeGaugeVec)
 
 	inodesUsedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ephemeral_storage_inodes_used",
 		Help: "Number of used inodes in the pod",
 	},
-		[]string{
+		dev.WithClusterLabel([]string{
+			// name of pod for Ephemeral Storage
+			"pod_name",
+			// namespace of pod for Ephemeral Storage
+			"pod_namespace",
+			// Name of Node where pod is placed.
+			"node_name",
+		}),
+	)
+
+	sample.MustRegister(inodesUsedGaugeVec)
+
+	podLimitBytesVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_pod_limit_bytes",
+		Help: "Ephemeral storage limit closest to triggering a kubelet eviction of the pod",
+	},
+		dev.WithClusterLabel([]string{
+			// name of pod for Ephemeral Storage
+			"pod_name",
+			// namespace of pod for Ephemeral Storage
+			"pod_namespace",
+			// Name of Node where pod is placed.
+			"node_name",
+			// Source of the limit ("pod" for pod.spec.resources.limits, "container" for the aggregated
+			// container limits or "volume" for an emptyDir sizeLimit)
+			"source",
+		}),
+	)
+
+	prometheus.MustRegister(podLimitBytesVec)
+
+	podLimitPercentageVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_pod_limit_percentage",
+		Help: "Percentage of the ephemeral storage limit closest to triggering a kubelet eviction of the pod",
+	},
+		dev.WithClusterLabel([]string{
+			// name of pod for Ephemeral Storage
+			"pod_name",
+			// namespace of pod for Ephemeral Storage
+			"pod_namespace",
+			// Name of Node where pod is placed.
+			"node_name",
+			// Source of the limit ("pod" for pod.spec.resources.limits, "container" for the aggregated
+			// container limits or "volume" for an emptyDir sizeLimit)
+			"source",
+		}),
+	)
+
+	sample.MustRegister(podLimitPercentageVec)
+
+	podPhaseVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_pod_phase",
+		Help: "Phase of a pod reported in the stats summary, set to 1 for the current phase",
+	},
+		dev.WithClusterLabel([]string{
 			// name of pod for Ephemeral Storage
 			"pod_name",
 			// namespace of pod for Ephemeral Storage
 			"pod_namespace",
 			// Name of Node where pod is placed.
 			"node_name",
-		},
+			// Phase of the pod (Pending, Running, Succeeded, Failed or Unknown)
+			"phase",
+		}),
 	)
 
-	prometheus.MustRegister(inodesUsedGaugeVec)
+	prometheus.MustRegister(podPhaseVec)
+
+	thresholdPercentageVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_threshold_percentage",
+		Help: "Usage percentage at which a pod breaches a threshold, set through pod or namespace annotations",
+	},
+		dev.WithClusterLabel([]string{
+			// name of pod for Ephemeral Storage
+			"pod_name",
+			// namespace of pod for Ephemeral Storage
+			"pod_namespace",
+			// Name of Node where pod is placed.
+			"node_name",
+			// Level of the threshold ("warn" or "critical")
+			"level",
+		}),
+	)
+
+	sample.MustRegister(thresholdPercentageVec)
+
+	thresholdBreachVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_threshold_breach",
+		Help: "Set to 1 while the usage of a pod is at or above its threshold, 0 otherwise",
+	},
+		dev.WithClusterLabel([]string{
+			// name of pod for Ephemeral Storage
+			"pod_name",
+			// namespace of pod for Ephemeral Storage
+			"pod_namespace",
+			// Name of Node where pod is placed.
+			"node_name",
+			// Level of the threshold ("warn" or "critical")
+			"level",
+		}),
+	)
+
+	sample.MustRegister(thresholdBreachVec)
+
+	containerLogsRotationPercentageVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_container_logs_rotation_percentage",
+		Help: "Percentage of the kubelet log rotation budget (containerLogMaxSize x containerLogMaxFiles) used by the logs of a container",
+	},
+		dev.WithClusterLabel([]string{
+			// name of pod for Ephemeral Storage
+			"pod_name",
+			// namespace of pod for Ephemeral Storage
+			"pod_namespace",
+			// Name of Node where pod is placed.
+			"node_name",
+			// Name of container
+			"container",
+		}),
+	)
+
+	sample.MustRegister(containerLogsRotationPercentageVec)
+
+	containerLogsRotationOutpacedVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_container_logs_rotation_outpaced",
+		Help: "Set to 1 while the logs of a container grow faster than kubelet log rotation can keep up, 0 otherwise",
+	},
+		dev.WithClusterLabel([]string{
+			// name of pod for Ephemeral Storage
+			"pod_name",
+			// namespace of pod for Ephemeral Storage
+			"pod_namespace",
+			// Name of Node where pod is placed.
+			"node_name",
+			// Name of container
+			"container",
+		}),
+	)
+
+	sample.MustRegister(containerLogsRotationOutpacedVec)
 }
 
 func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName string, usedBytes float64, availableBytes float64, capacityBytes float64, inodes float64, inodesFree float64, inodesUsed float64, volumes []Volume, containers []ContainerStats) {
@@ -420,6 +584,11 @@ func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName str
 	podResult, okPodResult := (*cr.lookup)[podName]
 	cr.lookupMutex.RUnlock()
 
+	var summaryContainers map[string]struct{}
+	if cr.containerMetrics() {
+		summaryContainers = cr.evictStaleContainers(podName, nodeName, containers)
+	}
+
 	// TODO: something seems wrong about the metrics.
 	//		the volume capacityBytes is not reflected in this query
 	// 		kubectl get --raw "/api/v1/nodes/ephemeral-metrics-cluster-worker/proxy/stats/summary"
@@ -429,13 +598,16 @@ func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName str
 		// TODO: what a mess...need to figure out a better way.
 		if okPodResult {
 			for _, c := range podResult.containers {
+				if !c.inSummary(summaryContainers) {
+					continue
+				}
 				if c.emptyDirVolumes != nil {
 					for _, edv := range c.emptyDirVolumes {
 						for _, v := range volumes {
 							if edv.name == v.Name {
-								labels := prometheus.Labels{"pod_namespace": podNamespace,
-									"pod_name": podName, "node_name": nodeName, "container": c.name, "volume_name": v.Name,
-									"mount_path": edv.mountPath}
+								labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+									"pod_name": podName, "node_name": nodeName, "container": c.name, "container_type": c.containerType, "volume_name": v.Name,
+									"mount_path": edv.mountPath})
 								containerVolumeUsageVec.With(labels).Set(float64(v.UsedBytes))
 								log.Debug().Msg(fmt.Sprintf("pod %s/%s/%s  on %s with usedBytes: %f", podNamespace, podName, c.name, nodeName, usedBytes))
 							}
@@ -450,14 +622,17 @@ func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName str
 		// TODO: what a mess...need to figure out a better way.
 		if okPodResult {
 			for _, c := range podResult.containers {
+				if !c.inSummary(summaryContainers) {
+					continue
+				}
 				if c.emptyDirVolumes != nil {
 					for _, edv := range c.emptyDirVolumes {
 						if edv.sizeLimit != 0 {
 							for _, v := range volumes {
 								if edv.name == v.Name {
-									labels := prometheus.Labels{"pod_namespace": podNamespace,
-										"pod_name": podName, "node_name": nodeName, "container": c.name, "volume_name": v.Name,
-										"mount_path": edv.mountPath}
+									labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+										"pod_name": podName, "node_name": nodeName, "container": c.name, "container_type": c.containerType, "volume_name": v.Name,
+										"mount_path": edv.mountPath})
 									// Convert used bytes to *bibyte since. Since the volume limit in the pod manifest is in *bibyte, but the
 									// Used bytes from the Kube API is not.
 									// multiply the digital storage value by 1.024
@@ -477,8 +652,11 @@ func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName str
 	if cr.containerLimitsPercentage {
 		if okPodResult {
 			for _, c := range podResult.containers {
-				labels := prometheus.Labels{"pod_namespace": podNamespace,
-					"pod_name": podName, "node_name": nodeName, "container": c.name, "source": "node"}
+				if !c.inSummary(summaryContainers) {
+					continue
+				}
+				labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+					"pod_name": podName, "node_name": nodeName, "container": c.name, "container_type": c.containerType, "source": "node"})
 				if c.limit != 0 {
 					// Use limit if found.
 					// Convert used bytes to *bibyte since. Since the limit in the pod manifest is in *bibyte, but the
@@ -499,10 +677,36 @@ func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName str
 		}
 	}
 
+	if cr.podLimit {
+		if okPodResult {
+			cr.setPodLimitMetrics(podResult, podName, podNamespace, nodeName, usedBytes, volumes)
+		}
+	}
+
+	if cr.podPhase {
+		if okPodResult {
+			cr.setPodPhaseMetrics(podName, podNamespace, nodeName, podResult.phase)
+		}
+	}
+
+	if cr.eventThreshold > 0 && okPodResult {
+		cr.setEvents(podResult, podName, podNamespace, volumes, containers)
+	}
+
+	if remediator := remediatorOf(cr.cluster.Name); remediator != nil && okPodResult {
+		if _, percentage, source := closestLimit(podResult, usedBytes, volumes); source != "" {
+			remediator.Observe(remediate.Target{Pod: podName, Namespace: podNamespace, UID: podResult.uid, Node: nodeName, Source: source}, percentage)
+		}
+	}
+
+	if cr.thresholds {
+		cr.setThresholdMetrics(podResult, podName, podNamespace, nodeName, usedBytes, availableBytes, capacityBytes, volumes)
+	}
+
 	if cr.containerRootfsUsage {
 		for _, c := range containers {
-			labels := prometheus.Labels{"pod_namespace": podNamespace,
-				"pod_name": podName, "node_name": nodeName, "container": c.Name}
+			labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+				"pod_name": podName, "node_name": nodeName, "container": c.Name})
 			containerRootfsUsedBytesVec.With(labels).Set(float64(c.Rootfs.UsedBytes))
 			containerRootfsAvailableBytesVec.With(labels).Set(float64(c.Rootfs.AvailableBytes))
 			containerRootfsCapacityBytesVec.With(labels).Set(float64(c.Rootfs.CapacityBytes))
@@ -519,8 +723,8 @@ func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName str
 
 	if cr.containerLogsUsage {
 		for _, c := range containers {
-			labels := prometheus.Labels{"pod_namespace": podNamespace,
-				"pod_name": podName, "node_name": nodeName, "container": c.Name}
+			labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+				"pod_name": podName, "node_name": nodeName, "container": c.Name})
 			containerLogsUsedBytesVec.With(labels).Set(float64(c.Logs.UsedBytes))
 			containerLogsAvailableBytesVec.With(labels).Set(float64(c.Logs.AvailableBytes))
 			containerLogsCapacityBytesVec.With(labels).Set(float64(c.Logs.CapacityBytes))
@@ -535,16 +739,20 @@ func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName str
 		}
 	}
 
+	if cr.containerLogsRotation {
+		cr.setLogRotationMetrics(podName, podNamespace, nodeName, containers)
+	}
+
 	if cr.podUsage {
-		labels := prometheus.Labels{"pod_namespace": podNamespace,
-			"pod_name": podName, "node_name": nodeName}
+		labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+			"pod_name": podName, "node_name": nodeName})
 		podGaugeVec.With(labels).Set(usedBytes)
 		log.Debug().Msg(fmt.Sprintf("pod %s/%s on %s with usedBytes: %f", podNamespace, podName, nodeName, usedBytes))
 	}
 
 	if cr.inodes {
-		labels := prometheus.Labels{"pod_namespace": podNamespace,
-			"pod_name": podName, "node_name": nodeName}
+		labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+			"pod_name": podName, "node_name": nodeName})
 		inodesGaugeVec.With(labels).Set(inodes)
 		inodesFreeGaugeVec.With(labels).Set(inodesFree)
 		inodesUsedGaugeVec.With(labels).Set(inodesUsed)
@@ -552,31 +760,193 @@ func (cr Collector) SetMetrics(podName string, podNamespace string, nodeName str
 	}
 }
 
+// podLimitSources lists every value of the source label on the pod limit metrics.
+var podLimitSources = []string{"pod", "container", "volume"}
+
+// setPodLimitMetrics reports the eviction rule closest to firing for a pod.
+// Kubelet evicts a pod once its total usage exceeds the pod-wide limit or
+// once any disk backed emptyDir exceeds its sizeLimit, comparing raw bytes.
+func (cr Collector) setPodLimitMetrics(podResult pod, podName string, podNamespace string, nodeName string, usedBytes float64, volumes []Volume) {
+	limit, percentage, source := closestLimit(podResult, usedBytes, volumes)
+
+	// Only one source is reported per pod, so drop the series of the others.
+	for _, s := range podLimitSources {
+		if s == source {
+			continue
+		}
+		labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+			"pod_name": podName, "node_name": nodeName, "source": s})
+		podLimitBytesVec.Delete(labels)
+		podLimitPercentageVec.Delete(labels)
+	}
+
+	if source == "" {
+		return
+	}
+
+	labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+		"pod_name": podName, "node_name": nodeName, "source": source})
+	podLimitBytesVec.With(labels).Set(limit)
+	podLimitPercentageVec.With(labels).Set(math.Min(percentage, 100.0))
+	log.Debug().Msg(fmt.Sprintf("pod %s/%s on %s at %f%% of its %s limit", podNamespace, podName, nodeName, percentage, source))
+}
+
+// closestLimit returns the limit of a pod closest to triggering a kubelet
+// eviction, the pod's usage in percent of it and its source, or an empty
+// source when the pod has no limit.
+func closestLimit(podResult pod, usedBytes float64, volumes []Volume) (float64, float64, string) {
+	var limit, percentage float64
+	source := ""
+
+	if podResult.limit > 0 {
+		limit = podResult.limit
+		percentage = usedBytes / limit * 100.0
+		source = podResult.limitSource
+	}
+
+	for _, v := range volumes {
+		sizeLimit, ok := podResult.emptyDirSizeLimits[v.Name]
+		if !ok {
+			continue
+		}
+		volumePercentage := float64(v.UsedBytes) / sizeLimit * 100.0
+		if source == "" || volumePercentage > percentage {
+			limit = sizeLimit
+			percentage = volumePercentage
+			source = "volume"
+		}
+	}
+	return limit, percentage, source
+}
+
+// podPhases lists every value of the phase label on the pod phase metric.
+var podPhases = []v1.PodPhase{v1.PodPending, v1.PodRunning, v1.PodSucceeded, v1.PodFailed, v1.PodUnknown}
+
+// setPodPhaseMetrics marks the current phase of a pod and drops the series
+// of the phase it left, so usage of Succeeded or Failed pods that still hold
+// disk can be told apart from running workloads.
+func (cr Collector) setPodPhaseMetrics(podName string, podNamespace string, nodeName string, phase v1.PodPhase) {
+	if phase == "" {
+		phase = v1.PodUnknown
+	}
+	for _, p := range podPhases {
+		labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+			"pod_name": podName, "node_name": nodeName, "phase": string(p)})
+		if p == phase {
+			podPhaseVec.With(labels).Set(1)
+			continue
+		}
+		podPhaseVec.Delete(labels)
+	}
+}
+
+// containerMetrics reports whether any metric with a container label is
+// enabled, so the containers of a pod need to be tracked across scrapes.
+func (cr Collector) containerMetrics() bool {
+	return cr.containerVolumeUsage || cr.containerVolumeLimitsPercentage || cr.containerLimitsPercentage ||
+		cr.containerRootfsUsage || cr.containerLogsUsage || cr.containerLogsRotation
+}
+
+// evictStaleContainers records the containers present in the stats summary
+// for a pod and evicts the series of containers that were exported on the
+// previous scrape but are gone now. It returns the current container names.
+func (cr Collector) evictStaleContainers(podName string, nodeName string, containers []ContainerStats) map[string]struct{} {
+	current := make(map[string]struct{}, len(containers))
+	for _, c := range containers {
+		current[c.Name] = struct{}{}
+	}
+
+	previous, ok := podContainers.Swap(cr.key(podName), &containerTracker{nodeName: cr.key(nodeName), names: current})
+	if ok {
+		for name := range previous.(*containerTracker).names {
+			if _, exists := current[name]; !exists {
+				evictContainer(cr.cluster.Name, podName, name)
+			}
+		}
+	}
+	return current
+}
+
+// inSummary reports whether a container from the pod spec should be exported.
+// Regular containers always are; init and ephemeral containers only while
+// kubelet still reports them in the stats summary.
+func (c container) inSummary(summaryContainers map[string]struct{}) bool {
+	if c.containerType == "container" {
+		return true
+	}
+	_, ok := summaryContainers[c.name]
+	return ok
+}
+
+// Evicts exporter metrics of a single container in a pod
+func evictContainer(cluster string, podName string, containerName string) {
+	deleteLabel := withCluster(cluster, prometheus.Labels{"pod_name": podName, "container": containerName})
+	containerRootfsUsedBytesVec.DeletePartialMatch(deleteLabel)
+	containerRootfsAvailableBytesVec.DeletePartialMatch(deleteLabel)
+	containerRootfsCapacityBytesVec.DeletePartialMatch(deleteLabel)
+	containerLogsUsedBytesVec.DeletePartialMatch(deleteLabel)
+	containerLogsAvailableBytesVec.DeletePartialMatch(deleteLabel)
+	containerLogsCapacityBytesVec.DeletePartialMatch(deleteLabel)
+	containerRootfsUsagePercentageVec.DeletePartialMatch(deleteLabel)
+	containerLogsUsagePercentageVec.DeletePartialMatch(deleteLabel)
+	containerRootfsInodesVec.DeletePartialMatch(deleteLabel)
+	containerRootfsInodesFreeVec.DeletePartialMatch(deleteLabel)
+	containerRootfsInodesUsedVec.DeletePartialMatch(deleteLabel)
+	containerLogsInodesVec.DeletePartialMatch(deleteLabel)
+	containerLogsInodesFreeVec.DeletePartialMatch(deleteLabel)
+	containerLogsInodesUsedVec.DeletePartialMatch(deleteLabel)
+	containerLogsRotationPercentageVec.DeletePartialMatch(deleteLabel)
+	containerLogsRotationOutpacedVec.DeletePartialMatch(deleteLabel)
+	containerVolumeUsageVec.DeletePartialMatch(deleteLabel)
+	containerPercentageLimitsVec.DeletePartialMatch(deleteLabel)
+	containerPercentageVolumeLimitsVec.DeletePartialMatch(deleteLabel)
+	log.Debug().Msgf("Container %s of pod %s left the stats summary, evicted its metrics", containerName, podName)
+}
+
 // Evicts exporter metrics by pod and container name
-func evictPodByName(p v1.Pod) {
+func evictPodByName(cluster string, p v1.Pod) {
 	start := time.Now()
-	podGaugeVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	inodesGaugeVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	inodesFreeGaugeVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	inodesUsedGaugeVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerRootfsUsedBytesVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerRootfsAvailableBytesVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerRootfsCapacityBytesVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerLogsUsedBytesVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerLogsAvailableBytesVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerLogsCapacityBytesVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerRootfsUsagePercentageVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerLogsUsagePercentageVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerRootfsInodesVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerRootfsInodesFreeVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerRootfsInodesUsedVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerLogsInodesVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerLogsInodesFreeVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerLogsInodesUsedVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-
-	containerVolumeUsageVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerPercentageLimitsVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
-	containerPercentageVolumeLimitsVec.DeletePartialMatch(prometheus.Labels{"pod_name": p.Name})
+	podKey := dev.ClusterKey(cluster, p.Name)
+	deleteLabel := withCluster(cluster, prometheus.Labels{"pod_name": p.Name})
+	podContainers.Delete(podKey)
+	sample.ForgetPod(cluster, p.Namespace, p.Name)
+	podGaugeVec.DeletePartialMatch(deleteLabel)
+	inodesGaugeVec.DeletePartialMatch(deleteLabel)
+	inodesFreeGaugeVec.DeletePartialMatch(deleteLabel)
+	inodesUsedGaugeVec.DeletePartialMatch(deleteLabel)
+	containerRootfsUsedBytesVec.DeletePartialMatch(deleteLabel)
+	containerRootfsAvailableBytesVec.DeletePartialMatch(deleteLabel)
+	containerRootfsCapacityBytesVec.DeletePartialMatch(deleteLabel)
+	containerLogsUsedBytesVec.DeletePartialMatch(deleteLabel)
+	containerLogsAvailableBytesVec.DeletePartialMatch(deleteLabel)
+	containerLogsCapacityBytesVec.DeletePartialMatch(deleteLabel)
+	containerRootfsUsagePercentageVec.DeletePartialMatch(deleteLabel)
+	containerLogsUsagePercentageVec.DeletePartialMatch(deleteLabel)
+	containerRootfsInodesVec.DeletePartialMatch(deleteLabel)
+	containerRootfsInodesFreeVec.DeletePartialMatch(deleteLabel)
+	containerRootfsInodesUsedVec.DeletePartialMatch(deleteLabel)
+	containerLogsInodesVec.DeletePartialMatch(deleteLabel)
+	containerLogsInodesFreeVec.DeletePartialMatch(deleteLabel)
+	containerLogsInodesUsedVec.DeletePartialMatch(deleteLabel)
+	containerLogsRotationPercentageVec.DeletePartialMatch(deleteLabel)
+	containerLogsRotationOutpacedVec.DeletePartialMatch(deleteLabel)
+
+	containerVolumeUsageVec.DeletePartialMatch(deleteLabel)
+	containerPercentageLimitsVec.DeletePartialMatch(deleteLabel)
+	containerPercentageVolumeLimitsVec.DeletePartialMatch(deleteLabel)
+	podLimitBytesVec.DeletePartialMatch(deleteLabel)
+	podLimitPercentageVec.DeletePartialMatch(deleteLabel)
+	podPhaseVec.DeletePartialMatch(deleteLabel)
+	thresholdPercentageVec.DeletePartialMatch(deleteLabel)
+	thresholdBreachVec.DeletePartialMatch(deleteLabel)
+	if alerts != nil {
+		alerts.ClearPod(cluster, p.Name)
+	}
+	forgetEvents(podKey)
+	forgetLogSamples(podKey)
+	if remediator := remediatorOf(cluster); remediator != nil {
+		remediator.Forget(p.Namespace, p.Name)
+	}
 	duration := time.Since(start)
 	if duration > 100*time.Millisecond {
 		log.Warn().
@@ -586,10 +956,27 @@ func evictPodByName(p v1.Pod) {
 	}
 }
 
-// EvictPodByNode Evicts exporter metrics by Node
+// EvictPodByNode Evicts exporter metrics by Node, and by cluster when
+// deleteLabel carries the cluster label.
 func EvictPodByNode(deleteLabel *prometheus.Labels) {
 	if nodeName, ok := (*deleteLabel)["node_name"]; ok {
-		nodeTrackers.Delete(nodeName)
+		cluster := (*deleteLabel)["cluster"]
+		nodeKey := dev.ClusterKey(cluster, nodeName)
+		nodeTrackers.Delete(nodeKey)
+		logRotations.Delete(nodeKey)
+		if alerts != nil {
+			alerts.ClearNode(cluster, nodeName)
+		}
+		if remediator := remediatorOf(cluster); remediator != nil {
+			remediator.ForgetNode(nodeName)
+		}
+		podContainers.Range(func(key, value any) bool {
+			if value.(*containerTracker).nodeName == nodeKey {
+				podContainers.Delete(key)
+				forgetLogSamples(key.(string))
+			}
+			return true
+		})
 	}
 	podGaugeVec.DeletePartialMatch(*deleteLabel)
 	containerVolumeUsageVec.DeletePartialMatch(*deleteLabel)
@@ -609,43 +996,50 @@ func EvictPodByNode(deleteLabel *prometheus.Labels) {
 	containerLogsInodesVec.DeletePartialMatch(*deleteLabel)
 	containerLogsInodesFreeVec.DeletePartialMatch(*deleteLabel)
 	containerLogsInodesUsedVec.DeletePartialMatch(*deleteLabel)
+	containerLogsRotationPercentageVec.DeletePartialMatch(*deleteLabel)
+	containerLogsRotationOutpacedVec.DeletePartialMatch(*deleteLabel)
 	inodesGaugeVec.DeletePartialMatch(*deleteLabel)
 	inodesFreeGaugeVec.DeletePartialMatch(*deleteLabel)
 	inodesUsedGaugeVec.DeletePartialMatch(*deleteLabel)
+	podLimitBytesVec.DeletePartialMatch(*deleteLabel)
+	podLimitPercentageVec.DeletePartialMatch(*deleteLabel)
+	podPhaseVec.DeletePartialMatch(*deleteLabel)
+	thresholdPercentageVec.DeletePartialMatch(*deleteLabel)
+	thresholdBreachVec.DeletePartialMatch(*deleteLabel)
 }
 
-// EvictStalePods evicts metrics for pods on nodeName that have been absent
-// from the kubelet stats summary for scrapeMissTolerance consecutive scrapes.
+// EvictStalePods evicts metrics for pods on nodeName of cluster that have
+// been absent from the kubelet stats summary for scrapeMissTolerance
+// consecutive scrapes. cluster is empty unless several clusters are scraped.
 //
-// Each scrape passes the current set of pod names from the stats summary.
+// Each scrape passes the pods of the stats summary, by name with their
+// namespace.
 // Pods present in the summary reset their miss count to 0. Pods absent
 // increment their miss count; when it reaches scrapeMissTolerance, the pod's
 // metrics are evicted and the pod is removed from the tracker.
 //
 // Query failures (node unreachable) do not call this function — the caller
 // returns early on error, so miss counts are not incremented spuriously.
-func EvictStalePods(nodeName string, currentPods []string) {
-	t, _ := nodeTrackers.LoadOrStore(nodeName, &podTracker{lastSeen: make(map[string]int)})
+func EvictStalePods(cluster string, nodeName string, currentPods map[string]string) {
+	t, _ := nodeTrackers.LoadOrStore(dev.ClusterKey(cluster, nodeName), &podTracker{lastSeen: make(map[string]trackedPod)})
 	tracker := t.(*podTracker)
 
 	tracker.mu.Lock()
 	defer tracker.mu.Unlock()
 
-	currentSet := make(map[string]struct{}, len(currentPods))
-	for _, name := range currentPods {
-		currentSet[name] = struct{}{}
-		tracker.lastSeen[name] = 0
+	for name, namespace := range currentPods {
+		tracker.lastSeen[name] = trackedPod{namespace: namespace}
 	}
 
-	for podName, misses := range tracker.lastSeen {
-		if _, exists := currentSet[podName]; !exists {
-			misses++
-			if misses >= scrapeMissTolerance {
-				log.Info().Msgf("Scrape-driven eviction: pod %s on node %s missing %d scrapes, evicting", podName, nodeName, misses)
-				evictPodByName(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName}})
+	for podName, p := range tracker.lastSeen {
+		if _, exists := currentPods[podName]; !exists {
+			p.misses++
+			if p.misses >= scrapeMissTolerance {
+				log.Info().Msgf("Scrape-driven eviction: pod %s/%s on node %s missing %d scrapes, evicting", p.namespace, podName, nodeName, p.misses)
+				evictPodByName(cluster, v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: p.namespace}})
 				delete(tracker.lastSeen, podName)
 			} else {
-				tracker.lastSeen[podName] = misses
+				tracker.lastSeen[podName] = p
 			}
 		}
 	}
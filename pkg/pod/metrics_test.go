@@ -4,11 +4,14 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/sample"
 )
 
 func TestRootfsLogsMetrics(t *testing.T) {
@@ -156,8 +159,9 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		(*cr3.lookup)["p3"] = pod{
 			containers: []container{
 				{
-					name:  "c1",
-					limit: 2 * 1024 * 1024 * 1024, // 2Gi
+					name:          "c1",
+					containerType: "container",
+					limit:         2 * 1024 * 1024 * 1024, // 2Gi
 					emptyDirVolumes: []emptyDirVolumes{
 						{name: "vol1", mountPath: "/data", sizeLimit: 500 * 1024 * 1024},
 					},
@@ -174,13 +178,13 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		expected := strings.NewReader(`
 			# HELP ephemeral_storage_container_volume_usage Current ephemeral storage used by a container's volume in a pod
 			# TYPE ephemeral_storage_container_volume_usage gauge
-			ephemeral_storage_container_volume_usage{container="c1",mount_path="/data",node_name="n3",pod_name="p3",pod_namespace="ns3",volume_name="vol1"} 0
+			ephemeral_storage_container_volume_usage{container="c1",container_type="container",mount_path="/data",node_name="n3",pod_name="p3",pod_namespace="ns3",volume_name="vol1"} 0
 			# HELP ephemeral_storage_container_volume_limit_percentage Percentage of ephemeral storage used by a container's volume in a pod
 			# TYPE ephemeral_storage_container_volume_limit_percentage gauge
-			ephemeral_storage_container_volume_limit_percentage{container="c1",mount_path="/data",node_name="n3",pod_name="p3",pod_namespace="ns3",volume_name="vol1"} 0
+			ephemeral_storage_container_volume_limit_percentage{container="c1",container_type="container",mount_path="/data",node_name="n3",pod_name="p3",pod_namespace="ns3",volume_name="vol1"} 0
 			# HELP ephemeral_storage_container_limit_percentage Percentage of ephemeral storage used by a container in a pod
 			# TYPE ephemeral_storage_container_limit_percentage gauge
-			ephemeral_storage_container_limit_percentage{container="c1",node_name="n3",pod_name="p3",pod_namespace="ns3",source="container"} 0
+			ephemeral_storage_container_limit_percentage{container="c1",container_type="container",node_name="n3",pod_name="p3",pod_namespace="ns3",source="container"} 0
 		`)
 		if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, expected,
 			"ephemeral_storage_container_volume_usage",
@@ -191,6 +195,65 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		}
 	})
 
+	t.Run("podLimit", func(t *testing.T) {
+		crLimit := Collector{
+			podLimit:    true,
+			lookup:      &map[string]pod{},
+			lookupMutex: &sync.RWMutex{},
+		}
+		(*crLimit.lookup)["p12"] = pod{
+			limit:              1000,
+			limitSource:        "container",
+			emptyDirSizeLimits: map[string]float64{"vol1": 200},
+		}
+
+		// Pod usage is the binding rule: 250/1000 vs 40/200.
+		crLimit.SetMetrics("p12", "ns12", "n12", 250, 0, 0, 0, 0, 0, []Volume{{Name: "vol1", UsedBytes: 40}}, nil)
+		expected := strings.NewReader(`
+			# HELP ephemeral_storage_pod_limit_bytes Ephemeral storage limit closest to triggering a kubelet eviction of the pod
+			# TYPE ephemeral_storage_pod_limit_bytes gauge
+			ephemeral_storage_pod_limit_bytes{node_name="n12",pod_name="p12",pod_namespace="ns12",source="container"} 1000
+			# HELP ephemeral_storage_pod_limit_percentage Percentage of the ephemeral storage limit closest to triggering a kubelet eviction of the pod
+			# TYPE ephemeral_storage_pod_limit_percentage gauge
+			ephemeral_storage_pod_limit_percentage{node_name="n12",pod_name="p12",pod_namespace="ns12",source="container"} 25
+		`)
+		if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, expected,
+			"ephemeral_storage_pod_limit_bytes",
+			"ephemeral_storage_pod_limit_percentage",
+		); err != nil {
+			t.Fatalf("pod limit mismatch: %v", err)
+		}
+
+		// The emptyDir is now closer to its sizeLimit and replaces the container series.
+		crLimit.SetMetrics("p12", "ns12", "n12", 250, 0, 0, 0, 0, 0, []Volume{{Name: "vol1", UsedBytes: 150}}, nil)
+		expected = strings.NewReader(`
+			# HELP ephemeral_storage_pod_limit_bytes Ephemeral storage limit closest to triggering a kubelet eviction of the pod
+			# TYPE ephemeral_storage_pod_limit_bytes gauge
+			ephemeral_storage_pod_limit_bytes{node_name="n12",pod_name="p12",pod_namespace="ns12",source="volume"} 200
+			# HELP ephemeral_storage_pod_limit_percentage Percentage of the ephemeral storage limit closest to triggering a kubelet eviction of the pod
+			# TYPE ephemeral_storage_pod_limit_percentage gauge
+			ephemeral_storage_pod_limit_percentage{node_name="n12",pod_name="p12",pod_namespace="ns12",source="volume"} 75
+		`)
+		if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, expected,
+			"ephemeral_storage_pod_limit_bytes",
+			"ephemeral_storage_pod_limit_percentage",
+		); err != nil {
+			t.Fatalf("pod limit volume mismatch: %v", err)
+		}
+
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p12"}})
+		count, err := testutil.GatherAndCount(prometheus.DefaultGatherer,
+			"ephemeral_storage_pod_limit_bytes",
+			"ephemeral_storage_pod_limit_percentage",
+		)
+		if err != nil {
+			t.Fatalf("GatherAndCount failed: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("expected 0 pod limit series after eviction, got %d", count)
+		}
+	})
+
 	t.Run("evictPodByNode", func(t *testing.T) {
 		deleteLabel := prometheus.Labels{"node_name": "n2"}
 		EvictPodByNode(&deleteLabel)
@@ -214,13 +277,13 @@ func TestRootfsLogsMetrics(t *testing.T) {
 	t.Run("eviction", func(t *testing.T) {
 		// Evict p3 (which has container volume/limit metrics from containerVolume_limits)
 		// and p1 (which has rootfs/logs metrics from set_values).
-		evictPodByName(v1.Pod{
+		evictPodByName("", v1.Pod{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "p1",
 				Namespace: "ns1",
 			},
 		})
-		evictPodByName(v1.Pod{
+		evictPodByName("", v1.Pod{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "p3",
 				Namespace: "ns3",
@@ -248,6 +311,93 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		}
 	})
 
+	t.Run("initContainers_followSummary", func(t *testing.T) {
+		crInit := Collector{
+			containerLimitsPercentage: true,
+			containerRootfsUsage:      true,
+			lookup:                    &map[string]pod{},
+			lookupMutex:               &sync.RWMutex{},
+		}
+		(*crInit.lookup)["p13"] = pod{
+			containers: []container{
+				{name: "c1", containerType: "container", limit: 1000},
+				{name: "sidecar", containerType: "init", limit: 1000},
+				{name: "setup", containerType: "init", limit: 1000},
+			},
+		}
+		rootfs := FsStats{UsedBytes: 100, CapacityBytes: 1000}
+		defer evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p13"}})
+
+		// The completed "setup" init container is not in the summary.
+		crInit.SetMetrics("p13", "ns13", "n13", 500, 0, 0, 0, 0, 0, nil, []ContainerStats{
+			{Name: "c1", Rootfs: rootfs},
+			{Name: "sidecar", Rootfs: rootfs},
+		})
+		expected := strings.NewReader(`
+			# HELP ephemeral_storage_container_limit_percentage Percentage of ephemeral storage used by a container in a pod
+			# TYPE ephemeral_storage_container_limit_percentage gauge
+			ephemeral_storage_container_limit_percentage{container="c1",container_type="container",node_name="n13",pod_name="p13",pod_namespace="ns13",source="container"} 51.2
+			ephemeral_storage_container_limit_percentage{container="sidecar",container_type="init",node_name="n13",pod_name="p13",pod_namespace="ns13",source="container"} 51.2
+		`)
+		if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, expected,
+			"ephemeral_storage_container_limit_percentage",
+		); err != nil {
+			t.Fatalf("init container mismatch: %v", err)
+		}
+
+		// The sidecar left the summary, so its series are evicted.
+		crInit.SetMetrics("p13", "ns13", "n13", 500, 0, 0, 0, 0, 0, nil, []ContainerStats{
+			{Name: "c1", Rootfs: rootfs},
+		})
+		expected = strings.NewReader(`
+			# HELP ephemeral_storage_container_limit_percentage Percentage of ephemeral storage used by a container in a pod
+			# TYPE ephemeral_storage_container_limit_percentage gauge
+			ephemeral_storage_container_limit_percentage{container="c1",container_type="container",node_name="n13",pod_name="p13",pod_namespace="ns13",source="container"} 51.2
+			# HELP ephemeral_storage_container_rootfs_used_bytes Current rootfs bytes used by a container in a pod
+			# TYPE ephemeral_storage_container_rootfs_used_bytes gauge
+			ephemeral_storage_container_rootfs_used_bytes{container="c1",node_name="n13",pod_name="p13",pod_namespace="ns13"} 100
+		`)
+		if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, expected,
+			"ephemeral_storage_container_limit_percentage",
+			"ephemeral_storage_container_rootfs_used_bytes",
+		); err != nil {
+			t.Fatalf("stale container eviction mismatch: %v", err)
+		}
+
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p13"}})
+		if _, ok := podContainers.Load("p13"); ok {
+			t.Error("expected container tracker to be removed with the pod")
+		}
+	})
+
+	t.Run("podPhase", func(t *testing.T) {
+		crPhase := Collector{
+			podPhase:    true,
+			lookup:      &map[string]pod{},
+			lookupMutex: &sync.RWMutex{},
+		}
+		defer evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p14"}})
+
+		(*crPhase.lookup)["p14"] = pod{phase: v1.PodRunning}
+		crPhase.SetMetrics("p14", "ns14", "n14", 100, 0, 0, 0, 0, 0, nil, nil)
+		(*crPhase.lookup)["p14"] = pod{phase: v1.PodSucceeded}
+		crPhase.SetMetrics("p14", "ns14", "n14", 100, 0, 0, 0, 0, 0, nil, nil)
+		if _, ok := podContainers.Load("p14"); ok {
+			t.Error("expected no container tracker without container metrics")
+		}
+
+		expected := strings.NewReader(`
+			# HELP ephemeral_storage_pod_phase Phase of a pod reported in the stats summary, set to 1 for the current phase
+			# TYPE ephemeral_storage_pod_phase gauge
+			ephemeral_storage_pod_phase{node_name="n14",phase="Succeeded",pod_name="p14",pod_namespace="ns14"} 1
+		`)
+		if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, expected,
+			"ephemeral_storage_pod_phase",
+		); err != nil {
+			t.Fatalf("pod phase mismatch: %v", err)
+		}
+	})
+
 	t.Run("scrapeDrivenEviction", func(t *testing.T) {
 		// Set tolerance to 2 for this test
 		prev := scrapeMissTolerance
@@ -266,10 +416,10 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		cr4.SetMetrics("p4", "ns4", "n4", 0, 0, 0, 0, 0, 0, nil, containers)
 
 		// Scrape 1: p4 present → miss count = 0
-		EvictStalePods("n4", []string{"p4"})
+		EvictStalePods("", "n4", map[string]string{"p4": "ns4"})
 
 		// Scrape 2: p4 missing → miss count = 1 (not yet evicted)
-		EvictStalePods("n4", nil)
+		EvictStalePods("", "n4", nil)
 
 		count, err := testutil.GatherAndCount(prometheus.DefaultGatherer,
 			"ephemeral_storage_container_rootfs_used_bytes",
@@ -282,7 +432,7 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		}
 
 		// Scrape 3: p4 missing → miss count = 2 → evicted
-		EvictStalePods("n4", nil)
+		EvictStalePods("", "n4", nil)
 
 		count, err = testutil.GatherAndCount(prometheus.DefaultGatherer,
 			"ephemeral_storage_container_rootfs_used_bytes",
@@ -312,10 +462,10 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		}
 		cr5.SetMetrics("p5", "ns5", "n5", 0, 0, 0, 0, 0, 0, nil, containers)
 
-		EvictStalePods("n5", []string{"p5"}) // miss=0
-		EvictStalePods("n5", nil)            // miss=1
-		EvictStalePods("n5", []string{"p5"}) // reset to 0
-		EvictStalePods("n5", nil)            // miss=1, NOT 2
+		EvictStalePods("", "n5", map[string]string{"p5": "ns5"}) // miss=0
+		EvictStalePods("", "n5", nil)                            // miss=1
+		EvictStalePods("", "n5", map[string]string{"p5": "ns5"}) // reset to 0
+		EvictStalePods("", "n5", nil)                            // miss=1, NOT 2
 
 		count, err := testutil.GatherAndCount(prometheus.DefaultGatherer,
 			"ephemeral_storage_container_rootfs_used_bytes",
@@ -326,7 +476,7 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		if count != 1 {
 			t.Errorf("expected 1 series (miss count reset on reappearance), got %d", count)
 		}
-		evictPodByName(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p5"}})
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p5"}})
 	})
 
 	t.Run("scrapeDriven_multiplePods", func(t *testing.T) {
@@ -346,9 +496,9 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		cr.SetMetrics("p6a", "ns6", "n6", 0, 0, 0, 0, 0, 0, nil, containers)
 		cr.SetMetrics("p6b", "ns6", "n6", 0, 0, 0, 0, 0, 0, nil, containers)
 
-		EvictStalePods("n6", []string{"p6a", "p6b"}) // both miss=0
-		EvictStalePods("n6", []string{"p6a"})        // p6b miss=1
-		EvictStalePods("n6", []string{"p6a"})        // p6b miss=2 → evicted
+		EvictStalePods("", "n6", map[string]string{"p6a": "ns6", "p6b": "ns6"}) // both miss=0
+		EvictStalePods("", "n6", map[string]string{"p6a": "ns6"})               // p6b miss=1
+		EvictStalePods("", "n6", map[string]string{"p6a": "ns6"})               // p6b miss=2 → evicted
 
 		count, err := testutil.GatherAndCount(prometheus.DefaultGatherer,
 			"ephemeral_storage_container_rootfs_used_bytes",
@@ -359,7 +509,7 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		if count != 1 {
 			t.Errorf("expected 1 (p6a survives, p6b evicted), got %d", count)
 		}
-		evictPodByName(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p6a"}})
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p6a"}})
 	})
 
 	t.Run("scrapeDriven_nodeIsolation", func(t *testing.T) {
@@ -380,10 +530,10 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		cr.SetMetrics("p7", "ns7", "n7", 0, 0, 0, 0, 0, 0, nil, containers)
 		cr.SetMetrics("p8", "ns8", "n8", 0, 0, 0, 0, 0, 0, nil, containers)
 
-		EvictStalePods("n7", []string{"p7"}) // p7 tracked, miss=0
-		EvictStalePods("n7", nil)            // p7 miss=1
-		EvictStalePods("n7", nil)            // p7 miss=2 → evicted
-		EvictStalePods("n8", []string{"p8"})
+		EvictStalePods("", "n7", map[string]string{"p7": "ns7"}) // p7 tracked, miss=0
+		EvictStalePods("", "n7", nil)                            // p7 miss=1
+		EvictStalePods("", "n7", nil)                            // p7 miss=2 → evicted
+		EvictStalePods("", "n8", map[string]string{"p8": "ns8"})
 
 		count, err := testutil.GatherAndCount(prometheus.DefaultGatherer,
 			"ephemeral_storage_container_rootfs_used_bytes",
@@ -394,8 +544,8 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		if count != 1 {
 			t.Errorf("expected 1 (p8 survives on n8, p7 evicted on n7), got %d", count)
 		}
-		evictPodByName(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p7"}})
-		evictPodByName(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p8"}})
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p7"}})
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p8"}})
 	})
 
 	t.Run("scrapeDriven_evictPodByNodeClearsTracker", func(t *testing.T) {
@@ -414,7 +564,7 @@ func TestRootfsLogsMetrics(t *testing.T) {
 			{Name: "c1", Rootfs: FsStats{UsedBytes: 100, CapacityBytes: 1000}},
 		}
 		cr.SetMetrics("p9", "ns9", "n9", 0, 0, 0, 0, 0, 0, nil, containers)
-		EvictStalePods("n9", []string{"p9"})
+		EvictStalePods("", "n9", map[string]string{"p9": "ns9"})
 
 		deleteLabel := prometheus.Labels{"node_name": "n9"}
 		EvictPodByNode(&deleteLabel)
@@ -431,8 +581,8 @@ func TestRootfsLogsMetrics(t *testing.T) {
 
 		// New pod on same node gets a fresh tracker (no leftover state).
 		cr.SetMetrics("p9b", "ns9", "n9", 0, 0, 0, 0, 0, 0, nil, containers)
-		EvictStalePods("n9", []string{"p9b"})
-		EvictStalePods("n9", nil) // 1 miss, NOT evicted (tolerance=2)
+		EvictStalePods("", "n9", map[string]string{"p9b": "ns9"})
+		EvictStalePods("", "n9", nil) // 1 miss, NOT evicted (tolerance=2)
 
 		count, err = testutil.GatherAndCount(prometheus.DefaultGatherer,
 			"ephemeral_storage_container_rootfs_used_bytes",
@@ -443,7 +593,7 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		if count != 1 {
 			t.Errorf("expected 1 (p9b fresh tracker, 1 miss not evicted), got %d", count)
 		}
-		evictPodByName(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p9b"}})
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p9b"}})
 	})
 
 	t.Run("scrapeDriven_tolerance1", func(t *testing.T) {
@@ -461,8 +611,8 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		}
 		cr.SetMetrics("p10", "ns10", "n10", 0, 0, 0, 0, 0, 0, nil, containers)
 
-		EvictStalePods("n10", []string{"p10"})
-		EvictStalePods("n10", nil) // miss=1 → evicted (tolerance=1)
+		EvictStalePods("", "n10", map[string]string{"p10": "ns10"})
+		EvictStalePods("", "n10", nil) // miss=1 → evicted (tolerance=1)
 
 		count, err := testutil.GatherAndCount(prometheus.DefaultGatherer,
 			"ephemeral_storage_container_rootfs_used_bytes",
@@ -475,6 +625,48 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		}
 	})
 
+	t.Run("EvictStalePods_forgetsNamespacedSample", func(t *testing.T) {
+		prev := scrapeMissTolerance
+		scrapeMissTolerance = 1
+		defer func() { scrapeMissTolerance = prev }()
+		sample.SetTimestamps(true)
+		defer sample.SetTimestamps(false)
+
+		// A gauge eviction leaves alone, so only its timestamp tells whether
+		// the sample of the pod was forgotten.
+		sampled := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ephemeral_storage_test_sampled",
+			Help: "Stamped with the sample time of its pod",
+		}, []string{"pod_namespace", "pod_name", "node_name"})
+		sample.MustRegister(sampled)
+		sampled.WithLabelValues("ns15", "p15", "n15").Set(1)
+		timestamp := func() int64 {
+			families, err := prometheus.DefaultGatherer.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range families {
+				if f.GetName() == "ephemeral_storage_test_sampled" {
+					return f.GetMetric()[0].GetTimestampMs()
+				}
+			}
+			t.Fatal("ephemeral_storage_test_sampled not gathered")
+			return 0
+		}
+
+		at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		sample.Observe("", "n15", "ns15", "p15", at)
+		EvictStalePods("", "n15", map[string]string{"p15": "ns15"})
+		if got := timestamp(); got != at.UnixMilli() {
+			t.Fatalf("timestamp before eviction = %d, want %d", got, at.UnixMilli())
+		}
+
+		EvictStalePods("", "n15", nil) // miss=1 → evicted (tolerance=1)
+		if got := timestamp(); got != 0 {
+			t.Errorf("timestamp after eviction = %d, want the sample of p15 forgotten", got)
+		}
+	})
+
 	t.Run("evictPodByName_crossPodSafety", func(t *testing.T) {
 		// Bug 2 fix: evicting pod A must NOT delete pod B's metrics
 		// when both share the same container name. Old code used
@@ -490,7 +682,7 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		cr.SetMetrics("p11a", "ns11", "n11", 0, 0, 0, 0, 0, 0, nil, containers)
 		cr.SetMetrics("p11b", "ns11", "n11", 0, 0, 0, 0, 0, 0, nil, containers)
 
-		evictPodByName(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p11a"}})
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p11a"}})
 
 		count, err := testutil.GatherAndCount(prometheus.DefaultGatherer,
 			"ephemeral_storage_container_rootfs_used_bytes",
@@ -501,6 +693,100 @@ func TestRootfsLogsMetrics(t *testing.T) {
 		if count != 1 {
 			t.Errorf("expected 1 (p11b survives, same container name), got %d", count)
 		}
-		evictPodByName(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p11b"}})
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p11b"}})
+	})
+
+	t.Run("filteredPod_evicted", func(t *testing.T) {
+		// A pod relabelled out of the pod label selector loses its metrics
+		// right away instead of after the scrape miss tolerance.
+		filter, err := NewFilter("", "", "app=web", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cr := Collector{
+			podUsage:    true,
+			lookup:      &map[string]pod{},
+			lookupMutex: &sync.RWMutex{},
+			filter:      filter,
+		}
+		p := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p15", Namespace: "ns15", Labels: map[string]string{"app": "web"}}}
+		cr.getPodData(p)
+		cr.SetMetrics("p15", "ns15", "n15", 100, 0, 0, 0, 0, 0, nil, nil)
+		labels := prometheus.Labels{"pod_name": "p15", "pod_namespace": "ns15", "node_name": "n15"}
+
+		p.Labels["app"] = "db"
+		cr.getPodData(p)
+		if _, ok := (*cr.lookup)["p15"]; ok {
+			t.Error("filtered pod still in the lookup")
+		}
+		if podGaugeVec.Delete(labels) {
+			t.Error("filtered pod metrics not evicted")
+		}
+	})
+
+	t.Run("logRotation", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		origNow := logsNow
+		logsNow = func() time.Time { return now }
+		defer func() { logsNow = origNow }()
+
+		cr := Collector{containerLogsRotation: true, lookup: &map[string]pod{}, lookupMutex: &sync.RWMutex{}}
+		logs := func(used int) []ContainerStats {
+			return []ContainerStats{{Name: "app", Logs: FsStats{UsedBytes: used}}}
+		}
+		labels := prometheus.Labels{"pod_name": "p16", "pod_namespace": "ns16", "node_name": "n16", "container": "app"}
+
+		// Nothing is exported until the node's rotation settings are known.
+		cr.SetMetrics("p16", "ns16", "n16", 0, 0, 0, 0, 0, 0, nil, logs(100))
+		if containerLogsRotationPercentageVec.Delete(labels) {
+			t.Fatal("rotation percentage exported without rotation settings")
+		}
+
+		// 10Mi x 5 files, rotated every 10s.
+		SetLogRotation("n16", LogRotation{MaxBytes: 10 << 20, MaxFiles: 5, MonitorInterval: 10 * time.Second})
+		cr.SetMetrics("p16", "ns16", "n16", 0, 0, 0, 0, 0, 0, nil, logs(25<<20))
+		if got := testutil.ToFloat64(containerLogsRotationPercentageVec.With(labels)); got != 50 {
+			t.Errorf("rotation percentage = %v, want 50", got)
+		}
+		if got := testutil.ToFloat64(containerLogsRotationOutpacedVec.With(labels)); got != 0 {
+			t.Errorf("outpaced = %v on the first sample, want 0", got)
+		}
+
+		// 1Mi/s writes 10Mi per monitor interval, which rotation keeps up with.
+		now = now.Add(5 * time.Second)
+		cr.SetMetrics("p16", "ns16", "n16", 0, 0, 0, 0, 0, 0, nil, logs(30<<20))
+		if got := testutil.ToFloat64(containerLogsRotationOutpacedVec.With(labels)); got != 0 {
+			t.Errorf("outpaced = %v at 1Mi/s, want 0", got)
+		}
+		// 2Mi/s writes 20Mi per monitor interval, more than a file holds.
+		now = now.Add(5 * time.Second)
+		cr.SetMetrics("p16", "ns16", "n16", 0, 0, 0, 0, 0, 0, nil, logs(40<<20))
+		if got := testutil.ToFloat64(containerLogsRotationOutpacedVec.With(labels)); got != 1 {
+			t.Errorf("outpaced = %v at 2Mi/s, want 1", got)
+		}
+		// A rotation drops usage; only usage above the budget flags it.
+		now = now.Add(5 * time.Second)
+		cr.SetMetrics("p16", "ns16", "n16", 0, 0, 0, 0, 0, 0, nil, logs(35<<20))
+		if got := testutil.ToFloat64(containerLogsRotationOutpacedVec.With(labels)); got != 0 {
+			t.Errorf("outpaced = %v after rotation, want 0", got)
+		}
+		now = now.Add(5 * time.Second)
+		cr.SetMetrics("p16", "ns16", "n16", 0, 0, 0, 0, 0, 0, nil, logs(60<<20))
+		if got := testutil.ToFloat64(containerLogsRotationPercentageVec.With(labels)); got != 120 {
+			t.Errorf("rotation percentage = %v, want 120", got)
+		}
+		if got := testutil.ToFloat64(containerLogsRotationOutpacedVec.With(labels)); got != 1 {
+			t.Errorf("outpaced = %v above the budget, want 1", got)
+		}
+
+		deleteLabel := prometheus.Labels{"node_name": "n16"}
+		EvictPodByNode(&deleteLabel)
+		if _, ok := logRotations.Load("n16"); ok {
+			t.Error("rotation settings kept after the node was evicted")
+		}
+		if containerLogsRotationPercentageVec.Delete(labels) {
+			t.Error("rotation metrics kept after the node was evicted")
+		}
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p16"}})
 	})
 }
@@ -0,0 +1,204 @@
+package pod
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/alert"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+)
+
+// Annotations of pods and namespaces overriding the usage percentages at
+// which a pod is reported as breaching a threshold.
+const (
+	WarnPercentAnnotation     = "ephemeral-storage-metrics/warn-percent"
+	CriticalPercentAnnotation = "ephemeral-storage-metrics/critical-percent"
+)
+
+// thresholdLevels lists every value of the level label on the threshold metrics.
+var thresholdLevels = []string{"warn", "critical"}
+
+// thresholds holds usage percentages per level; a zero percentage is unset.
+type thresholds struct {
+	warn     float64
+	critical float64
+}
+
+func (t thresholds) get(level string) float64 {
+	if level == "critical" {
+		return t.critical
+	}
+	return t.warn
+}
+
+// or fills the levels unset in t from fallback.
+func (t thresholds) or(fallback thresholds) thresholds {
+	if t.warn == 0 {
+		t.warn = fallback.warn
+	}
+	if t.critical == 0 {
+		t.critical = fallback.critical
+	}
+	return t
+}
+
+var (
+	namespaceMutex      sync.RWMutex
+	namespaceThresholds = map[string]thresholds{} // keyed by cluster and namespace name
+
+	// alerts evaluates thresholds for the alert webhooks, nil when no webhook
+	// is configured.
+	alerts *alert.Evaluator
+)
+
+// parseThresholds reads the threshold annotations of the object kind/name.
+// Values outside (0, 100] are ignored with a warning.
+func parseThresholds(kind string, name string, annotations map[string]string) thresholds {
+	var t thresholds
+	for _, a := range []struct {
+		key   string
+		value *float64
+	}{{WarnPercentAnnotation, &t.warn}, {CriticalPercentAnnotation, &t.critical}} {
+		raw, ok := annotations[a.key]
+		if !ok {
+			continue
+		}
+		percent, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(percent) || percent <= 0 || percent > 100 {
+			log.Warn().Msgf("%s %s: ignoring annotation %s=%q, want a percentage in (0, 100]", kind, name, a.key, raw)
+			continue
+		}
+		*a.value = percent
+	}
+	return t
+}
+
+// podThresholds resolves the thresholds of a pod: its own annotations win
+// over the annotations of its namespace, which win over the defaults.
+func (cr Collector) podThresholds(podResult pod, podNamespace string) thresholds {
+	namespaceMutex.RLock()
+	t := podResult.thresholds.or(namespaceThresholds[cr.key(podNamespace)])
+	namespaceMutex.RUnlock()
+	return t.or(cr.defaultThresholds)
+}
+
+// setThresholdMetrics exports the thresholds of a pod and whether its usage
+// breaches them. Usage is the percentage of the limit closest to a kubelet
+// eviction, or the node's used ephemeral storage for pods without limits,
+// since those are evicted once the node runs out of disk. The same usage
+// feeds the alert webhooks.
+func (cr Collector) setThresholdMetrics(podResult pod, podName string, podNamespace string, nodeName string, usedBytes float64, availableBytes float64, capacityBytes float64, volumes []Volume) {
+	t := cr.podThresholds(podResult, podNamespace)
+
+	usage := math.NaN()
+	if _, percentage, source := closestLimit(podResult, usedBytes, volumes); source != "" {
+		usage = percentage
+	} else if capacityBytes > 0 {
+		usage = math.Max(capacityBytes-availableBytes, 0) * 100.0 / capacityBytes
+	}
+
+	for _, level := range thresholdLevels {
+		labels := cr.labels(prometheus.Labels{"pod_namespace": podNamespace,
+			"pod_name": podName, "node_name": nodeName, "level": level})
+		key := alert.Key{Cluster: cr.cluster.Name, Pod: podName, Namespace: podNamespace, Node: nodeName, Level: level}
+		percent := t.get(level)
+		if percent == 0 || math.IsNaN(usage) {
+			thresholdPercentageVec.Delete(labels)
+			thresholdBreachVec.Delete(labels)
+			if alerts != nil {
+				alerts.Clear(key)
+			}
+			continue
+		}
+		if alerts != nil {
+			alerts.Evaluate(key, usage, percent)
+		}
+		thresholdPercentageVec.With(labels).Set(percent)
+		breach := 0.0
+		if usage >= percent {
+			breach = 1
+		}
+		thresholdBreachVec.With(labels).Set(breach)
+	}
+}
+
+func (cr Collector) setNamespaceThresholds(ns *v1.Namespace) {
+	t := parseThresholds("namespace", ns.Name, ns.Annotations)
+	namespaceMutex.Lock()
+	defer namespaceMutex.Unlock()
+	if t == (thresholds{}) {
+		delete(namespaceThresholds, cr.key(ns.Name))
+		return
+	}
+	namespaceThresholds[cr.key(ns.Name)] = t
+}
+
+// namespaceWatch keeps the threshold annotations of namespaces up to date.
+func (cr Collector) namespaceWatch() {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	sharedInformerFactory := informers.NewSharedInformerFactory(cr.cluster.Clientset, time.Duration(cr.sampleInterval)*time.Second)
+	namespaceInformer := sharedInformerFactory.Core().V1().Namespaces().Informer()
+
+	eventHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ns, ok := obj.(*v1.Namespace)
+			if !ok {
+				log.Error().Msgf("namespaceWatch: AddFunc got unexpected type %T", obj)
+				return
+			}
+			cr.setNamespaceThresholds(ns)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			ns, ok := newObj.(*v1.Namespace)
+			if !ok {
+				log.Error().Msgf("namespaceWatch: UpdateFunc got unexpected type %T", newObj)
+				return
+			}
+			cr.setNamespaceThresholds(ns)
+		},
+		DeleteFunc: func(obj interface{}) {
+			ns, ok := obj.(*v1.Namespace)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					log.Error().Msgf("namespaceWatch: DeleteFunc got unexpected type %T", obj)
+					return
+				}
+				ns, ok = tombstone.Obj.(*v1.Namespace)
+				if !ok {
+					log.Error().Msgf("namespaceWatch: tombstone held non-Namespace %T", tombstone.Obj)
+					return
+				}
+			}
+			namespaceMutex.Lock()
+			delete(namespaceThresholds, cr.key(ns.Name))
+			namespaceMutex.Unlock()
+		},
+	}
+
+	_, err := namespaceInformer.AddEventHandler(eventHandler)
+	if err != nil {
+		dev.WatchStopped(cr.cluster.Name, "namespaceWatch", err)
+		return
+	}
+
+	go sharedInformerFactory.Start(stopCh)
+
+	for {
+		time.Sleep(time.Duration(cr.sampleInterval) * time.Second)
+		select {
+		case <-stopCh:
+			dev.WatchStopped(cr.cluster.Name, "namespaceWatch", nil)
+			return
+		}
+	}
+}
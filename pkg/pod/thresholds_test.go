@@ -0,0 +1,124 @@
+package pod
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseThresholds(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		want        thresholds
+	}{
+		{nil, thresholds{}},
+		{map[string]string{WarnPercentAnnotation: "80", CriticalPercentAnnotation: "95.5"}, thresholds{warn: 80, critical: 95.5}},
+		{map[string]string{WarnPercentAnnotation: "0"}, thresholds{}},
+		{map[string]string{WarnPercentAnnotation: "101", CriticalPercentAnnotation: "90"}, thresholds{critical: 90}},
+		{map[string]string{WarnPercentAnnotation: "high", CriticalPercentAnnotation: "NaN"}, thresholds{}},
+	}
+	for _, tt := range tests {
+		if got := parseThresholds("pod", "ns/p", tt.annotations); got != tt.want {
+			t.Errorf("parseThresholds(%v) = %+v, want %+v", tt.annotations, got, tt.want)
+		}
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	cr := Collector{
+		thresholds:        true,
+		defaultThresholds: thresholds{warn: 70, critical: 90},
+		lookup:            &map[string]pod{},
+		lookupMutex:       &sync.RWMutex{},
+	}
+	defer func() {
+		namespaceMutex.Lock()
+		namespaceThresholds = map[string]thresholds{}
+		namespaceMutex.Unlock()
+	}()
+	cr.setNamespaceThresholds(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "build", Annotations: map[string]string{
+		WarnPercentAnnotation: "90", CriticalPercentAnnotation: "98",
+	}}})
+
+	limit := v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("1000")}}
+	cr.getPodData(v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "build"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "c", Resources: limit}}},
+	})
+	cr.getPodData(v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "build", Annotations: map[string]string{CriticalPercentAnnotation: "50"}},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "c", Resources: limit}}},
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		for _, tt := range []struct {
+			pod, namespace string
+			want           thresholds
+		}{
+			{"cache", "build", thresholds{warn: 90, critical: 98}},
+			{"db", "build", thresholds{warn: 90, critical: 50}},
+			{"other", "default", thresholds{warn: 70, critical: 90}},
+		} {
+			cr.lookupMutex.RLock()
+			p := (*cr.lookup)[tt.pod]
+			cr.lookupMutex.RUnlock()
+			if got := cr.podThresholds(p, tt.namespace); got != tt.want {
+				t.Errorf("thresholds of %s/%s = %+v, want %+v", tt.namespace, tt.pod, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		// 600 of a 1000 byte limit: the same usage breaches db's critical
+		// threshold but not cache's.
+		cr.SetMetrics("cache", "build", "n16", 600, 0, 0, 0, 0, 0, nil, nil)
+		cr.SetMetrics("db", "build", "n16", 600, 0, 0, 0, 0, 0, nil, nil)
+		// Without a limit the node usage counts: 80% of the node is used.
+		cr.SetMetrics("other", "default", "n16", 10, 200, 1000, 0, 0, 0, nil, nil)
+
+		expected := strings.NewReader(`
+			# HELP ephemeral_storage_threshold_breach Set to 1 while the usage of a pod is at or above its threshold, 0 otherwise
+			# TYPE ephemeral_storage_threshold_breach gauge
+			ephemeral_storage_threshold_breach{level="critical",node_name="n16",pod_name="cache",pod_namespace="build"} 0
+			ephemeral_storage_threshold_breach{level="critical",node_name="n16",pod_name="db",pod_namespace="build"} 1
+			ephemeral_storage_threshold_breach{level="critical",node_name="n16",pod_name="other",pod_namespace="default"} 0
+			ephemeral_storage_threshold_breach{level="warn",node_name="n16",pod_name="cache",pod_namespace="build"} 0
+			ephemeral_storage_threshold_breach{level="warn",node_name="n16",pod_name="db",pod_namespace="build"} 0
+			ephemeral_storage_threshold_breach{level="warn",node_name="n16",pod_name="other",pod_namespace="default"} 1
+			# HELP ephemeral_storage_threshold_percentage Usage percentage at which a pod breaches a threshold, set through pod or namespace annotations
+			# TYPE ephemeral_storage_threshold_percentage gauge
+			ephemeral_storage_threshold_percentage{level="critical",node_name="n16",pod_name="cache",pod_namespace="build"} 98
+			ephemeral_storage_threshold_percentage{level="critical",node_name="n16",pod_name="db",pod_namespace="build"} 50
+			ephemeral_storage_threshold_percentage{level="critical",node_name="n16",pod_name="other",pod_namespace="default"} 90
+			ephemeral_storage_threshold_percentage{level="warn",node_name="n16",pod_name="cache",pod_namespace="build"} 90
+			ephemeral_storage_threshold_percentage{level="warn",node_name="n16",pod_name="db",pod_namespace="build"} 90
+			ephemeral_storage_threshold_percentage{level="warn",node_name="n16",pod_name="other",pod_namespace="default"} 70
+		`)
+		if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, expected,
+			"ephemeral_storage_threshold_breach", "ephemeral_storage_threshold_percentage"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("unset_level_dropped", func(t *testing.T) {
+		cr.defaultThresholds = thresholds{warn: 70}
+		cr.SetMetrics("other", "default", "n16", 10, 200, 1000, 0, 0, 0, nil, nil)
+		labels := prometheus.Labels{"pod_name": "other", "pod_namespace": "default", "node_name": "n16", "level": "critical"}
+		if thresholdPercentageVec.Delete(labels) || thresholdBreachVec.Delete(labels) {
+			t.Error("series of an unset level not dropped")
+		}
+	})
+
+	for _, name := range []string{"cache", "db", "other"} {
+		evictPodByName("", v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	if n := testutil.CollectAndCount(thresholdBreachVec); n != 0 {
+		t.Errorf("%d threshold series left after eviction", n)
+	}
+}
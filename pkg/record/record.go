@@ -0,0 +1,279 @@
+package record
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+)
+
+// timeFormat sorts lexically in capture order, so a recording replays
+// chronologically.
+const timeFormat = "20060102T150405.000000000Z"
+
+// defaultLast is the number of captures the download endpoint returns when
+// the request does not ask for a count.
+const defaultLast = 10
+
+type capture struct {
+	node string
+	path string
+	size int64
+	time time.Time
+}
+
+// Recorder persists raw stats summaries to <dir>/<node>/<timestamp>.json,
+// optionally gzipped, and drops the oldest captures once the recording grows
+// past maxBytes or a capture gets older than maxAge. The layout is the one
+// replay mode reads.
+type Recorder struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	gzip     bool
+	now      func() time.Time
+
+	// Download serves the captures on /captures when set through
+	// RECORD_DOWNLOAD. They hold pod names, namespaces and volumes.
+	Download bool
+
+	mu       sync.Mutex
+	captures []capture // oldest first
+	total    int64
+}
+
+// NewRecorder returns the Recorder configured through RECORD_PATH,
+// RECORD_MAX_BYTES, RECORD_MAX_AGE (0 keeps captures regardless of age) and
+// RECORD_GZIP, or nil when recording is disabled. RECORD_DOWNLOAD enables the
+// download endpoint.
+func NewRecorder() (*Recorder, error) {
+	dir := dev.GetEnv("RECORD_PATH", "")
+	if dir == "" {
+		return nil, nil
+	}
+	maxBytes, err := strconv.ParseInt(dev.GetEnv("RECORD_MAX_BYTES", "104857600"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("RECORD_MAX_BYTES: %w", err)
+	}
+	maxAge, err := time.ParseDuration(dev.GetEnv("RECORD_MAX_AGE", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("RECORD_MAX_AGE: %w", err)
+	}
+	gz, _ := strconv.ParseBool(dev.GetEnv("RECORD_GZIP", "true"))
+	download, _ := strconv.ParseBool(dev.GetEnv("RECORD_DOWNLOAD", "false"))
+
+	r := &Recorder{dir: dir, maxBytes: maxBytes, maxAge: maxAge, gzip: gz, now: time.Now, Download: download}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.rotate(r.now().UTC())
+	return r, nil
+}
+
+// load indexes captures left by a previous run, so rotation keeps covering
+// them after a restart.
+func (r *Recorder) load() error {
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+	err := filepath.WalkDir(r.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(d.Name(), ".gz"), ".json")
+		t, err := time.Parse(timeFormat, name)
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		r.captures = append(r.captures, capture{node: filepath.Base(filepath.Dir(path)), path: path, size: info.Size(), time: t})
+		r.total += info.Size()
+		return nil
+	})
+	sort.SliceStable(r.captures, func(i, j int) bool { return r.captures[i].time.Before(r.captures[j].time) })
+	return err
+}
+
+// Record writes content as the latest capture of node and rotates the
+// recording.
+func (r *Recorder) Record(node string, content []byte) error {
+	if !validNode(node) {
+		return fmt.Errorf("invalid node name %q", node)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now().UTC()
+	dir := filepath.Join(r.dir, node)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := now.Format(timeFormat) + ".json"
+	if r.gzip {
+		name += ".gz"
+	}
+	path := filepath.Join(dir, name)
+	size, err := writeFile(path, content, r.gzip)
+	if err != nil {
+		return err
+	}
+
+	r.captures = append(r.captures, capture{node: node, path: path, size: size, time: now})
+	r.total += size
+	r.rotate(now)
+	return nil
+}
+
+// writeFile writes through a temporary file so a crash never leaves a
+// truncated capture behind.
+func writeFile(path string, content []byte, gz bool) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".capture-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	if _, err := w.Write(content); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(f.Name(), path)
+}
+
+func (r *Recorder) rotate(now time.Time) {
+	for len(r.captures) > 0 {
+		oldest := r.captures[0]
+		if r.total <= r.maxBytes && (r.maxAge <= 0 || now.Sub(oldest.time) <= r.maxAge) {
+			return
+		}
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Msgf("Failed to remove capture %s", oldest.path)
+		}
+		r.captures = r.captures[1:]
+		r.total -= oldest.size
+	}
+}
+
+// last returns up to n of the most recent captures of node, oldest first.
+func (r *Recorder) last(node string, n int) []capture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []capture
+	for i := len(r.captures) - 1; i >= 0 && len(out) < n; i-- {
+		if r.captures[i].node == node {
+			out = append(out, r.captures[i])
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// WriteArchive writes the last n captures of node as a .tar.gz that replay
+// mode can read directly.
+func (r *Recorder) WriteArchive(w io.Writer, node string, n int) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, c := range r.last(node, n) {
+		content, err := os.ReadFile(c.path)
+		if os.IsNotExist(err) {
+			// Rotated since the index was read.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:     node + "/" + filepath.Base(c.path),
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			ModTime:  c.time,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ServeHTTP serves GET ?node=<node>&last=<n> with the last n captures of
+// node as a .tar.gz.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	node := req.URL.Query().Get("node")
+	if !validNode(node) {
+		http.Error(w, "missing or invalid node parameter", http.StatusBadRequest)
+		return
+	}
+	n := defaultLast
+	if s := req.URL.Query().Get("last"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 {
+			http.Error(w, "last must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		n = v
+	}
+	if len(r.last(node, 1)) == 0 {
+		http.Error(w, fmt.Sprintf("no captures for node %s", node), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", node+"-captures.tar.gz"))
+	if err := r.WriteArchive(w, node, n); err != nil {
+		log.Warn().Err(err).Msgf("Failed to write captures of node %s", node)
+	}
+}
+
+// validNode rejects names that would escape the recording directory.
+func validNode(node string) bool {
+	return node != "" && node != "." && node != ".." && !strings.ContainsAny(node, `/\`)
+}
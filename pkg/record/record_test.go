@@ -0,0 +1,227 @@
+package record
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock advances by one second on every call.
+func fakeClock() func() time.Time {
+	t := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		t = t.Add(time.Second)
+		return t
+	}
+}
+
+func newTestRecorder(t *testing.T, maxBytes int64, maxAge time.Duration, gz bool) *Recorder {
+	t.Helper()
+	return &Recorder{dir: t.TempDir(), maxBytes: maxBytes, maxAge: maxAge, gzip: gz, now: fakeClock()}
+}
+
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+	var out []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		out = append(out, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestRecord(t *testing.T) {
+	r := newTestRecorder(t, 1<<20, time.Hour, false)
+	if err := r.Record("node-1", []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := r.Record("node-2", []byte(`{"b":2}`)); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	want := []string{
+		"node-1/20240101T000001.000000000Z.json",
+		"node-2/20240101T000002.000000000Z.json",
+	}
+	if got := files(t, r.dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	content, _ := os.ReadFile(filepath.Join(r.dir, want[0]))
+	if string(content) != `{"a":1}` {
+		t.Errorf("content = %q", content)
+	}
+
+	if err := r.Record("../escape", []byte(`{}`)); err == nil {
+		t.Error("expected error for node name with a path separator")
+	}
+}
+
+func TestRecordGzip(t *testing.T) {
+	r := newTestRecorder(t, 1<<20, time.Hour, true)
+	if err := r.Record("node-1", []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	f, err := os.Open(filepath.Join(r.dir, "node-1", "20240101T000001.000000000Z.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(zr)
+	if string(content) != `{"a":1}` {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	// Every capture is 10 bytes; keep at most 25 bytes.
+	r := newTestRecorder(t, 25, 0, false)
+	for i := 0; i < 4; i++ {
+		if err := r.Record("node-1", []byte("0123456789")); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	want := []string{
+		"node-1/20240101T000003.000000000Z.json",
+		"node-1/20240101T000004.000000000Z.json",
+	}
+	if got := files(t, r.dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if r.total != 20 {
+		t.Errorf("total = %d, want 20", r.total)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	r := newTestRecorder(t, 1<<20, 2*time.Second, false)
+	for i := 0; i < 4; i++ {
+		if err := r.Record("node-1", []byte(`{}`)); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	want := []string{
+		"node-1/20240101T000002.000000000Z.json",
+		"node-1/20240101T000003.000000000Z.json",
+		"node-1/20240101T000004.000000000Z.json",
+	}
+	if got := files(t, r.dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestLoadExistingCaptures(t *testing.T) {
+	r := newTestRecorder(t, 1<<20, time.Hour, false)
+	for i := 0; i < 3; i++ {
+		if err := r.Record("node-1", []byte("0123456789")); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	t.Setenv("RECORD_PATH", r.dir)
+	t.Setenv("RECORD_MAX_BYTES", "20")
+	t.Setenv("RECORD_MAX_AGE", "0")
+	t.Setenv("RECORD_GZIP", "false")
+	restarted, err := NewRecorder()
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	if restarted.Download {
+		t.Error("download endpoint enabled without RECORD_DOWNLOAD")
+	}
+	if len(restarted.captures) != 2 || restarted.total != 20 {
+		t.Errorf("captures = %+v, total = %d", restarted.captures, restarted.total)
+	}
+	if got := files(t, r.dir); len(got) != 2 {
+		t.Errorf("files = %v, want the 2 newest", got)
+	}
+}
+
+func TestNewRecorderDisabled(t *testing.T) {
+	t.Setenv("RECORD_PATH", "")
+	r, err := NewRecorder()
+	if err != nil || r != nil {
+		t.Errorf("NewRecorder() = %v, %v; want nil, nil", r, err)
+	}
+
+	t.Setenv("RECORD_PATH", t.TempDir())
+	t.Setenv("RECORD_MAX_AGE", "soon")
+	if _, err := NewRecorder(); err == nil {
+		t.Error("expected error for invalid RECORD_MAX_AGE")
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := newTestRecorder(t, 1<<20, time.Hour, true)
+	for i := 0; i < 3; i++ {
+		if err := r.Record("node-1", []byte(`{"node":{"nodeName":"node-1"}}`)); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	if err := r.Record("node-2", []byte(`{}`)); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/captures?node=node-1&last=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	want := []string{
+		"node-1/20240101T000002.000000000Z.json.gz",
+		"node-1/20240101T000003.000000000Z.json.gz",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("archive = %v, want %v", names, want)
+	}
+
+	for _, tt := range []struct {
+		url  string
+		code int
+	}{
+		{"/captures", http.StatusBadRequest},
+		{"/captures?node=..", http.StatusBadRequest},
+		{"/captures?node=node-1&last=0", http.StatusBadRequest},
+		{"/captures?node=node-3", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.url, rec.Code, tt.code)
+		}
+	}
+}
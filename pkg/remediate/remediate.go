@@ -0,0 +1,298 @@
+package remediate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+)
+
+// Policies of the controller.
+const (
+	PolicyAnnotate = "annotate"
+	PolicyCordon   = "cordon"
+	PolicyEvict    = "evict"
+)
+
+// Annotation is set on pods by the annotate policy, with the usage that
+// triggered it.
+const Annotation = "ephemeral-storage-metrics/pre-eviction"
+
+// Outcomes of a decision, recorded in the audit log.
+const (
+	outcomeApplied = "applied"
+	outcomeDryRun  = "dry-run"
+	outcomeBlocked = "blocked" // the Eviction API refused, e.g. a PodDisruptionBudget
+	outcomeFailed  = "failed"
+)
+
+// Target is a pod whose usage is observed, in percent of the limit closest
+// to a kubelet eviction.
+type Target struct {
+	Pod       string
+	Namespace string
+	UID       string
+	Node      string
+	Source    string // the limit the usage is relative to: "pod", "container" or "volume"
+}
+
+// state tracks a pod above the threshold.
+type state struct {
+	pending     bool // queued or being acted on
+	done        bool
+	lastAttempt time.Time
+}
+
+// decision is an action queued for Run.
+type decision struct {
+	target    Target
+	usage     float64
+	threshold float64
+}
+
+// Controller acts on pods whose usage passes a threshold below their
+// eviction limit, before kubelet evicts them without a grace period. It
+// annotates the pod, cordons its node so no more pods land there, or evicts
+// it through the Eviction API, which honours PodDisruptionBudgets. A pod is
+// acted on once while it stays above the threshold; failed or blocked
+// actions are retried after the retry interval. In dry-run mode requests are
+// sent with dryRun=All, so the apiserver validates them without persisting
+// anything. Every decision is written to the audit log.
+type Controller struct {
+	client        kubernetes.Interface
+	policy        string
+	threshold     float64
+	dryRun        bool
+	retryInterval time.Duration
+	timeout       time.Duration
+	audit         zerolog.Logger
+	now           func() time.Time
+
+	mu       sync.Mutex
+	pods     map[string]*state // keyed by namespace/name
+	cordoned map[string]bool   // nodes cordoned or being cordoned
+
+	queue chan decision
+}
+
+// NewController returns the Controller configured through REMEDIATION_POLICY
+// (annotate, cordon or evict), REMEDIATION_THRESHOLD_PERCENT,
+// REMEDIATION_DRY_RUN and REMEDIATION_RETRY_INTERVAL, or nil when no policy
+// is set.
+func NewController(client kubernetes.Interface) (*Controller, error) {
+	policy := dev.GetEnv("REMEDIATION_POLICY", "")
+	switch policy {
+	case "":
+		return nil, nil
+	case PolicyAnnotate, PolicyCordon, PolicyEvict:
+	default:
+		return nil, fmt.Errorf("REMEDIATION_POLICY: want %s, %s or %s, got %q", PolicyAnnotate, PolicyCordon, PolicyEvict, policy)
+	}
+	threshold, err := strconv.ParseFloat(dev.GetEnv("REMEDIATION_THRESHOLD_PERCENT", "90"), 64)
+	if err != nil || threshold <= 0 || threshold > 100 {
+		return nil, fmt.Errorf("REMEDIATION_THRESHOLD_PERCENT: want a percentage in (0, 100], got %q", dev.GetEnv("REMEDIATION_THRESHOLD_PERCENT", "90"))
+	}
+	dryRun, err := strconv.ParseBool(dev.GetEnv("REMEDIATION_DRY_RUN", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("REMEDIATION_DRY_RUN: %w", err)
+	}
+	retryInterval, err := time.ParseDuration(dev.GetEnv("REMEDIATION_RETRY_INTERVAL", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("REMEDIATION_RETRY_INTERVAL: %w", err)
+	}
+	audit := log.Logger.With().Str("component", "remediation").Logger()
+	return newController(client, policy, threshold, dryRun, retryInterval, audit), nil
+}
+
+func newController(client kubernetes.Interface, policy string, threshold float64, dryRun bool, retryInterval time.Duration, audit zerolog.Logger) *Controller {
+	return &Controller{
+		client:        client,
+		policy:        policy,
+		threshold:     threshold,
+		dryRun:        dryRun,
+		retryInterval: retryInterval,
+		timeout:       30 * time.Second,
+		audit:         audit,
+		now:           time.Now,
+		pods:          map[string]*state{},
+		cordoned:      map[string]bool{},
+		queue:         make(chan decision, 1024),
+	}
+}
+
+// Observe records the usage of target and queues the policy's action when
+// it passes the threshold.
+func (c *Controller) Observe(target Target, usage float64) {
+	key := target.Namespace + "/" + target.Pod
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.pods[key]
+	if usage < c.threshold {
+		if ok && !s.pending {
+			// Re-arm once the pod is back below the threshold.
+			delete(c.pods, key)
+		}
+		return
+	}
+	if !ok {
+		s = &state{}
+		c.pods[key] = s
+	}
+	if s.pending || s.done || (!s.lastAttempt.IsZero() && c.now().Sub(s.lastAttempt) < c.retryInterval) {
+		return
+	}
+	if c.policy == PolicyCordon && c.cordoned[target.Node] {
+		s.done = true
+		return
+	}
+
+	select {
+	case c.queue <- decision{target: target, usage: usage, threshold: c.threshold}:
+		s.pending = true
+		if c.policy == PolicyCordon {
+			c.cordoned[target.Node] = true
+		}
+	default:
+		log.Warn().Msgf("Remediation queue full, dropping decision for pod %s", key)
+	}
+}
+
+// Forget drops the state of a deleted pod.
+func (c *Controller) Forget(namespace string, podName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pods, namespace+"/"+podName)
+}
+
+// ForgetNode drops the state of a removed node.
+func (c *Controller) ForgetNode(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cordoned, node)
+}
+
+// Run acts on queued decisions until stop is closed.
+func (c *Controller) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case d := <-c.queue:
+			c.act(d)
+		}
+	}
+}
+
+// act applies the policy to a decision, writes it to the audit log and
+// records the outcome.
+func (c *Controller) act(d decision) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var err error
+	switch c.policy {
+	case PolicyAnnotate:
+		err = c.annotate(ctx, d)
+	case PolicyCordon:
+		err = c.cordon(ctx, d.target.Node)
+	case PolicyEvict:
+		err = c.evict(ctx, d.target)
+	}
+
+	outcome := outcomeApplied
+	switch {
+	case err != nil && apierrors.IsTooManyRequests(err):
+		outcome = outcomeBlocked
+	case err != nil:
+		outcome = outcomeFailed
+	case c.dryRun:
+		outcome = outcomeDryRun
+	}
+
+	var event *zerolog.Event
+	if err != nil {
+		event = c.audit.Warn().Err(err)
+	} else {
+		event = c.audit.Info()
+	}
+	event.
+		Str("policy", c.policy).
+		Str("outcome", outcome).
+		Str("pod", d.target.Pod).
+		Str("namespace", d.target.Namespace).
+		Str("node", d.target.Node).
+		Str("limit", d.target.Source).
+		Float64("usage_percent", d.usage).
+		Float64("threshold_percent", d.threshold).
+		Bool("dry_run", c.dryRun).
+		Msg("Remediation decision")
+
+	key := d.target.Namespace + "/" + d.target.Pod
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil && c.policy == PolicyCordon {
+		delete(c.cordoned, d.target.Node)
+	}
+	s, ok := c.pods[key]
+	if !ok {
+		return
+	}
+	s.pending = false
+	if err != nil {
+		s.lastAttempt = c.now()
+		return
+	}
+	s.done = true
+}
+
+func (c *Controller) dryRunOption() []string {
+	if c.dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+func (c *Controller) annotate(ctx context.Context, d decision) error {
+	value := fmt.Sprintf("%.1f%% of %s limit at %s", d.usage, d.target.Source, c.now().UTC().Format(time.RFC3339))
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{Annotation: value}}})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.CoreV1().Pods(d.target.Namespace).Patch(ctx, d.target.Pod, types.MergePatchType, patch,
+		metav1.PatchOptions{DryRun: c.dryRunOption()})
+	return err
+}
+
+func (c *Controller) cordon(ctx context.Context, node string) error {
+	_, err := c.client.CoreV1().Nodes().Patch(ctx, node, types.StrategicMergePatchType, []byte(`{"spec":{"unschedulable":true}}`),
+		metav1.PatchOptions{DryRun: c.dryRunOption()})
+	return err
+}
+
+func (c *Controller) evict(ctx context.Context, target Target) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: target.Pod, Namespace: target.Namespace},
+		DeleteOptions: &metav1.DeleteOptions{
+			DryRun: c.dryRunOption(),
+		},
+	}
+	if target.UID != "" {
+		// Only evict the pod that was observed, not a replacement with the same name.
+		uid := types.UID(target.UID)
+		eviction.DeleteOptions.Preconditions = &metav1.Preconditions{UID: &uid}
+	}
+	return c.client.CoreV1().Pods(target.Namespace).EvictV1(ctx, eviction)
+}
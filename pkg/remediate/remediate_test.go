@@ -0,0 +1,203 @@
+package remediate
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// drain acts on every queued decision.
+func drain(c *Controller) {
+	for {
+		select {
+		case d := <-c.queue:
+			c.act(d)
+		default:
+			return
+		}
+	}
+}
+
+// auditEntries decodes the audit log written to buf.
+func auditEntries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	buf.Reset()
+	return entries
+}
+
+func newFakeClient() *fake.Clientset {
+	return fake.NewClientset(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-1"}, Spec: v1.PodSpec{NodeName: "node-1"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "uid-2"}, Spec: v1.PodSpec{NodeName: "node-1"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+	)
+}
+
+var (
+	web = Target{Pod: "web", Namespace: "default", UID: "uid-1", Node: "node-1", Source: "container"}
+	db  = Target{Pod: "db", Namespace: "default", UID: "uid-2", Node: "node-1", Source: "pod"}
+)
+
+func TestControllerAnnotate(t *testing.T) {
+	client := newFakeClient()
+	var buf bytes.Buffer
+	c := newController(client, PolicyAnnotate, 90, false, time.Minute, zerolog.New(&buf))
+
+	c.Observe(web, 80)
+	drain(c)
+	if entries := auditEntries(t, &buf); len(entries) != 0 {
+		t.Fatalf("decisions below the threshold: %v", entries)
+	}
+
+	c.Observe(web, 93)
+	c.Observe(web, 95)
+	drain(c)
+	entries := auditEntries(t, &buf)
+	if len(entries) != 1 || entries[0]["outcome"] != outcomeApplied || entries[0]["pod"] != "web" || entries[0]["usage_percent"] != 93.0 {
+		t.Fatalf("audit = %v", entries)
+	}
+	p, err := client.CoreV1().Pods("default").Get(t.Context(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(p.Annotations[Annotation], "93.0% of container limit") {
+		t.Errorf("annotation = %q", p.Annotations[Annotation])
+	}
+
+	// Acted on once while above, again after dropping below.
+	c.Observe(web, 97)
+	drain(c)
+	c.Observe(web, 50)
+	c.Observe(web, 91)
+	drain(c)
+	if entries := auditEntries(t, &buf); len(entries) != 1 {
+		t.Errorf("%d decisions after re-arming, want 1", len(entries))
+	}
+}
+
+func TestControllerCordon(t *testing.T) {
+	client := newFakeClient()
+	var buf bytes.Buffer
+	c := newController(client, PolicyCordon, 90, false, time.Minute, zerolog.New(&buf))
+
+	c.Observe(web, 95)
+	c.Observe(db, 95)
+	drain(c)
+	if entries := auditEntries(t, &buf); len(entries) != 1 || entries[0]["node"] != "node-1" {
+		t.Fatalf("audit = %v, want a single cordon of node-1", entries)
+	}
+	n, err := client.CoreV1().Nodes().Get(t.Context(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Spec.Unschedulable {
+		t.Error("node-1 not cordoned")
+	}
+}
+
+func TestControllerEvict(t *testing.T) {
+	client := newFakeClient()
+	// The first eviction is refused like a PodDisruptionBudget does.
+	refusals := 1
+	var evictions []*policyv1.Eviction
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		evictions = append(evictions, eviction)
+		if refusals > 0 {
+			refusals--
+			return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		return true, nil, nil
+	})
+
+	var buf bytes.Buffer
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newController(client, PolicyEvict, 90, false, time.Minute, zerolog.New(&buf))
+	c.now = func() time.Time { return now }
+
+	c.Observe(web, 95)
+	drain(c)
+	entries := auditEntries(t, &buf)
+	if len(entries) != 1 || entries[0]["outcome"] != outcomeBlocked {
+		t.Fatalf("audit = %v", entries)
+	}
+
+	// Blocked evictions are retried after the retry interval.
+	now = now.Add(30 * time.Second)
+	c.Observe(web, 95)
+	drain(c)
+	if entries := auditEntries(t, &buf); len(entries) != 0 {
+		t.Fatalf("retried within the interval: %v", entries)
+	}
+	now = now.Add(30 * time.Second)
+	c.Observe(web, 95)
+	drain(c)
+	entries = auditEntries(t, &buf)
+	if len(entries) != 1 || entries[0]["outcome"] != outcomeApplied {
+		t.Fatalf("audit = %v", entries)
+	}
+	if len(evictions) != 2 {
+		t.Fatalf("%d eviction requests, want 2", len(evictions))
+	}
+	if uid := evictions[1].DeleteOptions.Preconditions.UID; uid == nil || *uid != "uid-1" {
+		t.Errorf("eviction precondition = %v", uid)
+	}
+}
+
+func TestControllerDryRun(t *testing.T) {
+	for _, policy := range []string{PolicyAnnotate, PolicyCordon, PolicyEvict} {
+		t.Run(policy, func(t *testing.T) {
+			client := newFakeClient()
+			var dryRun []string
+			client.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				switch a := action.(type) {
+				case k8stesting.PatchAction:
+					dryRun = a.(k8stesting.PatchActionImpl).PatchOptions.DryRun
+				case k8stesting.CreateAction:
+					dryRun = a.GetObject().(*policyv1.Eviction).DeleteOptions.DryRun
+				default:
+					return false, nil, nil
+				}
+				// The apiserver validates dry-run requests without persisting them.
+				return true, nil, nil
+			})
+			var buf bytes.Buffer
+			c := newController(client, policy, 90, true, time.Minute, zerolog.New(&buf))
+
+			c.Observe(web, 95)
+			drain(c)
+			entries := auditEntries(t, &buf)
+			if len(entries) != 1 || entries[0]["outcome"] != outcomeDryRun || entries[0]["dry_run"] != true {
+				t.Fatalf("audit = %v", entries)
+			}
+			if len(dryRun) != 1 || dryRun[0] != metav1.DryRunAll {
+				t.Errorf("dryRun = %v, want [All]", dryRun)
+			}
+		})
+	}
+}
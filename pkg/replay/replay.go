@@ -0,0 +1,201 @@
+package replay
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Capture is a recorded kubelet stats summary of a node.
+type Capture struct {
+	Path    string
+	Node    string
+	Content []byte
+}
+
+// Recording holds the stats summaries and pod manifests to replay. Captures
+// are ordered by path, so timestamped file names replay chronologically.
+type Recording struct {
+	Captures []Capture
+	Pods     []v1.Pod
+}
+
+// Load reads a recording from a directory or a .tar, .tar.gz or .tgz
+// archive. JSON files without a "kind" field are stats summaries, YAML files
+// and JSON files with a "kind" are pod manifests (Pod, PodList or List).
+// Any file may additionally be gzipped.
+func Load(path string) (Recording, error) {
+	var rec Recording
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return rec, err
+	}
+
+	if info.IsDir() {
+		err = loadDir(path, rec.add)
+	} else {
+		err = loadTar(path, rec.add)
+	}
+	if err != nil {
+		return rec, err
+	}
+
+	sort.SliceStable(rec.Captures, func(i, j int) bool { return rec.Captures[i].Path < rec.Captures[j].Path })
+	return rec, nil
+}
+
+func loadDir(root string, add func(string, []byte) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		return add(filepath.ToSlash(rel), content)
+	})
+}
+
+func loadTar(path string, add func(string, []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if err := add(hdr.Name, content); err != nil {
+			return err
+		}
+	}
+}
+
+func (rec *Recording) add(name string, content []byte) error {
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		content, err = io.ReadAll(gz)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		name = strings.TrimSuffix(name, ".gz")
+	}
+
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		return rec.addManifests(name, content)
+	case ".json":
+		var probe struct {
+			Kind string `json:"kind"`
+			Node struct {
+				NodeName string `json:"nodeName"`
+			} `json:"node"`
+		}
+		if err := json.Unmarshal(content, &probe); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if probe.Kind != "" {
+			return rec.addManifests(name, content)
+		}
+		node := probe.Node.NodeName
+		if node == "" {
+			node = strings.TrimSuffix(filepath.Base(name), ".json")
+		}
+		rec.Captures = append(rec.Captures, Capture{Path: name, Node: node, Content: content})
+	default:
+		log.Debug().Msgf("replay: skipping %s", name)
+	}
+	return nil
+}
+
+func (rec *Recording) addManifests(name string, content []byte) error {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(content)))
+	decoder := scheme.Codecs.UniversalDeserializer()
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := rec.addObject(name, obj); err != nil {
+			return err
+		}
+	}
+}
+
+func (rec *Recording) addObject(name string, obj runtime.Object) error {
+	switch o := obj.(type) {
+	case *v1.Pod:
+		rec.Pods = append(rec.Pods, *o)
+	case *v1.PodList:
+		rec.Pods = append(rec.Pods, o.Items...)
+	case *v1.List:
+		for _, item := range o.Items {
+			itemObj, _, err := scheme.Codecs.UniversalDeserializer().Decode(item.Raw, nil, nil)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if err := rec.addObject(name, itemObj); err != nil {
+				return err
+			}
+		}
+	default:
+		log.Debug().Msgf("replay: skipping %T in %s", obj, name)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package replay
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const summaryNode1 = `{"node":{"nodeName":"node-1"},"pods":[{"podRef":{"name":"web-0","namespace":"default"}}]}`
+
+const summaryNoNodeName = `{"pods":[]}`
+
+const podsYAML = `apiVersion: v1
+kind: Pod
+metadata:
+  name: web-0
+  namespace: default
+spec:
+  containers:
+  - name: app
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+`
+
+const podListJSON = `{"apiVersion":"v1","kind":"PodList","items":[{"metadata":{"name":"db-0","namespace":"data"}}]}`
+
+func gzipped(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// recordingFiles is the recording shared by the directory and tarball tests.
+func recordingFiles(t *testing.T) map[string][]byte {
+	return map[string][]byte{
+		"node-1/2024-01-01T00-00-10Z.json.gz": gzipped(t, summaryNode1),
+		"node-1/2024-01-01T00-00-00Z.json":    []byte(summaryNode1),
+		"node-2.json":                         []byte(summaryNoNodeName),
+		"manifests/pods.yaml":                 []byte(podsYAML),
+		"manifests/db.json":                   []byte(podListJSON),
+		"README.txt":                          []byte("notes"),
+	}
+}
+
+func assertRecording(t *testing.T, rec Recording) {
+	t.Helper()
+	var captures []string
+	for _, c := range rec.Captures {
+		captures = append(captures, c.Node+" "+c.Path)
+	}
+	want := []string{
+		"node-1 node-1/2024-01-01T00-00-00Z.json",
+		"node-1 node-1/2024-01-01T00-00-10Z.json",
+		"node-2 node-2.json",
+	}
+	if !reflect.DeepEqual(captures, want) {
+		t.Errorf("captures = %v, want %v", captures, want)
+	}
+	if string(rec.Captures[1].Content) != summaryNode1 {
+		t.Errorf("gzipped capture not decompressed: %q", rec.Captures[1].Content)
+	}
+
+	pods := map[string]bool{}
+	for _, p := range rec.Pods {
+		pods[p.Namespace+"/"+p.Name] = true
+	}
+	if !reflect.DeepEqual(pods, map[string]bool{"default/web-0": true, "data/db-0": true}) {
+		t.Errorf("pods = %v", pods)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range recordingFiles(t) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	assertRecording(t, rec)
+}
+
+func TestLoadTarball(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "manifests/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range recordingFiles(t) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "capture.tgz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	assertRecording(t, rec)
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing path")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for malformed summary")
+	}
+}
@@ -0,0 +1,217 @@
+package sample
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+)
+
+// nodeRef identifies a node across the scraped clusters.
+type nodeRef struct {
+	cluster string
+	node    string
+}
+
+// podRef identifies a pod across the scraped clusters.
+type podRef struct {
+	cluster   string
+	namespace string
+	pod       string
+}
+
+// podSample is the time of the last stats summary sample of a pod.
+type podSample struct {
+	node nodeRef
+	time time.Time
+}
+
+// nodeSample holds the sample times of a node: the filesystem sample its
+// node-level series carry, and the newest sample of the node or its pods.
+type nodeSample struct {
+	fs     time.Time
+	newest time.Time
+}
+
+var (
+	// timestamps makes registered collectors export samples with the time the
+	// kubelet took them instead of the Prometheus scrape time.
+	timestamps atomic.Bool
+
+	mu    sync.RWMutex
+	nodes = map[nodeRef]nodeSample{}
+	pods  = map[podRef]podSample{}
+
+	now = time.Now
+
+	// ageDesc is set by NewAgeCollector, once the cluster label is known.
+	ageDesc *prometheus.Desc
+)
+
+// SetTimestamps turns exporting source timestamps on or off.
+func SetTimestamps(enabled bool) {
+	timestamps.Store(enabled)
+}
+
+// ObserveNode records the time the kubelet sampled the filesystem of node of
+// cluster, empty unless several clusters are scraped. Zero times, from
+// sources that carry none, are ignored.
+func ObserveNode(cluster string, node string, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	ref := nodeRef{cluster: cluster, node: node}
+	mu.Lock()
+	defer mu.Unlock()
+	n := nodes[ref]
+	n.fs = t
+	n.newest = latest(n.newest, t)
+	nodes[ref] = n
+}
+
+// Observe records the time the kubelet sampled a pod on node of cluster.
+// Zero times are ignored.
+func Observe(cluster string, node string, namespace string, pod string, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	ref := nodeRef{cluster: cluster, node: node}
+	mu.Lock()
+	defer mu.Unlock()
+	pods[podRef{cluster: cluster, namespace: namespace, pod: pod}] = podSample{node: ref, time: t}
+	n := nodes[ref]
+	n.newest = latest(n.newest, t)
+	nodes[ref] = n
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// ForgetPod drops the sample time of an evicted pod.
+func ForgetPod(cluster string, namespace string, pod string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(pods, podRef{cluster: cluster, namespace: namespace, pod: pod})
+}
+
+// ForgetNode drops the sample times of an evicted node and its pods.
+func ForgetNode(cluster string, node string) {
+	ref := nodeRef{cluster: cluster, node: node}
+	mu.Lock()
+	defer mu.Unlock()
+	delete(nodes, ref)
+	for name, p := range pods {
+		if p.node == ref {
+			delete(pods, name)
+		}
+	}
+}
+
+// lookup returns the sample time of the series of pod on node, or of the
+// node filesystem for node-level series. Pods without a sample of their own
+// stay unstamped.
+func lookup(cluster string, node string, namespace string, pod string) time.Time {
+	ref := nodeRef{cluster: cluster, node: node}
+	mu.RLock()
+	defer mu.RUnlock()
+	if pod == "" {
+		return nodes[ref].fs
+	}
+	if p, ok := pods[podRef{cluster: cluster, namespace: namespace, pod: pod}]; ok && p.node == ref {
+		return p.time
+	}
+	return time.Time{}
+}
+
+// timestamped stamps the metrics of a collector with their sample time,
+// found through their cluster, node_name, pod_namespace and pod_name labels.
+type timestamped struct {
+	prometheus.Collector
+}
+
+// MustRegister registers collectors so their metrics carry source
+// timestamps while SetTimestamps is on.
+func MustRegister(collectors ...prometheus.Collector) {
+	for _, c := range collectors {
+		prometheus.MustRegister(timestamped{c})
+	}
+}
+
+func (c timestamped) Collect(ch chan<- prometheus.Metric) {
+	if !timestamps.Load() {
+		c.Collector.Collect(ch)
+		return
+	}
+	inner := make(chan prometheus.Metric)
+	go func() {
+		c.Collector.Collect(inner)
+		close(inner)
+	}()
+	for m := range inner {
+		ch <- withTimestamp(m)
+	}
+}
+
+func withTimestamp(m prometheus.Metric) prometheus.Metric {
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		return m
+	}
+	var cluster, node, namespace, pod string
+	for _, l := range out.GetLabel() {
+		switch l.GetName() {
+		case "cluster":
+			cluster = l.GetValue()
+		case "node_name":
+			node = l.GetValue()
+		case "pod_namespace":
+			namespace = l.GetValue()
+		case "pod_name":
+			pod = l.GetValue()
+		}
+	}
+	t := lookup(cluster, node, namespace, pod)
+	if t.IsZero() {
+		return m
+	}
+	return prometheus.NewMetricWithTimestamp(t, m)
+}
+
+// ageCollector reports ephemeral_storage_sample_age_seconds for every node
+// with a known sample time.
+type ageCollector struct{}
+
+// NewAgeCollector returns the collector of ephemeral_storage_sample_age_seconds.
+func NewAgeCollector() prometheus.Collector {
+	ageDesc = prometheus.NewDesc(
+		"ephemeral_storage_sample_age_seconds",
+		"Seconds since the kubelet took the newest stats summary sample of a node",
+		dev.WithClusterLabel([]string{"node_name"}), nil,
+	)
+	return ageCollector{}
+}
+
+func (ageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ageDesc
+}
+
+func (ageCollector) Collect(ch chan<- prometheus.Metric) {
+	mu.RLock()
+	defer mu.RUnlock()
+	current := now()
+	for ref, n := range nodes {
+		values := []string{ref.node}
+		if ref.cluster != "" {
+			values = append(values, ref.cluster)
+		}
+		ch <- prometheus.MustNewConstMetric(ageDesc, prometheus.GaugeValue, current.Sub(n.newest).Seconds(), values...)
+	}
+}
@@ -0,0 +1,155 @@
+package sample
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func reset(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	nodes = map[nodeRef]nodeSample{}
+	pods = map[podRef]podSample{}
+	mu.Unlock()
+	t.Cleanup(func() {
+		SetTimestamps(false)
+		now = time.Now
+	})
+}
+
+func newRegistry(t *testing.T) (*prometheus.Registry, *prometheus.GaugeVec, *prometheus.GaugeVec) {
+	t.Helper()
+	podVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_pod_usage", Help: "pod"}, []string{"pod_namespace", "pod_name", "node_name"})
+	nodeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_node_available", Help: "node"}, []string{"node_name"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(timestamped{podVec}, timestamped{nodeVec}, NewAgeCollector())
+	return registry, podVec, nodeVec
+}
+
+// timestampsMs gathers the registry and returns the timestamp of every
+// sample by metric name, pod namespace and pod or node name, 0 when it has
+// none. The age gauge is skipped.
+func timestampsMs(t *testing.T, registry *prometheus.Registry) map[string]int64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]int64{}
+	for _, f := range families {
+		if f.GetName() == "ephemeral_storage_sample_age_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			name := labels["pod_name"]
+			if name == "" {
+				name = labels["node_name"]
+			}
+			out[f.GetName()+"/"+labels["pod_namespace"]+"/"+name] = m.GetTimestampMs()
+		}
+	}
+	return out
+}
+
+func TestTimestamps(t *testing.T) {
+	reset(t)
+	registry, podVec, nodeVec := newRegistry(t)
+
+	t1 := time.Date(2024, 1, 1, 0, 0, 10, 0, time.UTC)
+	t2 := time.Date(2024, 1, 1, 0, 0, 20, 0, time.UTC)
+	t3 := time.Date(2024, 1, 1, 0, 0, 15, 0, time.UTC)
+	Observe("", "node-1", "ns-a", "pod-a", t1)
+	Observe("", "node-1", "ns-a", "pod-b", t2)
+	Observe("", "node-1", "ns-a", "pod-c", time.Time{})
+	ObserveNode("", "node-1", t3)
+	for _, p := range []string{"pod-a", "pod-b", "pod-c"} {
+		podVec.WithLabelValues("ns-a", p, "node-1").Set(1)
+	}
+	// A pod of the same name in another namespace has no sample of its own.
+	podVec.WithLabelValues("ns-b", "pod-a", "node-1").Set(1)
+	nodeVec.WithLabelValues("node-1").Set(1)
+	nodeVec.WithLabelValues("node-2").Set(1)
+
+	// Disabled by default: samples carry no timestamp.
+	for _, ts := range timestampsMs(t, registry) {
+		if ts != 0 {
+			t.Errorf("sample has timestamp %d with timestamps disabled", ts)
+		}
+	}
+
+	SetTimestamps(true)
+	got := timestampsMs(t, registry)
+	want := map[string]int64{
+		"test_pod_usage/ns-a/pod-a":   t1.UnixMilli(),
+		"test_pod_usage/ns-a/pod-b":   t2.UnixMilli(),
+		"test_pod_usage/ns-a/pod-c":   0, // no own sample, stays unstamped
+		"test_pod_usage/ns-b/pod-a":   0,
+		"test_node_available//node-1": t3.UnixMilli(), // the node filesystem sample
+		"test_node_available//node-2": 0,
+	}
+	for series, ts := range want {
+		if ts2, ok := got[series]; !ok || ts2 != ts {
+			t.Errorf("%s timestamp = %d (found %v), want %d", series, ts2, ok, ts)
+		}
+	}
+
+	ForgetPod("", "ns-a", "pod-a")
+	if got := timestampsMs(t, registry)["test_pod_usage/ns-a/pod-a"]; got != 0 {
+		t.Errorf("forgotten pod timestamp = %d, want none", got)
+	}
+	ForgetNode("", "node-1")
+	if got := timestampsMs(t, registry)["test_pod_usage/ns-a/pod-b"]; got != 0 {
+		t.Errorf("timestamp after the node was forgotten = %d, want none", got)
+	}
+}
+
+func TestSampleAge(t *testing.T) {
+	reset(t)
+	registry, _, _ := newRegistry(t)
+	now = func() time.Time { return time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC) }
+
+	Observe("", "node-1", "ns", "pod-a", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	Observe("", "node-1", "ns", "pod-b", time.Date(2024, 1, 1, 0, 0, 45, 0, time.UTC))
+	ObserveNode("", "node-2", time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC))
+
+	expected := `
+		# HELP ephemeral_storage_sample_age_seconds Seconds since the kubelet took the newest stats summary sample of a node
+		# TYPE ephemeral_storage_sample_age_seconds gauge
+		ephemeral_storage_sample_age_seconds{node_name="node-1"} 15
+		ephemeral_storage_sample_age_seconds{node_name="node-2"} 30
+	`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "ephemeral_storage_sample_age_seconds"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSampleAgeClusters(t *testing.T) {
+	reset(t)
+	t.Setenv("CLUSTERS", "a,b")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewAgeCollector())
+	now = func() time.Time { return time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC) }
+
+	// Nodes of the same name in two clusters are tracked apart.
+	Observe("a", "node-1", "ns", "pod-a", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	Observe("b", "node-1", "ns", "pod-a", time.Date(2024, 1, 1, 0, 0, 45, 0, time.UTC))
+	ForgetNode("b", "node-2")
+
+	expected := `
+		# HELP ephemeral_storage_sample_age_seconds Seconds since the kubelet took the newest stats summary sample of a node
+		# TYPE ephemeral_storage_sample_age_seconds gauge
+		ephemeral_storage_sample_age_seconds{cluster="a",node_name="node-1"} 60
+		ephemeral_storage_sample_age_seconds{cluster="b",node_name="node-1"} 15
+	`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "ephemeral_storage_sample_age_seconds"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+)
+
+// ScrapeFunc scrapes a node and returns its ephemeral storage usage in
+// percent, or a negative value when the node reported no capacity.
+type ScrapeFunc func(node string) (float64, error)
+
+// Scheduler scrapes every node on its own timer through a rate-limited work
+// queue instead of scraping all nodes in lock step. The first scrape of a
+// node is jittered across the interval, failing nodes back off
+// exponentially, nodes at or above the fast threshold are scraped on the
+// fast interval, and the queue never hands out a node that is still being
+// scraped.
+type Scheduler struct {
+	interval      time.Duration
+	fastInterval  time.Duration // 0 disables the fast interval
+	fastThreshold float64       // node usage in percent
+	syncPeriod    time.Duration
+	workers       int
+
+	nodes  mapset.Set[string]
+	scrape ScrapeFunc
+
+	limiter workqueue.TypedRateLimiter[string]
+	queue   workqueue.TypedRateLimitingInterface[string]
+
+	mu        sync.Mutex
+	scheduled map[string]bool
+}
+
+// NewScheduler returns a Scheduler scraping the nodes in nodes every
+// sampleInterval seconds with workers concurrent scrapes, configured through
+// SCRAPE_FAST_INTERVAL, SCRAPE_FAST_THRESHOLD_PERCENT and SCRAPE_MAX_BACKOFF.
+// Nodes removed from the set are dropped from the schedule.
+func NewScheduler(sampleInterval int64, workers int, nodes mapset.Set[string], scrape ScrapeFunc) (*Scheduler, error) {
+	fastInterval, err := strconv.ParseInt(dev.GetEnv("SCRAPE_FAST_INTERVAL", "0"), 10, 64)
+	if err != nil || fastInterval < 0 {
+		return nil, fmt.Errorf("SCRAPE_FAST_INTERVAL: want a non-negative number of seconds, got %q", dev.GetEnv("SCRAPE_FAST_INTERVAL", "0"))
+	}
+	fastThreshold, err := strconv.ParseFloat(dev.GetEnv("SCRAPE_FAST_THRESHOLD_PERCENT", "80"), 64)
+	if err != nil || fastThreshold < 0 || fastThreshold > 100 {
+		return nil, fmt.Errorf("SCRAPE_FAST_THRESHOLD_PERCENT: want a percentage, got %q", dev.GetEnv("SCRAPE_FAST_THRESHOLD_PERCENT", "80"))
+	}
+	maxBackoff, err := strconv.ParseInt(dev.GetEnv("SCRAPE_MAX_BACKOFF", "300"), 10, 64)
+	if err != nil || maxBackoff < 0 {
+		return nil, fmt.Errorf("SCRAPE_MAX_BACKOFF: want a non-negative number of seconds, got %q", dev.GetEnv("SCRAPE_MAX_BACKOFF", "300"))
+	}
+
+	interval := time.Duration(sampleInterval) * time.Second
+	return newScheduler(interval, time.Duration(fastInterval)*time.Second, fastThreshold, time.Duration(maxBackoff)*time.Second, workers, nodes, scrape), nil
+}
+
+func newScheduler(interval, fastInterval time.Duration, fastThreshold float64, maxBackoff time.Duration, workers int, nodes mapset.Set[string], scrape ScrapeFunc) *Scheduler {
+	limiter := workqueue.NewTypedItemExponentialFailureRateLimiter[string](interval, max(maxBackoff, interval))
+	return &Scheduler{
+		interval:      interval,
+		fastInterval:  fastInterval,
+		fastThreshold: fastThreshold,
+		syncPeriod:    min(interval, time.Second),
+		workers:       max(workers, 1),
+		nodes:         nodes,
+		scrape:        scrape,
+		limiter:       limiter,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(limiter, workqueue.TypedRateLimitingQueueConfig[string]{
+			Name: "node_scrapes",
+		}),
+		scheduled: map[string]bool{},
+	}
+}
+
+// Run schedules nodes as they show up and scrapes them until stop is closed.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Go(func() {
+			for s.process() {
+			}
+		})
+	}
+
+	ticker := time.NewTicker(s.syncPeriod)
+	defer ticker.Stop()
+	for {
+		s.sync()
+		select {
+		case <-stop:
+			s.queue.ShutDown()
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync schedules the nodes not scheduled yet. A node that was dropped while
+// failing resumes its backoff rather than starting over.
+func (s *Scheduler) sync() {
+	for _, node := range s.nodes.ToSlice() {
+		s.mu.Lock()
+		if s.scheduled[node] {
+			s.mu.Unlock()
+			continue
+		}
+		s.scheduled[node] = true
+		s.mu.Unlock()
+
+		if s.limiter.NumRequeues(node) > 0 {
+			s.queue.AddRateLimited(node)
+			continue
+		}
+		var jitter time.Duration
+		if s.interval > 0 {
+			jitter = rand.N(s.interval)
+		}
+		s.queue.AddAfter(node, jitter)
+	}
+}
+
+func (s *Scheduler) process() bool {
+	node, shutdown := s.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer s.queue.Done(node)
+
+	if !s.nodes.Contains(node) {
+		s.drop(node)
+		return true
+	}
+
+	usage, err := s.scrape(node)
+	if err != nil {
+		delay := s.limiter.When(node)
+		log.Debug().Err(err).Msgf("Node %s: scrape failed, retrying in %v", node, delay)
+		s.queue.AddAfter(node, delay)
+		return true
+	}
+	s.queue.Forget(node)
+	s.queue.AddAfter(node, s.next(usage))
+	return true
+}
+
+// drop removes node from the schedule. Its failure count is kept so the
+// backoff continues if the node comes back.
+func (s *Scheduler) drop(node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.scheduled, node)
+}
+
+// next is the delay until the following scrape of a node at usage percent.
+func (s *Scheduler) next(usage float64) time.Duration {
+	if s.fastInterval > 0 && usage >= s.fastThreshold {
+		return s.fastInterval
+	}
+	return s.interval
+}
@@ -0,0 +1,207 @@
+package scheduler
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// scrapes counts scrapes per node and fails the test when a node is scraped
+// while a previous scrape of it is still running.
+type scrapes struct {
+	t        *testing.T
+	mu       sync.Mutex
+	count    map[string]int
+	inFlight map[string]bool
+	usage    map[string]float64
+	fail     map[string]bool
+}
+
+func newScrapes(t *testing.T) *scrapes {
+	return &scrapes{t: t, count: map[string]int{}, inFlight: map[string]bool{}, usage: map[string]float64{}, fail: map[string]bool{}}
+}
+
+func (s *scrapes) scrape(node string) (float64, error) {
+	s.mu.Lock()
+	if s.inFlight[node] {
+		s.t.Errorf("node %s scraped concurrently", node)
+	}
+	s.inFlight[node] = true
+	s.count[node]++
+	usage, fail := s.usage[node], s.fail[node]
+	s.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	s.mu.Lock()
+	s.inFlight[node] = false
+	s.mu.Unlock()
+	if fail {
+		return -1, errors.New("boom")
+	}
+	return usage, nil
+}
+
+func (s *scrapes) get(node string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count[node]
+}
+
+func run(t *testing.T, s *Scheduler, d time.Duration) {
+	t.Helper()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+	time.Sleep(d)
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop")
+	}
+}
+
+func TestSchedulerScrapesEveryNode(t *testing.T) {
+	nodes := mapset.NewSet("node-1", "node-2", "node-3")
+	sc := newScrapes(t)
+	s := newScheduler(20*time.Millisecond, 0, 80, time.Second, 4, nodes, sc.scrape)
+	run(t, s, 300*time.Millisecond)
+
+	for _, node := range nodes.ToSlice() {
+		// ~15 scrapes in 300ms at a 20ms interval, minus the jittered start.
+		if n := sc.get(node); n < 5 || n > 16 {
+			t.Errorf("node %s scraped %d times, want about 15", node, n)
+		}
+	}
+}
+
+func TestSchedulerFastInterval(t *testing.T) {
+	nodes := mapset.NewSet("full", "empty")
+	sc := newScrapes(t)
+	sc.usage["full"] = 95
+	sc.usage["empty"] = 10
+	s := newScheduler(100*time.Millisecond, 10*time.Millisecond, 80, time.Second, 2, nodes, sc.scrape)
+	run(t, s, 400*time.Millisecond)
+
+	if full, empty := sc.get("full"), sc.get("empty"); full < 3*empty {
+		t.Errorf("node above the threshold scraped %d times, node below %d times; want it much more often", full, empty)
+	}
+}
+
+func TestSchedulerBacksOffFailingNodes(t *testing.T) {
+	nodes := mapset.NewSet("healthy", "failing")
+	sc := newScrapes(t)
+	sc.fail["failing"] = true
+	s := newScheduler(10*time.Millisecond, 0, 80, time.Minute, 2, nodes, sc.scrape)
+	run(t, s, 300*time.Millisecond)
+
+	// Retries after 10, 20, 40, 80 and 160ms fit in 300ms.
+	if failing, healthy := sc.get("failing"), sc.get("healthy"); failing > 6 || healthy < 3*failing {
+		t.Errorf("failing node scraped %d times, healthy node %d times", failing, healthy)
+	}
+	if n := s.limiter.NumRequeues("failing"); n == 0 {
+		t.Error("expected failures to be tracked for the failing node")
+	}
+	if n := s.limiter.NumRequeues("healthy"); n != 0 {
+		t.Errorf("healthy node has %d failures, want 0", n)
+	}
+}
+
+func TestSchedulerDropsRemovedNodes(t *testing.T) {
+	nodes := mapset.NewSet("node-1")
+	sc := newScrapes(t)
+	s := newScheduler(10*time.Millisecond, 0, 80, time.Second, 1, nodes, sc.scrape)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	nodes.Remove("node-1")
+	time.Sleep(50 * time.Millisecond)
+	before := sc.get("node-1")
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-done
+
+	if before == 0 {
+		t.Fatal("node was never scraped")
+	}
+	if after := sc.get("node-1"); after != before {
+		t.Errorf("removed node scraped %d more times", after-before)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scheduled["node-1"] {
+		t.Error("removed node still scheduled")
+	}
+}
+
+func TestSyncResumesBackoff(t *testing.T) {
+	nodes := mapset.NewSet("node-1")
+	s := newScheduler(time.Hour, 0, 80, 24*time.Hour, 1, nodes, nil)
+	defer s.queue.ShutDown()
+
+	// The node failed twice before it was dropped.
+	s.limiter.When("node-1")
+	s.limiter.When("node-1")
+	s.sync()
+	if n := s.limiter.NumRequeues("node-1"); n != 3 {
+		t.Errorf("failures = %d, want the backoff to continue at 3", n)
+	}
+
+	// Scheduled nodes are not added twice.
+	s.sync()
+	if n := s.limiter.NumRequeues("node-1"); n != 3 {
+		t.Errorf("failures = %d after a second sync, want 3", n)
+	}
+}
+
+func TestNext(t *testing.T) {
+	s := newScheduler(time.Minute, 5*time.Second, 80, time.Hour, 1, mapset.NewSet[string](), nil)
+	defer s.queue.ShutDown()
+	for _, tt := range []struct {
+		usage float64
+		want  time.Duration
+	}{
+		{-1, time.Minute},
+		{79.9, time.Minute},
+		{80, 5 * time.Second},
+		{100, 5 * time.Second},
+	} {
+		if got := s.next(tt.usage); got != tt.want {
+			t.Errorf("next(%v) = %v, want %v", tt.usage, got, tt.want)
+		}
+	}
+
+	s.fastInterval = 0
+	if got := s.next(100); got != time.Minute {
+		t.Errorf("next(100) with the fast interval disabled = %v, want 1m", got)
+	}
+}
+
+func TestNewSchedulerRejectsBadEnv(t *testing.T) {
+	for _, tt := range []struct{ env, value string }{
+		{"SCRAPE_FAST_INTERVAL", "5s"},
+		{"SCRAPE_FAST_INTERVAL", "-1"},
+		{"SCRAPE_FAST_THRESHOLD_PERCENT", "high"},
+		{"SCRAPE_FAST_THRESHOLD_PERCENT", "120"},
+		{"SCRAPE_MAX_BACKOFF", "5m"},
+	} {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			if _, err := NewScheduler(15, 1, mapset.NewSet[string](), nil); err == nil {
+				t.Errorf("expected an error for %s=%q", tt.env, tt.value)
+			}
+		})
+	}
+}
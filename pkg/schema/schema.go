@@ -0,0 +1,130 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/dev"
+)
+
+// Schemas the metrics are exported in, selected through METRICS_SCHEMA.
+const (
+	// V1 is the original schema, kept as the default so existing dashboards
+	// and alerts keep working.
+	V1 = "v1"
+	// V2 names families with their units and labels pods and nodes like
+	// kube-state-metrics does.
+	V2 = "v2"
+	// Both exports the families of both schemas while dashboards migrate:
+	// every family as in v1, plus the renamed families with v2 labels.
+	Both = "both"
+)
+
+// names maps the v1 families renamed in v2. Families missing here keep their
+// name and only have their labels renamed.
+var names = map[string]string{
+	"ephemeral_storage_pod_usage":              "ephemeral_storage_pod_used_bytes",
+	"ephemeral_storage_container_volume_usage": "ephemeral_storage_container_volume_used_bytes",
+	"ephemeral_storage_node_available":         "ephemeral_storage_node_available_bytes",
+	"ephemeral_storage_node_capacity":          "ephemeral_storage_node_capacity_bytes",
+	"ephemeral_storage_inodes":                 "ephemeral_storage_pod_inodes",
+	"ephemeral_storage_inodes_free":            "ephemeral_storage_pod_inodes_free",
+	"ephemeral_storage_inodes_used":            "ephemeral_storage_pod_inodes_used",
+	"ephemeral_storage_adjusted_polling_rate":  "ephemeral_storage_adjusted_polling_rate_milliseconds",
+}
+
+// labels maps the v1 labels renamed in v2 to the ones kube-state-metrics
+// uses, so series join with kube_pod_* and kube_node_* without label_replace.
+var labels = map[string]string{
+	"pod_name":      "pod",
+	"pod_namespace": "namespace",
+	"node_name":     "node",
+}
+
+// collisions holds the family and label pairs already warned about, so a
+// colliding label is only logged once.
+var collisions sync.Map
+
+// gatherer translates the families of a gatherer from v1 to its schema.
+type gatherer struct {
+	prometheus.Gatherer
+	schema string
+}
+
+// NewGatherer returns g exporting the schema selected through
+// METRICS_SCHEMA (v1, v2 or both). The collectors keep producing v1 and are
+// translated on every gather, so v1 returns g as is.
+func NewGatherer(g prometheus.Gatherer) (prometheus.Gatherer, error) {
+	switch schema := dev.GetEnv("METRICS_SCHEMA", V1); schema {
+	case V1:
+		return g, nil
+	case V2, Both:
+		return gatherer{Gatherer: g, schema: schema}, nil
+	default:
+		return nil, fmt.Errorf("METRICS_SCHEMA: unknown schema %q, want %s, %s or %s", schema, V1, V2, Both)
+	}
+}
+
+func (g gatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	// Like the registry, return whatever was gathered along with the error.
+	out := make([]*dto.MetricFamily, 0, len(families))
+	for _, f := range families {
+		name, renamed := names[f.GetName()]
+		switch {
+		case g.schema == V2:
+			for _, m := range f.GetMetric() {
+				m.Label = translateLabels(f.GetName(), m.GetLabel())
+			}
+			if renamed {
+				f.Name = &name
+			}
+			out = append(out, f)
+		case renamed:
+			// The v1 family keeps its v1 labels, only its v2 copy gets the v2 ones.
+			metrics := make([]*dto.Metric, 0, len(f.GetMetric()))
+			for _, m := range f.GetMetric() {
+				metrics = append(metrics, &dto.Metric{Label: translateLabels(f.GetName(), m.GetLabel()),
+					Gauge: m.Gauge, Counter: m.Counter, Summary: m.Summary, Untyped: m.Untyped,
+					Histogram: m.Histogram, TimestampMs: m.TimestampMs})
+			}
+			out = append(out, f, &dto.MetricFamily{Name: &name, Help: f.Help, Type: f.Type, Unit: f.Unit, Metric: metrics})
+		default:
+			out = append(out, f)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out, err
+}
+
+// translateLabels renames the v1 labels of a series of family and sorts the
+// labels by name as the exposition expects. A v1 label whose v2 name the
+// series already carries keeps its v1 name rather than overwriting it.
+func translateLabels(family string, pairs []*dto.LabelPair) []*dto.LabelPair {
+	present := make(map[string]bool, len(pairs))
+	for _, l := range pairs {
+		present[l.GetName()] = true
+	}
+	out := make([]*dto.LabelPair, 0, len(pairs))
+	for _, l := range pairs {
+		name, renamed := labels[l.GetName()]
+		switch {
+		case !renamed:
+			out = append(out, l)
+		case present[name]:
+			if _, warned := collisions.LoadOrStore(family+"/"+name, true); !warned {
+				log.Warn().Msgf("%s already has a %s label, keeping %s", family, name, l.GetName())
+			}
+			out = append(out, l)
+		default:
+			out = append(out, &dto.LabelPair{Name: &name, Value: l.Value})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out
+}
@@ -0,0 +1,129 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newRegistry(t *testing.T) *prometheus.Registry {
+	t.Helper()
+	podUsage := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_pod_usage",
+		Help: "Current ephemeral byte usage of pod",
+	}, []string{"pod_namespace", "pod_name", "node_name"})
+	rootfsUsed := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_container_rootfs_used_bytes",
+		Help: "Current rootfs bytes used by a container in a pod",
+	}, []string{"pod_namespace", "pod_name", "node_name", "container"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(podUsage, rootfsUsed)
+	podUsage.WithLabelValues("ns-a", "pod-a", "node-1").Set(2e6)
+	rootfsUsed.WithLabelValues("ns-a", "pod-a", "node-1", "app").Set(1e6)
+	return registry
+}
+
+func TestGatherer(t *testing.T) {
+	tests := []struct {
+		schema   string
+		expected string
+	}{
+		{
+			schema: V1,
+			expected: `
+				# HELP ephemeral_storage_container_rootfs_used_bytes Current rootfs bytes used by a container in a pod
+				# TYPE ephemeral_storage_container_rootfs_used_bytes gauge
+				ephemeral_storage_container_rootfs_used_bytes{container="app",node_name="node-1",pod_name="pod-a",pod_namespace="ns-a"} 1e+06
+				# HELP ephemeral_storage_pod_usage Current ephemeral byte usage of pod
+				# TYPE ephemeral_storage_pod_usage gauge
+				ephemeral_storage_pod_usage{node_name="node-1",pod_name="pod-a",pod_namespace="ns-a"} 2e+06
+			`,
+		},
+		{
+			schema: V2,
+			expected: `
+				# HELP ephemeral_storage_container_rootfs_used_bytes Current rootfs bytes used by a container in a pod
+				# TYPE ephemeral_storage_container_rootfs_used_bytes gauge
+				ephemeral_storage_container_rootfs_used_bytes{container="app",namespace="ns-a",node="node-1",pod="pod-a"} 1e+06
+				# HELP ephemeral_storage_pod_used_bytes Current ephemeral byte usage of pod
+				# TYPE ephemeral_storage_pod_used_bytes gauge
+				ephemeral_storage_pod_used_bytes{namespace="ns-a",node="node-1",pod="pod-a"} 2e+06
+			`,
+		},
+		{
+			schema: Both,
+			expected: `
+				# HELP ephemeral_storage_container_rootfs_used_bytes Current rootfs bytes used by a container in a pod
+				# TYPE ephemeral_storage_container_rootfs_used_bytes gauge
+				ephemeral_storage_container_rootfs_used_bytes{container="app",node_name="node-1",pod_name="pod-a",pod_namespace="ns-a"} 1e+06
+				# HELP ephemeral_storage_pod_usage Current ephemeral byte usage of pod
+				# TYPE ephemeral_storage_pod_usage gauge
+				ephemeral_storage_pod_usage{node_name="node-1",pod_name="pod-a",pod_namespace="ns-a"} 2e+06
+				# HELP ephemeral_storage_pod_used_bytes Current ephemeral byte usage of pod
+				# TYPE ephemeral_storage_pod_used_bytes gauge
+				ephemeral_storage_pod_used_bytes{namespace="ns-a",node="node-1",pod="pod-a"} 2e+06
+			`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			t.Setenv("METRICS_SCHEMA", tt.schema)
+			g, err := NewGatherer(newRegistry(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := testutil.GatherAndCompare(g, strings.NewReader(tt.expected)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestGathererKeepsCollidingLabels checks a family already labelled with a
+// v2 label name keeps it instead of having it overwritten.
+func TestGathererKeepsCollidingLabels(t *testing.T) {
+	t.Setenv("METRICS_SCHEMA", V2)
+	deleted := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ephemeral_storage_deleted_file_bytes",
+		Help: "Bytes held by deleted files",
+	}, []string{"pod_name", "pod", "node_name"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(deleted)
+	deleted.WithLabelValues("pod-a", "sandbox", "node-1").Set(1)
+
+	g, err := NewGatherer(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+		# HELP ephemeral_storage_deleted_file_bytes Bytes held by deleted files
+		# TYPE ephemeral_storage_deleted_file_bytes gauge
+		ephemeral_storage_deleted_file_bytes{node="node-1",pod="sandbox",pod_name="pod-a"} 1
+	`
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewGathererRejectsUnknownSchema(t *testing.T) {
+	t.Setenv("METRICS_SCHEMA", "v3")
+	if _, err := NewGatherer(prometheus.NewRegistry()); err == nil {
+		t.Error("expected an error for an unknown schema")
+	}
+}
+
+// TestNamesAreUnique guards against a v2 name shadowing another family.
+func TestNamesAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for v1, v2 := range names {
+		if _, ok := names[v2]; ok {
+			t.Errorf("v2 name %s of %s is a v1 name", v2, v1)
+		}
+		if seen[v2] {
+			t.Errorf("v2 name %s is used twice", v2)
+		}
+		seen[v2] = true
+	}
+}
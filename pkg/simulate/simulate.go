@@ -0,0 +1,349 @@
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/node"
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/pod"
+)
+
+// Usage patterns of the simulated containers.
+const (
+	PatternFlat     = "flat"
+	PatternGrow     = "grow"
+	PatternShrink   = "shrink"
+	PatternSawtooth = "sawtooth"
+)
+
+// Config describes the simulated cluster and the failures to inject into
+// stats summary requests.
+type Config struct {
+	Nodes            int
+	PodsPerNode      int
+	ContainersPerPod int
+	CapacityBytes    int64 // ephemeral storage capacity of every node
+	BaseBytes        int64 // starting usage of a container, varied per container
+	RateBytes        int64 // bytes per second the pattern adds or removes
+	LimitBytes       int64 // ephemeral-storage limit of every container, 0 for none
+	Pattern          string
+	Latency          time.Duration // added to every stats summary response
+	ErrorRate        float64       // share of stats summary requests answered with 503
+	MalformedRate    float64       // share of stats summary responses cut short
+	DropPodRate      float64       // share of pods left out of each stats summary
+	DeadNodes        int           // the first DeadNodes nodes always answer 503
+	WatchTimeout     time.Duration // longest a watch request is held open
+}
+
+// Cluster is a fake apiserver serving the node and pod lists and watches the
+// exporter's informers need, plus the node proxy stats summaries.
+type Cluster struct {
+	cfg   Config
+	start time.Time
+	now   func() time.Time
+
+	summaries atomic.Int64
+	failures  atomic.Int64
+}
+
+// NewCluster returns a simulated cluster whose usage patterns start now.
+func NewCluster(cfg Config) (*Cluster, error) {
+	switch cfg.Pattern {
+	case PatternFlat, PatternGrow, PatternShrink, PatternSawtooth:
+	default:
+		return nil, fmt.Errorf("unknown pattern %q, want flat, grow, shrink or sawtooth", cfg.Pattern)
+	}
+	if cfg.Nodes < 1 || cfg.PodsPerNode < 0 || cfg.ContainersPerPod < 1 {
+		return nil, fmt.Errorf("need at least 1 node and 1 container per pod")
+	}
+	if cfg.WatchTimeout <= 0 {
+		cfg.WatchTimeout = 5 * time.Minute
+	}
+	return &Cluster{cfg: cfg, start: time.Now(), now: time.Now}, nil
+}
+
+func nodeName(n int) string {
+	return fmt.Sprintf("sim-node-%05d", n)
+}
+
+// podName is unique across the cluster, as the exporter looks pods up by name.
+func podName(n, p int) string {
+	return fmt.Sprintf("sim-node-%05d-pod-%04d", n, p)
+}
+
+func podNamespace(p int) string {
+	return fmt.Sprintf("sim-%d", p%10)
+}
+
+func containerName(c int) string {
+	return fmt.Sprintf("c%d", c)
+}
+
+// usage is the current usage of a container following the configured
+// pattern; seed varies the starting usage across containers.
+func (c *Cluster) usage(seed int) int64 {
+	base := c.cfg.BaseBytes + int64(seed%10)*c.cfg.BaseBytes/10
+	delta := c.cfg.RateBytes * int64(c.now().Sub(c.start)/time.Second)
+	switch c.cfg.Pattern {
+	case PatternGrow:
+		return base + delta
+	case PatternShrink:
+		return max(base-delta, 0)
+	case PatternSawtooth:
+		if base == 0 {
+			return 0
+		}
+		return base + delta%base
+	default:
+		return base
+	}
+}
+
+func (c *Cluster) node(n int) v1.Node {
+	return v1.Node{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Node"},
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName(n), ResourceVersion: "1"},
+		Status: v1.NodeStatus{
+			Conditions:      []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue, Reason: "KubeletReady"}},
+			Addresses:       []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "127.0.0.1"}},
+			DaemonEndpoints: v1.NodeDaemonEndpoints{KubeletEndpoint: v1.DaemonEndpoint{Port: 10250}},
+			Capacity:        v1.ResourceList{v1.ResourceEphemeralStorage: *resource.NewQuantity(c.cfg.CapacityBytes, resource.BinarySI)},
+		},
+	}
+}
+
+func (c *Cluster) pod(n, p int) v1.Pod {
+	var containers []v1.Container
+	for i := 0; i < c.cfg.ContainersPerPod; i++ {
+		container := v1.Container{Name: containerName(i), Image: "sim"}
+		if c.cfg.LimitBytes > 0 {
+			container.Resources.Limits = v1.ResourceList{v1.ResourceEphemeralStorage: *resource.NewQuantity(c.cfg.LimitBytes, resource.BinarySI)}
+		}
+		containers = append(containers, container)
+	}
+	return v1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: podName(n, p), Namespace: podNamespace(p), ResourceVersion: "1"},
+		Spec:       v1.PodSpec{NodeName: nodeName(n), Containers: containers},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+// Summary builds the stats summary of node n at the current time.
+func (c *Cluster) Summary(n int) node.Summary {
+	var summary node.Summary
+	summary.Node.NodeName = nodeName(n)
+
+	type podUsage struct {
+		stats node.PodStats
+		used  int64
+	}
+	var pods []podUsage
+	var nodeUsed int64
+	for p := 0; p < c.cfg.PodsPerNode; p++ {
+		stats := node.PodStats{PodRef: node.PodReference{Name: podName(n, p), Namespace: podNamespace(p)}}
+		var used int64
+		for i := 0; i < c.cfg.ContainersPerPod; i++ {
+			u := c.usage(n + p + i)
+			used += u
+			stats.Containers = append(stats.Containers, pod.ContainerStats{
+				Name:   containerName(i),
+				Rootfs: pod.FsStats{UsedBytes: int(u), CapacityBytes: c.cfg.CapacityBytes},
+			})
+		}
+		nodeUsed += used
+		pods = append(pods, podUsage{stats: stats, used: used})
+	}
+
+	available := max(c.cfg.CapacityBytes-nodeUsed, 0)
+	sampled := c.now().UTC().Truncate(time.Second)
+	for _, p := range pods {
+		if c.cfg.DropPodRate > 0 && rand.Float64() < c.cfg.DropPodRate {
+			continue
+		}
+		p.stats.EphemeralStorage = node.FsStats{
+			Time:           sampled,
+			UsedBytes:      float64(p.used),
+			AvailableBytes: float64(available),
+			CapacityBytes:  float64(c.cfg.CapacityBytes),
+		}
+		for i := range p.stats.Containers {
+			p.stats.Containers[i].Rootfs.AvailableBytes = available
+		}
+		summary.Pods = append(summary.Pods, p.stats)
+	}
+	return summary
+}
+
+// Handler serves the apiserver paths the exporter uses in Deployment mode,
+// plus /sim/stats with the number of summaries served and failed.
+func (c *Cluster) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/nodes", c.serveNodes)
+	mux.HandleFunc("GET /api/v1/pods", c.servePods)
+	mux.HandleFunc("GET /api/v1/nodes/{node}/proxy/stats/summary", c.serveSummary)
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("GET /sim/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]int64{"summaries": c.summaries.Load(), "failures": c.failures.Load()})
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("simulate: failed to write response")
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Code:     int32(code),
+		Reason:   reason,
+		Message:  msg,
+	})
+}
+
+// serveWatch holds a watch open without events until the client goes away or
+// the watch times out. Streaming watch lists are refused so client-go falls
+// back to a regular list.
+func (c *Cluster) serveWatch(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("sendInitialEvents") == "true" {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, "sendInitialEvents is not supported by the simulator")
+		return
+	}
+	timeout := c.cfg.WatchTimeout
+	if s := r.URL.Query().Get("timeoutSeconds"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && time.Duration(v)*time.Second < timeout {
+			timeout = time.Duration(v) * time.Second
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	select {
+	case <-r.Context().Done():
+	case <-time.After(timeout):
+	}
+}
+
+func (c *Cluster) serveNodes(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("watch") == "true" {
+		c.serveWatch(w, r)
+		return
+	}
+	list := v1.NodeList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "NodeList"}, ListMeta: metav1.ListMeta{ResourceVersion: "1"}}
+	for n := 0; n < c.cfg.Nodes; n++ {
+		list.Items = append(list.Items, c.node(n))
+	}
+	writeJSON(w, list)
+}
+
+// servePods lists pods in node order, honouring limit/continue paging and a
+// spec.nodeName field selector.
+func (c *Cluster) servePods(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("watch") == "true" {
+		c.serveWatch(w, r)
+		return
+	}
+
+	first, last := 0, c.cfg.Nodes
+	if selector := q.Get("fieldSelector"); selector != "" {
+		name, ok := strings.CutPrefix(selector, "spec.nodeName=")
+		if !ok {
+			writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("unsupported field selector %q", selector))
+			return
+		}
+		first, last = 0, 0
+		var n int
+		if _, err := fmt.Sscanf(name, "sim-node-%05d", &n); err == nil && n < c.cfg.Nodes {
+			first, last = n, n+1
+		}
+	}
+	total := (last - first) * c.cfg.PodsPerNode
+	offset, _ := strconv.Atoi(q.Get("continue"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+
+	list := v1.PodList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PodList"}, ListMeta: metav1.ListMeta{ResourceVersion: "1"}}
+	for i := offset; i < end; i++ {
+		list.Items = append(list.Items, c.pod(first+i/c.cfg.PodsPerNode, i%c.cfg.PodsPerNode))
+	}
+	if end < total {
+		list.Continue = strconv.Itoa(end)
+	}
+	writeJSON(w, list)
+}
+
+func (c *Cluster) serveSummary(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if _, err := fmt.Sscanf(r.PathValue("node"), "sim-node-%05d", &n); err != nil || n >= c.cfg.Nodes {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("node %q not found", r.PathValue("node")))
+		return
+	}
+	if c.cfg.Latency > 0 {
+		time.Sleep(c.cfg.Latency)
+	}
+	if n < c.cfg.DeadNodes || (c.cfg.ErrorRate > 0 && rand.Float64() < c.cfg.ErrorRate) {
+		c.failures.Add(1)
+		writeStatus(w, http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable, "injected failure")
+		return
+	}
+	content, err := json.Marshal(c.Summary(n))
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+		return
+	}
+	if c.cfg.MalformedRate > 0 && rand.Float64() < c.cfg.MalformedRate {
+		c.failures.Add(1)
+		content = content[:len(content)/2]
+	}
+	c.summaries.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(content)
+}
+
+// WriteKubeconfig writes a kubeconfig pointing at the simulator on server.
+func WriteKubeconfig(path string, server string) error {
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: simulate
+  cluster:
+    server: %s
+contexts:
+- name: simulate
+  context:
+    cluster: simulate
+    user: simulate
+current-context: simulate
+users:
+- name: simulate
+  user: {}
+`, server)
+	return os.WriteFile(path, []byte(kubeconfig), 0o600)
+}
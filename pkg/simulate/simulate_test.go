@@ -0,0 +1,200 @@
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/jmcgrath207/k8s-ephemeral-storage-metrics/pkg/node"
+)
+
+func newTestCluster(t *testing.T, cfg Config) (*Cluster, *time.Time) {
+	t.Helper()
+	c, err := NewCluster(cfg)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	now := c.start
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestNewClusterValidation(t *testing.T) {
+	if _, err := NewCluster(Config{Nodes: 1, ContainersPerPod: 1, Pattern: "spiky"}); err == nil {
+		t.Error("expected error for unknown pattern")
+	}
+	if _, err := NewCluster(Config{Nodes: 0, ContainersPerPod: 1, Pattern: PatternFlat}); err == nil {
+		t.Error("expected error for no nodes")
+	}
+}
+
+func TestUsagePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []int64 // usage at 0s, 5s and 15s
+	}{
+		{PatternFlat, []int64{100, 100, 100}},
+		{PatternGrow, []int64{100, 150, 250}},
+		{PatternShrink, []int64{100, 50, 0}},
+		{PatternSawtooth, []int64{100, 150, 150}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			c, now := newTestCluster(t, Config{Nodes: 1, ContainersPerPod: 1, BaseBytes: 100, RateBytes: 10, Pattern: tt.pattern})
+			start := *now
+			for i, elapsed := range []time.Duration{0, 5 * time.Second, 15 * time.Second} {
+				*now = start.Add(elapsed)
+				if got := c.usage(0); got != tt.want[i] {
+					t.Errorf("usage after %v = %d, want %d", elapsed, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	c, _ := newTestCluster(t, Config{Nodes: 2, PodsPerNode: 3, ContainersPerPod: 2, CapacityBytes: 10000, BaseBytes: 100, Pattern: PatternFlat})
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/nodes/sim-node-00001/proxy/stats/summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var summary node.Summary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if summary.Node.NodeName != "sim-node-00001" || len(summary.Pods) != 3 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	p := summary.Pods[0]
+	// Containers are seeded with node+pod+container: 110 and 120 bytes.
+	if p.PodRef.Name != "sim-node-00001-pod-0000" || p.PodRef.Namespace != "sim-0" || p.EphemeralStorage.UsedBytes != 230 || len(p.Containers) != 2 {
+		t.Errorf("pod = %+v", p)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/v1/nodes/sim-node-00002/proxy/stats/summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown node: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestFailureInjection(t *testing.T) {
+	get := func(c *Cluster, node string) (int, []byte) {
+		rec := httptest.NewRecorder()
+		c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/nodes/"+node+"/proxy/stats/summary", nil))
+		body, _ := io.ReadAll(rec.Body)
+		return rec.Code, body
+	}
+	base := Config{Nodes: 2, PodsPerNode: 2, ContainersPerPod: 1, Pattern: PatternFlat}
+
+	cfg := base
+	cfg.DeadNodes = 1
+	c, _ := newTestCluster(t, cfg)
+	if code, _ := get(c, "sim-node-00000"); code != http.StatusServiceUnavailable {
+		t.Errorf("dead node: status = %d", code)
+	}
+	if code, _ := get(c, "sim-node-00001"); code != http.StatusOK {
+		t.Errorf("live node: status = %d", code)
+	}
+
+	cfg = base
+	cfg.ErrorRate = 1
+	c, _ = newTestCluster(t, cfg)
+	if code, _ := get(c, "sim-node-00001"); code != http.StatusServiceUnavailable {
+		t.Errorf("error rate: status = %d", code)
+	}
+
+	cfg = base
+	cfg.MalformedRate = 1
+	c, _ = newTestCluster(t, cfg)
+	_, body := get(c, "sim-node-00001")
+	var summary node.Summary
+	if err := json.Unmarshal(body, &summary); err == nil {
+		t.Error("malformed rate: expected a truncated summary")
+	}
+
+	cfg = base
+	cfg.DropPodRate = 1
+	c, _ = newTestCluster(t, cfg)
+	_, body = get(c, "sim-node-00001")
+	if err := json.Unmarshal(body, &summary); err != nil || len(summary.Pods) != 0 {
+		t.Errorf("drop pod rate: pods = %v, err = %v", summary.Pods, err)
+	}
+
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sim/stats", nil))
+	var stats map[string]int64
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || stats["summaries"] != 1 {
+		t.Errorf("stats = %v, err = %v", stats, err)
+	}
+}
+
+// TestInformers points client-go at the simulator through a generated
+// kubeconfig, the way the exporter runs against it.
+func TestInformers(t *testing.T) {
+	c, _ := newTestCluster(t, Config{Nodes: 3, PodsPerNode: 4, ContainersPerPod: 1, Pattern: PatternFlat, WatchTimeout: time.Second})
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := WriteKubeconfig(path, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := kubernetes.NewForConfigOrDie(config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{Limit: 5})
+	if err != nil {
+		t.Fatalf("list pods: %v", err)
+	}
+	if len(pods.Items) != 5 || pods.Continue == "" {
+		t.Errorf("first page: %d pods, continue %q", len(pods.Items), pods.Continue)
+	}
+	pods, err = client.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=sim-node-00002"})
+	if err != nil {
+		t.Fatalf("list pods on node: %v", err)
+	}
+	if len(pods.Items) != 4 || pods.Items[0].Name != "sim-node-00002-pod-0000" {
+		t.Errorf("pods on node = %d", len(pods.Items))
+	}
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	nodeInformer := factory.Core().V1().Nodes().Informer()
+	podInformer := factory.Core().V1().Pods().Informer()
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), nodeInformer.HasSynced, podInformer.HasSynced) {
+		t.Fatal("informers did not sync")
+	}
+	if n := len(nodeInformer.GetStore().List()); n != 3 {
+		t.Errorf("nodes = %d, want 3", n)
+	}
+	if n := len(podInformer.GetStore().List()); n != 12 {
+		t.Errorf("pods = %d, want 12", n)
+	}
+	cancel()
+	factory.Shutdown()
+}
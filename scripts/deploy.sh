@@ -62,8 +62,12 @@ function main() {
     "metrics.adjusted_polling_rate=true"
     "pprof=true"
     "prometheus.rules.enable=true"
+    "metrics.ephemeral_storage_node_image_fs=true"
     "metrics.ephemeral_storage_container_rootfs_usage=true"
     "metrics.ephemeral_storage_container_logs_usage=true"
+    "metrics.ephemeral_storage_container_logs_rotation=true"
+    "metrics.ephemeral_storage_thresholds=true"
+    "metrics.threshold_warn_percent=90"
   )
 
   if [[ $ENV =~ "e2e" ]]; then
@@ -121,7 +121,7 @@ func checkPrometheus(checkSlice []string, inverse bool) {
 
 func WatchContainerPercentage() {
 	status := 0
-	re := regexp.MustCompile(`ephemeral_storage_container_limit_percentage{container="grow-test",node_name="minikube".+,pod_namespace="ephemeral-metrics",source="container"}\s+(.+)`)
+	re := regexp.MustCompile(`ephemeral_storage_container_limit_percentage{container="grow-test",container_type="container",node_name="minikube".+,pod_namespace="ephemeral-metrics",source="container"}\s+(.+)`)
 	timeout := time.Second * 180
 	startTime := time.Now()
 	for {
@@ -145,7 +145,7 @@ func WatchContainerPercentage() {
 
 func WatchContainerVolumePercentage() {
 	status := 0
-	re := regexp.MustCompile(`ephemeral_storage_container_volume_limit_percentage{container="shrink-test",mount_path="\/cache".+volume_name="cache-volume-1"}\s+(.+)`)
+	re := regexp.MustCompile(`ephemeral_storage_container_volume_limit_percentage{container="shrink-test",container_type="container",mount_path="\/cache".+volume_name="cache-volume-1"}\s+(.+)`)
 	timeout := time.Second * 180
 	startTime := time.Now()
 	for {
@@ -241,7 +241,7 @@ func getContainerLimitPercentage(podName string) float64 {
 func getContainerVolumeLimitPercentage(podName string) float64 {
 	output := requestPrometheusString()
 	re := regexp.MustCompile(
-		fmt.Sprintf(`ephemeral_storage_container_volume_limit_percentage.+container="%s",mount_path="\/cache".+\}\s(.+)`,
+		fmt.Sprintf(`ephemeral_storage_container_volume_limit_percentage.+container="%s",container_type="container",mount_path="\/cache".+\}\s(.+)`,
 			podName))
 	match := re.FindAllStringSubmatch(output, 2)
 	if match == nil {
@@ -254,7 +254,7 @@ func getContainerVolumeLimitPercentage(podName string) float64 {
 func getContainerVolumeUsage(podName string) float64 {
 	output := requestPrometheusString()
 	re := regexp.MustCompile(
-		fmt.Sprintf(`ephemeral_storage_container_volume_usage.+container="%s",mount_path="\/cache".+\}\s(.+)`,
+		fmt.Sprintf(`ephemeral_storage_container_volume_usage.+container="%s",container_type="container",mount_path="\/cache".+\}\s(.+)`,
 			podName))
 	match := re.FindAllStringSubmatch(output, 2)
 	if match == nil {
@@ -374,7 +374,7 @@ func nodeReconnect() {
 
 var scalingCheckSlice = []string{
 	"node_name=\"minikube-m02",
-	"ephemeral_storage_container_limit_percentage{container=\"kube-proxy\",node_name=\"minikube-m02\"",
+	"ephemeral_storage_container_limit_percentage{container=\"kube-proxy\",container_type=\"container\",node_name=\"minikube-m02\"",
 	"ephemeral_storage_inodes{node_name=\"minikube-m02\"",
 	"ephemeral_storage_inodes_free{node_name=\"minikube-m02\"",
 	"ephemeral_storage_inodes_used{node_name=\"minikube-m02\"",
@@ -396,12 +396,20 @@ var _ = ginkgo.Describe("Test Metrics\n", func() {
 				"ephemeral_storage_node_available",
 				"ephemeral_storage_node_capacity",
 				"ephemeral_storage_node_percentage",
+				"ephemeral_storage_node_image_fs_used_bytes",
+				"ephemeral_storage_node_scrape_stale",
+				"ephemeral_storage_sample_age_seconds",
 				"ephemeral_storage_container_limit_percentage",
 				"ephemeral_storage_container_volume_limit_percentage",
 				"ephemeral_storage_container_volume_usage",
 				"ephemeral_storage_inodes",
 				"ephemeral_storage_inodes_free",
 				"ephemeral_storage_inodes_used",
+				"ephemeral_storage_pod_limit_bytes",
+				"ephemeral_storage_pod_limit_percentage",
+				"ephemeral_storage_pod_phase",
+				"ephemeral_storage_threshold_percentage",
+				"ephemeral_storage_threshold_breach",
 				"ephemeral_storage_container_rootfs_used_bytes",
 				"ephemeral_storage_container_rootfs_available_bytes",
 				"ephemeral_storage_container_rootfs_capacity_bytes",
@@ -416,6 +424,8 @@ var _ = ginkgo.Describe("Test Metrics\n", func() {
 				"ephemeral_storage_container_logs_inodes",
 				"ephemeral_storage_container_logs_inodes_free",
 				"ephemeral_storage_container_logs_inodes_used",
+				"ephemeral_storage_container_logs_rotation_percentage",
+				"ephemeral_storage_container_logs_rotation_outpaced",
 			)
 			checkPrometheus(checkSlice, false)
 		})
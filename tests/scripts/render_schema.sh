@@ -0,0 +1,42 @@
+#!/bin/bash
+# Renders the chart with each metric schema and checks the PrometheusRules
+# and ServiceMonitor query the label names the exporter exports with it.
+set -euo pipefail
+
+render() {
+  helm template ./chart -f ./chart/test-values.yaml \
+    --set metrics.schema="$1" \
+    --set prometheus.rules.enable=true \
+    --set metrics.ephemeral_storage_thresholds=true
+}
+
+check() {
+  local schema=$1 honor_labels=$2
+  shift 2
+  local out
+  out=$(render "$schema")
+  for want in "$@"; do
+    if ! grep -qF -- "$want" <<<"$out"; then
+      echo "schema $schema: missing \"$want\"" >&2
+      exit 1
+    fi
+  done
+  if [[ $(grep -c "honorLabels: true" <<<"$out") -ne $honor_labels ]]; then
+    echo "schema $schema: want honorLabels set $honor_labels times" >&2
+    exit 1
+  fi
+}
+
+check v1 0 \
+  "max by (node_name, pod_namespace, pod_name, exported_container)" \
+  "max by (node_name, pod_namespace, pod_name, level)" \
+  "{{ \$labels.pod_name }}"
+check v2 1 \
+  "max by (node, namespace, pod, container)" \
+  "max by (node, namespace, pod, level)" \
+  "{{ \$labels.pod }}"
+# both keeps every v1 family with its v1 labels, only container is honored.
+check both 1 \
+  "max by (node_name, pod_namespace, pod_name, container)" \
+  "max by (node_name, pod_namespace, pod_name, level)" \
+  "{{ \$labels.pod_name }}"